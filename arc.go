@@ -2,7 +2,9 @@ package gcache
 
 import (
 	"container/list"
+	"context"
 	"errors"
+	"io"
 	"time"
 )
 
@@ -25,8 +27,10 @@ type ARC struct {
 }
 
 type arcItem struct {
+	expiry
 	key     interface{}
 	value   interface{}
+	weight  int64
 	parent  *list.List
 	element *list.Element
 	ghost   bool
@@ -38,24 +42,37 @@ func (c *ARC) init() {
 	c.t2 = list.New()
 	c.b1 = list.New()
 	c.b2 = list.New()
+	c.split = 0
+	c.size = 0
 }
 
 func newARC(cb *CacheBuilder) *ARC {
 	c := &ARC{}
 	buildCache(&c.baseCache, cb)
+	if cb.bufferedReads {
+		c.reads = newReadBuffer(c.applyRead)
+	}
 	c.loadGroup.cache = c
 	c.init()
+	c.startJanitor(c, cb.cleanupInterval)
+	c.startMemoryController(c, cb.memoryPressure)
+	c.startCheckpointer(c, cb.persistence)
 	return c
 }
 
 func (c *ARC) set(key, value interface{}) (interface{}, error) {
-	var err error
-	if c.serializeFunc != nil {
-		value, err = c.serializeFunc(key, value)
-		if err != nil {
-			return nil, err
-		}
+	value, w, err := c.prepare(key, value)
+	if err != nil {
+		return nil, err
 	}
+	return c.insert(key, value, w), nil
+}
+
+// insert stores value, prepared with its weight w.
+func (c *ARC) insert(key, value interface{}, w int64) interface{} {
+	c.drainReads()
+	c.forgetHeld(key)
+	c.makeRoom(c, key, w)
 
 	if c.addedFunc != nil {
 		defer c.addedFunc(key, value)
@@ -66,40 +83,92 @@ func (c *ARC) set(key, value interface{}) (interface{}, error) {
 		c.size++
 
 		entry = &arcItem{
-			key:   key,
-			value: value,
+			expiry: expiry{clock: c.clock},
+			key:    key,
+			value:  value,
 		}
 
 		c.request(entry)
 		c.store[key] = entry
-		return entry, nil
-	}
+	} else {
+		if entry.ghost {
+			c.size++
+		} else {
+			c.removed(key, entry.value, nil, RemovalReplaced)
+		}
 
-	if entry.ghost {
-		c.size++
+		entry.value = value
+		entry.ghost = false
+		c.request(entry)
 	}
+	c.addWeight(w - entry.weight)
+	entry.weight = w
 
-	entry.value = value
-	entry.ghost = false
-	c.request(entry)
-	return entry, nil
+	c.expireAfterWrite(key, &entry.expiry)
+
+	return entry
 }
 
 func (c *ARC) Set(key, value interface{}) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	_, err := c.set(key, value)
-	return err
+
+	stored, w, err := c.prepare(key, value)
+	if err != nil {
+		return err
+	}
+	if err := c.beforeSet(key, value); err != nil {
+		return err
+	}
+	c.insert(key, stored, w)
+	return nil
 }
 
 func (c *ARC) SetWithExpire(key, value interface{}, expiration time.Duration) error {
-	return c.Set(key, value)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, w, err := c.prepare(key, value)
+	if err != nil {
+		return err
+	}
+	if err := c.beforeSetWithExpire(key, value, expiration); err != nil {
+		return err
+	}
+	item := c.insert(key, stored, w)
+
+	c.expireAt(key, &item.(*arcItem).expiry, expiration)
+	return nil
+}
+
+func (c *ARC) SetWithExpireAfterAccess(key, value interface{}, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, w, err := c.prepare(key, value)
+	if err != nil {
+		return err
+	}
+	if err := c.beforeSetWithExpireAfterAccess(key, value, expiration); err != nil {
+		return err
+	}
+	item := c.insert(key, stored, w)
+
+	c.expireAfterAccess(key, &item.(*arcItem).expiry, expiration)
+	return nil
 }
 
 func (c *ARC) get(key interface{}, onLoad bool) (interface{}, error) {
 	entry, exists := c.store[key]
-	if !exists {
-		// Ugly. This needs to go.
+	if !exists || entry.ghost {
+		if !onLoad {
+			c.stats.IncrMissCount()
+		}
+		return nil, KeyNotFoundError
+	}
+
+	if entry.isExpired(nil) {
+		c.removeResident(entry, RemovalExpired)
 		if !onLoad {
 			c.stats.IncrMissCount()
 		}
@@ -107,6 +176,11 @@ func (c *ARC) get(key interface{}, onLoad bool) (interface{}, error) {
 	}
 
 	c.request(entry)
+	if !onLoad {
+		c.stats.IncrHitCount()
+		c.accessed(key, &entry.expiry)
+		c.refreshIfStale(key, &entry.expiry)
+	}
 
 	if c.deserializeFunc != nil {
 		return c.deserializeFunc(key, entry.value)
@@ -115,62 +189,97 @@ func (c *ARC) get(key interface{}, onLoad bool) (interface{}, error) {
 	return entry.value, nil
 }
 
-func (c *ARC) getWithLoader(key interface{}, isWait bool) (interface{}, error) {
+func (c *ARC) storeEntry(key, value interface{}, expiration, accessTTL *time.Duration) error {
+	item, err := c.set(key, value)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case expiration != nil:
+		c.expireAt(key, &item.(*arcItem).expiry, *expiration)
+	case accessTTL != nil:
+		c.expireAfterAccess(key, &item.(*arcItem).expiry, *accessTTL)
+	}
+	return nil
+}
+
+func (c *ARC) removeEntry(key interface{}) {
+	c.remove(key)
+}
+
+func (c *ARC) expiryOf(key interface{}) (*expiry, bool) {
+	if item, ok := c.store[key]; ok && !item.ghost {
+		return &item.expiry, true
+	}
+	return nil, false
+}
+
+func (c *ARC) getWithLoader(ctx context.Context, key interface{}, isWait bool) (interface{}, error) {
 	if c.loaderExpireFunc == nil {
 		return nil, KeyNotFoundError
 	}
 
-	value, _, err := c.load(key, func(v interface{}, expiration *time.Duration, e error) (interface{}, error) {
+	value, _, err := c.load(ctx, key, func(v interface{}, expiration *time.Duration, e error) (interface{}, error) {
 		if e != nil {
 			return nil, e
 		}
 
-		err := c.Set(key, v)
-		if err != nil {
+		if err := c.setLoaded(key, v, expiration); err != nil {
 			return nil, err
 		}
-
 		return v, nil
 	}, isWait)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return value, nil
+	return value, err
 }
 
 func (c *ARC) Get(key interface{}) (interface{}, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *ARC) GetContext(ctx context.Context, key interface{}) (interface{}, error) {
+	if v, ok := c.getShared(key); ok {
+		return c.deserialize(key, v)
+	}
 	c.mu.Lock()
 	v, err := c.get(key, false)
 	c.mu.Unlock()
 	if err == KeyNotFoundError {
-		return c.getWithLoader(key, true)
+		return c.getWithLoader(ctx, key, true)
 	}
 	return v, err
 }
 
 func (c *ARC) GetIFPresent(key interface{}) (interface{}, error) {
+	if v, ok := c.getShared(key); ok {
+		return c.deserialize(key, v)
+	}
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	v, err := c.get(key, false)
+	c.mu.Unlock()
+
 	if err == KeyNotFoundError {
-		return c.getWithLoader(key, false)
+		return c.getWithLoader(context.Background(), key, false)
 	}
 
 	return v, err
 }
 
+// cached returns the resident, unexpired entries of t1 and t2.
 func (c *ARC) cached() []interface{} {
 	cached := make([]interface{}, 0)
+	now := c.clock.Now()
 
 	for e := c.t1.Front(); e != nil; e = e.Next() {
-		cached = append(cached, e.Value.(*arcItem))
+		if !e.Value.(*arcItem).isExpired(&now) {
+			cached = append(cached, e.Value.(*arcItem))
+		}
 	}
 
 	for e := c.t2.Front(); e != nil; e = e.Next() {
-		cached = append(cached, e.Value.(*arcItem))
+		if !e.Value.(*arcItem).isExpired(&now) {
+			cached = append(cached, e.Value.(*arcItem))
+		}
 	}
 
 	return cached
@@ -184,7 +293,15 @@ func (c *ARC) GetALL() map[interface{}]interface{} {
 
 	cached := c.cached()
 	for _, elt := range cached {
-		storeActual[elt.(*arcItem).key] = elt.(*arcItem).value
+		key, value := elt.(*arcItem).key, elt.(*arcItem).value
+		if c.deserializeFunc != nil {
+			v, err := c.deserializeFunc(key, value)
+			if err != nil {
+				continue
+			}
+			value = v
+		}
+		storeActual[key] = value
 	}
 
 	return storeActual
@@ -196,23 +313,44 @@ func (c *ARC) remove(key interface{}) error {
 		return KeyNotFoundError
 	}
 
-	if elt.parent != nil {
+	if elt.ghost {
 		elt.parent.Remove(elt.element)
+		delete(c.store, elt.key)
+		return KeyNotFoundError
 	}
 
-	if c.evictedFunc != nil {
-		c.evictedFunc(key, elt.value)
+	c.removeResident(elt, RemovalExplicit)
+	return nil
+}
+
+// removeResident drops an entry of t1 or t2 without turning it into a ghost,
+// leaving the adaptation state (split, b1, b2) untouched.
+func (c *ARC) removeResident(elt *arcItem, cause RemovalCause) {
+	if elt.parent != nil {
+		elt.parent.Remove(elt.element)
 	}
 
 	delete(c.store, elt.key)
 	c.size--
-	return nil
+
+	c.addWeight(-elt.weight)
+	c.removed(elt.key, elt.value, &elt.expiry, cause)
+}
+
+func (c *ARC) expire(key interface{}, now time.Time) {
+	if entry, ok := c.store[key]; ok && !entry.ghost && entry.isExpired(&now) {
+		c.removeResident(entry, RemovalExpired)
+	}
 }
 
 func (c *ARC) Remove(key interface{}) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if err := c.beforeRemove(key); err != nil {
+		return err
+	}
+	c.forgetHeld(key)
 	return c.remove(key)
 }
 
@@ -220,20 +358,27 @@ func (c *ARC) Purge() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.purgeVisitorFunc != nil {
+	c.logPurge()
+	c.purgeHeld()
+	c.purge()
+}
+
+func (c *ARC) purge() {
+	if c.purgeVisitorFunc != nil || c.listensRemovals() {
 		for elt := c.t1.Front(); elt != nil; elt = elt.Next() {
 			entry := elt.Value.(*arcItem)
-			c.purgeVisitorFunc(entry.key, entry.value)
+			c.purged(entry.key, entry.value)
 		}
 
 		for elt := c.t2.Front(); elt != nil; elt = elt.Next() {
 			entry := elt.Value.(*arcItem)
-			c.purgeVisitorFunc(entry.key, entry.value)
+			c.purged(entry.key, entry.value)
 		}
 	}
 
+	c.resetExpirations()
+	c.resetWeight()
 	c.init()
-
 }
 
 func (c *ARC) Keys() []interface{} {
@@ -249,24 +394,39 @@ func (c *ARC) Keys() []interface{} {
 }
 
 func (c *ARC) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.size
 }
 
-func (c *ARC) removeLRU(l *list.List) {
+func (c *ARC) Resize(capacity int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.setCapacity(c, capacity)
+}
+
+func (c *ARC) removeLRU(l *list.List, cause RemovalCause) {
 	lru := l.Back()
-	if c.evictedFunc != nil {
-		defer c.evictedFunc(lru.Value.(*arcItem).key, lru.Value.(*arcItem).value)
+	if lru == nil {
+		return
 	}
+	entry := lru.Value.(*arcItem)
 
 	l.Remove(lru)
-	delete(c.store, lru.Value.(*arcItem).key)
+	delete(c.store, entry.key)
+	if entry.ghost {
+		return
+	}
+
 	c.size--
+	c.addWeight(-entry.weight)
+	c.removed(entry.key, entry.value, &entry.expiry, cause)
 }
 
 func (c *ARC) request(e *arcItem) error {
 	var delta int
 	if e.parent == c.t1 || e.parent == c.t2 {
-		c.stats.IncrHitCount()
 		e.setMRU(c.t2)
 		return nil
 	}
@@ -308,15 +468,15 @@ func (c *ARC) request(e *arcItem) error {
 
 	if l1Len == c.capacity {
 		if c.t1.Len() < c.capacity {
-			c.removeLRU(c.b1)
+			c.removeLRU(c.b1, RemovalSize)
 			c.replace(e)
 		} else {
-			c.removeLRU(c.t1)
+			c.removeLRU(c.t1, RemovalSize)
 		}
 	} else {
 		if l1Len+l2Len >= c.capacity {
 			if l1Len+l2Len == 2*c.capacity {
-				c.removeLRU(c.b2)
+				c.removeLRU(c.b2, RemovalSize)
 			}
 			c.replace(e)
 		}
@@ -337,24 +497,69 @@ func (e *arcItem) setMRU(l *list.List) {
 }
 
 func (c *ARC) replace(e *arcItem) {
+	// Expired entries may have left room in t1/t2, in which case nothing
+	// needs to be pushed out to make space for e.
+	if c.t1.Len()+c.t2.Len() < c.capacity {
+		return
+	}
+	c.demote(e, RemovalSize)
+}
+
+// demote turns the LRU entry of t1 or t2 into a ghost, and reports false if
+// both are empty. e is the entry room is made for, if any.
+func (c *ARC) demote(e *arcItem, cause RemovalCause) bool {
 	var lru *arcItem
 	var target *list.List
-	if c.t1.Len() > 0 && (c.t1.Len() > c.split) || (e.parent == c.b2 && c.t1.Len() == c.split) {
+	if c.t1.Len() > 0 && (c.t1.Len() > c.split || (e != nil && e.parent == c.b2 && c.t1.Len() == c.split) || c.t2.Len() == 0) {
 		lru = c.t1.Back().Value.(*arcItem)
 		target = c.b1
-	} else {
+	} else if c.t2.Len() > 0 {
 		lru = c.t2.Back().Value.(*arcItem)
 		target = c.b2
+	} else {
+		return false
 	}
 
-	if c.evictedFunc != nil {
-		defer c.evictedFunc(lru.key, lru.value)
-	}
+	// The ghost loses its expiration.
+	exp := lru.expiry
+	defer c.removed(lru.key, lru.value, &exp, cause)
 
+	c.addWeight(-lru.weight)
+	lru.weight = 0
 	lru.value = nil
 	lru.ghost = true
+	lru.expiration = nil
 	lru.setMRU(target)
 	c.size--
+	return true
+}
+
+func (c *ARC) weightOf(key interface{}) int64 {
+	if entry, ok := c.store[key]; ok {
+		return entry.weight
+	}
+	return 0
+}
+
+func (c *ARC) evictOne(cause RemovalCause) bool {
+	return c.demote(nil, cause)
+}
+
+// resize scales split so that the learned balance between recency and
+// frequency is kept, demotes residents until at most capacity remain, then
+// trims the ghost lists back within the bounds of the new capacity:
+// t1+b1 <= c and t1+t2+b1+b2 <= 2c.
+func (c *ARC) resize(capacity int) {
+	c.split = minInt(c.split*capacity/c.capacity, capacity)
+	c.capacity = capacity
+	for c.t1.Len()+c.t2.Len() > capacity && c.demote(nil, RemovalResized) {
+	}
+	for c.t1.Len()+c.b1.Len() > capacity && c.b1.Len() > 0 {
+		c.removeLRU(c.b1, RemovalResized)
+	}
+	for c.t1.Len()+c.t2.Len()+c.b1.Len()+c.b2.Len() > 2*capacity && c.b2.Len() > 0 {
+		c.removeLRU(c.b2, RemovalResized)
+	}
 }
 
 func (c *ARC) Debug() map[string][]int {
@@ -363,6 +568,95 @@ func (c *ARC) Debug() map[string][]int {
 	return d
 }
 
+// getShared serves a hit under the shared lock when BufferedReads is set.
+func (c *ARC) getShared(key interface{}) (interface{}, bool) {
+	if c.reads == nil {
+		return nil, false
+	}
+	c.mu.RLock()
+	item, ok := c.store[key]
+	if !ok || item.ghost {
+		c.mu.RUnlock()
+		return nil, false
+	}
+	if !c.sharedRead(&item.expiry) {
+		c.mu.RUnlock()
+		return nil, false
+	}
+	v := item.value
+	c.mu.RUnlock()
+
+	c.stats.IncrHitCount()
+	c.recordRead(item)
+	return v, true
+}
+
+// applyRead replays a read buffered by getShared.
+func (c *ARC) applyRead(v interface{}) {
+	item := v.(*arcItem)
+	if c.store[item.key] == item && !item.ghost {
+		c.request(item)
+	}
+}
+
+// Snapshot includes the keys of the ghost lists.
+func (c *ARC) Snapshot(w io.Writer) error {
+	return c.snapshot(c, TYPE_ARC, w)
+}
+
+func (c *ARC) Restore(r io.Reader) error {
+	return c.restore(c, TYPE_ARC, r)
+}
+
+// lists are numbered by SnapshotEntry.Segment, the ghost lists come last.
+func (c *ARC) lists() []*list.List {
+	return []*list.List{c.t1, c.t2, c.b1, c.b2}
+}
+
+func (c *ARC) exportEntries(at time.Time) ([]SnapshotEntry, int) {
+	entries := make([]SnapshotEntry, 0, len(c.store))
+	for segment, l := range c.lists() {
+		for e := l.Back(); e != nil; e = e.Prev() {
+			item := e.Value.(*arcItem)
+			entry := SnapshotEntry{Key: item.key, Segment: segment, Ghost: item.ghost}
+			if !item.ghost {
+				entry.Value = item.value
+				item.export(at, &entry)
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, c.split
+}
+
+func (c *ARC) importEntries(at time.Time, entries []SnapshotEntry, split int) {
+	lists := c.lists()
+	for i := range entries {
+		e := &entries[i]
+		if e.Segment < 0 || e.Segment >= len(lists) || e.Ghost != (e.Segment >= 2) {
+			continue
+		}
+		item := &arcItem{
+			expiry: expiry{clock: c.clock},
+			key:    e.Key,
+			ghost:  e.Ghost,
+		}
+		if !e.Ghost {
+			w, ok := c.importWeight(e)
+			if !ok {
+				continue
+			}
+			item.value = e.Value
+			item.weight = w
+			item.restore(at, e)
+			c.size++
+		}
+		item.setMRU(lists[e.Segment])
+		c.store[e.Key] = item
+	}
+	c.split = minInt(maxInt(split, 0), c.capacity)
+}
+
 func (c *ARC) unsafeGet(key interface{}, onLoad bool) (interface{}, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -3,6 +3,7 @@ package gcache
 import (
 	"fmt"
 	"testing"
+	"time"
 )
 
 func buildARCache(size int) (Cache, error) {
@@ -44,3 +45,92 @@ func TestLoadingARCGet(t *testing.T) {
 	}
 	testGetCache(t, gc, numbers)
 }
+
+func TestARCGetIFPresent(t *testing.T) {
+	testGetIFPresent(t, TYPE_ARC)
+}
+
+func TestARCGetALL(t *testing.T) {
+	testGetALL(t, TYPE_ARC)
+}
+
+func TestARCExpiration(t *testing.T) {
+	clock := NewFakeClock()
+	var evicted []interface{}
+	gc, err := New(4).
+		ARC().
+		Clock(clock).
+		Expiration(time.Second).
+		EvictedFunc(func(key, value interface{}) {
+			evicted = append(evicted, key)
+		}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gc.Set("t1", 1)
+	gc.Set("t2", 2)
+	gc.Get("t2")
+	gc.SetWithExpire("long", 3, time.Minute)
+	clock.Advance(2 * time.Second)
+
+	for _, key := range []string{"t1", "t2"} {
+		if _, err := gc.Get(key); err != KeyNotFoundError {
+			t.Errorf("%v should have expired, err is %v", key, err)
+		}
+	}
+	if v, err := gc.Get("long"); err != nil || v != 3 {
+		t.Errorf("Get(long) = %v, %v", v, err)
+	}
+	if len(evicted) != 2 {
+		t.Errorf("%v != %v", len(evicted), 2)
+	}
+	if gc.Len() != 1 {
+		t.Errorf("%v != %v", gc.Len(), 1)
+	}
+
+	// Expired entries are dropped, not turned into ghosts.
+	d := gc.Debug()["arc"]
+	if d[3] != 0 || d[5] != 0 {
+		t.Errorf("ghost lists should be empty: %v", d)
+	}
+	if keys := gc.Keys(); len(keys) != 1 || keys[0] != "long" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
+
+func TestARCGhostIsMiss(t *testing.T) {
+	size := 2
+	gc, err := New(size).ARC().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gc.Set(0, 0)
+	gc.Set(1, 1)
+	gc.Get(1)
+	gc.Set(2, 2)
+	// Key 0 was pushed out to the b1 ghost list.
+	if d := gc.Debug()["arc"]; d[3] != 1 {
+		t.Fatalf("expected one ghost in b1: %v", d)
+	}
+	if _, err := gc.Get(0); err != KeyNotFoundError {
+		t.Errorf("err should be KeyNotFoundError, not %v", err)
+	}
+	if gc.MissCount() != 1 || gc.HitCount() != 1 {
+		t.Errorf("hits: %v, misses: %v", gc.HitCount(), gc.MissCount())
+	}
+	if gc.Len() != size {
+		t.Errorf("%v != %v", gc.Len(), size)
+	}
+
+	// Reinserting a ghost is a ghost hit that moves the key to t2.
+	gc.Set(0, 0)
+	if v, err := gc.Get(0); err != nil || v != 0 {
+		t.Errorf("Get(0) = %v, %v", v, err)
+	}
+	if gc.Len() != size {
+		t.Errorf("%v != %v", gc.Len(), size)
+	}
+}
@@ -0,0 +1,125 @@
+package gcache
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// GetMany returns the values of keys, loading the missing ones.
+// With a BatchLoaderFunc, all missing keys that aren't already being loaded
+// are fetched by a single call to it; otherwise they are loaded one by one.
+// Keys that could not be resolved are reported in the error map. A stale
+// value served by StaleIfError is in both maps, along with its *StaleError.
+func (c *baseCache) GetMany(keys []interface{}) (map[interface{}]interface{}, map[interface{}]error) {
+	cache := c.loadGroup.cache
+	values := make(map[interface{}]interface{}, len(keys))
+	errs := make(map[interface{}]error)
+
+	var missing []interface{}
+	seen := make(map[interface{}]struct{}, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		v, err := cache.unsafeGet(key, false)
+		switch err {
+		case nil:
+			values[key] = v
+		case KeyNotFoundError:
+			missing = append(missing, key)
+		default:
+			errs[key] = err
+		}
+	}
+	if len(missing) == 0 {
+		return values, errs
+	}
+
+	if c.batchLoaderFunc == nil {
+		for _, key := range missing {
+			v, err := c.loadOne(context.Background(), key)
+			addResult(values, errs, key, v, err)
+		}
+		return values, errs
+	}
+
+	batch := missing[:0]
+	for _, key := range missing {
+		if err, ok := c.negativeHit(key); ok {
+			v, _, err := c.staleIfError(key, err, false)
+			addResult(values, errs, key, v, err)
+			continue
+		}
+		batch = append(batch, key)
+	}
+	if len(batch) == 0 {
+		return values, errs
+	}
+
+	loaded, loadErrs := c.loadGroup.doMany(context.Background(), batch, c.loadBatch)
+	for key, v := range loaded {
+		values[key] = v
+	}
+	for key, err := range loadErrs {
+		var v interface{}
+		if le, ok := err.(*loaderError); ok {
+			v, _, err = c.staleIfError(key, le.err, true)
+		}
+		addResult(values, errs, key, v, err)
+	}
+	return values, errs
+}
+
+// addResult adds the result of loading key to the maps of GetMany.
+func addResult(values map[interface{}]interface{}, errs map[interface{}]error, key, v interface{}, err error) {
+	if err == nil {
+		values[key] = v
+		return
+	}
+	if _, ok := err.(*StaleError); ok {
+		values[key] = v
+	}
+	errs[key] = err
+}
+
+// loadOne loads key through the loader and stores it in the cache.
+func (c *baseCache) loadOne(ctx context.Context, key interface{}) (interface{}, error) {
+	if c.loaderExpireFunc == nil {
+		return nil, KeyNotFoundError
+	}
+	v, _, err := c.load(ctx, key, func(v interface{}, expiration *time.Duration, e error) (interface{}, error) {
+		if e != nil {
+			return nil, e
+		}
+		return v, c.setLoaded(key, v, expiration)
+	}, true)
+	return v, err
+}
+
+// loadBatch calls the batch loader and stores every value it returned. Its
+// errors are cached for every key, like the errors of the loader in load.
+func (c *baseCache) loadBatch(ctx context.Context, keys []interface{}) (values map[interface{}]interface{}, e error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e = fmt.Errorf("Loader panics: %v", r)
+		}
+	}()
+
+	loaded, err := c.callBatchLoader(ctx, keys)
+	if err != nil {
+		for _, key := range keys {
+			c.cacheError(key, err)
+		}
+		return nil, &loaderError{err}
+	}
+	values = make(map[interface{}]interface{}, len(loaded))
+	for key, v := range loaded {
+		if err := c.setLoaded(key, v, nil); err == nil {
+			values[key] = v
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,238 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+type batchRecorder struct {
+	mu      sync.Mutex
+	batches [][]interface{}
+}
+
+func (r *batchRecorder) record(keys []interface{}) {
+	sorted := append([]interface{}(nil), keys...)
+	sort.Slice(sorted, func(i, j int) bool { return fmt.Sprint(sorted[i]) < fmt.Sprint(sorted[j]) })
+	r.mu.Lock()
+	r.batches = append(r.batches, sorted)
+	r.mu.Unlock()
+}
+
+func (r *batchRecorder) get() [][]interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]interface{}(nil), r.batches...)
+}
+
+func TestGetManyBatchLoader(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		var rec batchRecorder
+		gc, err := New(16).
+			EvictType(tp).
+			BatchLoaderFunc(func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+				rec.record(keys)
+				m := make(map[interface{}]interface{})
+				for _, k := range keys {
+					if k != "missing" {
+						m[k] = "loaded-" + k.(string)
+					}
+				}
+				return m, nil
+			}).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gc.Set("a", "cached-a")
+		values, errs := gc.GetMany([]interface{}{"a", "b", "c", "b", "missing"})
+
+		if len(values) != 3 || values["a"] != "cached-a" || values["b"] != "loaded-b" || values["c"] != "loaded-c" {
+			t.Errorf("%s: unexpected values %v", tp, values)
+		}
+		if len(errs) != 1 || errs["missing"] != KeyNotFoundError {
+			t.Errorf("%s: unexpected errors %v", tp, errs)
+		}
+		batches := rec.get()
+		if len(batches) != 1 || fmt.Sprint(batches[0]) != "[b c missing]" {
+			t.Errorf("%s: unexpected batches %v", tp, batches)
+		}
+
+		// Loaded values were stored, and single-key loads use the batch loader.
+		if v, err := gc.GetIFPresent("b"); err != nil || v != "loaded-b" {
+			t.Errorf("%s: GetIFPresent(b) = %v, %v", tp, v, err)
+		}
+		if v, err := gc.Get("d"); err != nil || v != "loaded-d" {
+			t.Errorf("%s: Get(d) = %v, %v", tp, v, err)
+		}
+	}
+}
+
+func TestGetManyBatchLoaderError(t *testing.T) {
+	loaderErr := errors.New("backend down")
+	gc, err := New(16).
+		LRU().
+		BatchLoaderFunc(func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+			return nil, loaderErr
+		}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, errs := gc.GetMany([]interface{}{1, 2})
+	if len(values) != 0 {
+		t.Errorf("unexpected values %v", values)
+	}
+	if len(errs) != 2 || errs[1] != loaderErr || errs[2] != loaderErr {
+		t.Errorf("unexpected errors %v", errs)
+	}
+}
+
+func TestGetManyDeduplicatesInFlightLoads(t *testing.T) {
+	var rec batchRecorder
+	release := make(chan struct{})
+	singleStarted := make(chan struct{})
+	gc, err := New(16).
+		LRU().
+		LoaderFunc(func(key interface{}) (interface{}, error) {
+			close(singleStarted)
+			<-release
+			return "single-" + key.(string), nil
+		}).
+		BatchLoaderFunc(func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+			rec.record(keys)
+			<-release
+			m := make(map[interface{}]interface{})
+			for _, k := range keys {
+				m[k] = "batch-" + k.(string)
+			}
+			return m, nil
+		}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if v, err := gc.Get("a"); err != nil || v != "single-a" {
+			t.Errorf("Get(a) = %v, %v", v, err)
+		}
+	}()
+	<-singleStarted
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		values, errs := gc.GetMany([]interface{}{"a", "b", "c"})
+		if len(errs) != 0 || values["a"] != "single-a" || values["b"] != "batch-b" || values["c"] != "batch-c" {
+			t.Errorf("unexpected result %v, %v", values, errs)
+		}
+	}()
+	waitFor(t, func() bool { return len(rec.get()) == 1 })
+
+	// A single-key Get for a key of the in-flight batch waits on the batch.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if v, err := gc.Get("b"); err != nil || v != "batch-b" {
+			t.Errorf("Get(b) = %v, %v", v, err)
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	close(release)
+	wg.Wait()
+
+	if batches := rec.get(); len(batches) != 1 || fmt.Sprint(batches[0]) != "[b c]" {
+		t.Errorf("unexpected batches %v", batches)
+	}
+}
+
+func TestGetManyWithoutBatchLoader(t *testing.T) {
+	gc, err := New(16).
+		LFU().
+		LoaderFunc(func(key interface{}) (interface{}, error) {
+			if key == 3 {
+				return nil, KeyNotFoundError
+			}
+			return key.(int) * 10, nil
+		}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, errs := gc.GetMany([]interface{}{1, 2, 3})
+	if len(values) != 2 || values[1] != 10 || values[2] != 20 {
+		t.Errorf("unexpected values %v", values)
+	}
+	if len(errs) != 1 || errs[3] != KeyNotFoundError {
+		t.Errorf("unexpected errors %v", errs)
+	}
+
+	// Without any loader, GetMany only returns what is cached.
+	plain, err := New(16).LRU().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain.Set(1, 1)
+	values, errs = plain.GetMany([]interface{}{1, 2})
+	if len(values) != 1 || len(errs) != 1 {
+		t.Errorf("unexpected result %v, %v", values, errs)
+	}
+}
+
+func TestGetManyBatchLoaderGuards(t *testing.T) {
+	var calls int
+	down := false
+	clock := NewFakeClock()
+	gc := mustBuild(t, New(16).LRU().Clock(clock).
+		Expiration(time.Minute).
+		BatchLoaderFunc(func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+			calls++
+			if down {
+				return nil, errTransient
+			}
+			m := make(map[interface{}]interface{})
+			for _, k := range keys {
+				m[k] = k
+			}
+			return m, nil
+		}).
+		NegativeCache(NegativeCache{TTL: time.Hour}).
+		StaleIfError(time.Hour).
+		CircuitBreaker(CircuitBreaker{Failures: 1, OpenTimeout: time.Hour}))
+
+	gc.GetMany([]interface{}{"a", "b"})
+	clock.Advance(2 * time.Minute)
+	down = true
+
+	values, errs := gc.GetMany([]interface{}{"a", "c"})
+	var stale *StaleError
+	if !errors.As(errs["a"], &stale) || values["a"] != "a" {
+		t.Errorf("the stale value of a should be served, got %v, %v", values["a"], errs["a"])
+	}
+	if _, ok := values["c"]; ok || errs["c"] != errTransient {
+		t.Errorf("c should fail with the loader error, got %v", errs["c"])
+	}
+	if s := gc.CircuitState(); s != CircuitOpen {
+		t.Errorf("a failed batch should open the circuit, got %v", s)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if _, errs := gc.GetMany([]interface{}{"c"}); errs["c"] != errTransient || calls != 2 {
+		t.Errorf("the error of c should be cached, got %v after %v calls", errs["c"], calls)
+	}
+	if _, errs := gc.GetMany([]interface{}{"d"}); errs["d"] != CircuitOpenError || calls != 2 {
+		t.Errorf("an open circuit should not call the batch loader, got %v after %v calls", errs["d"], calls)
+	}
+}
@@ -0,0 +1,121 @@
+package gcache
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var CircuitOpenError = errors.New("Circuit breaker is open.")
+
+// CircuitState is the state of the circuit breaker of a cache.
+type CircuitState int
+
+const (
+	// CircuitClosed calls the loader.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails the loads with CircuitOpenError, without calling
+	// the loader.
+	CircuitOpen
+	// CircuitHalfOpen lets a single load through to probe the loader, the
+	// others fail with CircuitOpenError.
+	CircuitHalfOpen
+)
+
+func (cs CircuitState) String() string {
+	switch cs {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreaker configures the circuit breaker of the loader, see
+// CacheBuilder.CircuitBreaker. Zero fields take their default value.
+type CircuitBreaker struct {
+	// Failures is the number of consecutive failed loads opening the
+	// circuit. Defaults to 5.
+	Failures int
+	// OpenTimeout is how long the circuit stays open before probing the
+	// loader again. Defaults to 30 seconds.
+	OpenTimeout time.Duration
+	// IsFailure selects the errors counted as failures, all of them when
+	// nil. A "not found" error is usually not one.
+	IsFailure func(error) bool
+}
+
+func (cbr CircuitBreaker) withDefaults() CircuitBreaker {
+	if cbr.Failures <= 0 {
+		cbr.Failures = 5
+	}
+	if cbr.OpenTimeout <= 0 {
+		cbr.OpenTimeout = 30 * time.Second
+	}
+	return cbr
+}
+
+// circuitBreaker is shared by the shards of a cache.
+type circuitBreaker struct {
+	config CircuitBreaker
+	clock  Clock
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+}
+
+func newCircuitBreaker(config CircuitBreaker, clock Clock) *circuitBreaker {
+	return &circuitBreaker{config: config.withDefaults(), clock: clock}
+}
+
+// allow returns CircuitOpenError if the loader must not be called. Every
+// allowed load must be followed by a call to done.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if b.clock.Now().Sub(b.openedAt) < b.config.OpenTimeout {
+			return CircuitOpenError
+		}
+		b.state = CircuitHalfOpen
+		return nil
+	case CircuitHalfOpen:
+		// The probe is in flight.
+		return CircuitOpenError
+	}
+	return nil
+}
+
+func (b *circuitBreaker) done(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		b.state = CircuitClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.config.Failures {
+		b.state = CircuitOpen
+		b.openedAt = b.clock.Now()
+	}
+}
+
+func (b *circuitBreaker) isFailure(err error) bool {
+	return err != nil && (b.config.IsFailure == nil || b.config.IsFailure(err))
+}
+
+func (b *circuitBreaker) current() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
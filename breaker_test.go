@@ -0,0 +1,140 @@
+package gcache
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var down int32 = 1
+	var calls int32
+	clock := NewFakeClock()
+	gc := mustBuild(t, New(8).LRU().Clock(clock).
+		LoaderFunc(func(key interface{}) (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			if atomic.LoadInt32(&down) == 1 {
+				return nil, errTransient
+			}
+			return key, nil
+		}).
+		CircuitBreaker(CircuitBreaker{Failures: 2, OpenTimeout: 10 * time.Second}))
+
+	gc.Get(1)
+	if s := gc.CircuitState(); s != CircuitClosed {
+		t.Errorf("the circuit should stay closed after one failure, got %v", s)
+	}
+	gc.Get(2)
+	if s := gc.CircuitState(); s != CircuitOpen {
+		t.Fatalf("the circuit should open after two failures, got %v", s)
+	}
+
+	if _, err := gc.Get(3); err != CircuitOpenError {
+		t.Errorf("err should be CircuitOpenError, not %v", err)
+	}
+	if calls != 2 || gc.CircuitRejectCount() != 1 {
+		t.Errorf("an open circuit should not call the loader, called %v times", calls)
+	}
+
+	clock.Advance(10 * time.Second)
+	gc.Get(4)
+	if s := gc.CircuitState(); s != CircuitOpen {
+		t.Errorf("a failed probe should open the circuit again, got %v", s)
+	}
+
+	clock.Advance(10 * time.Second)
+	atomic.StoreInt32(&down, 0)
+	if v, err := gc.Get(5); err != nil || v != 5 {
+		t.Errorf("the probe should load 5, got %v, %v", v, err)
+	}
+	if s := gc.CircuitState(); s != CircuitClosed {
+		t.Errorf("a successful probe should close the circuit, got %v", s)
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	release := make(chan struct{})
+	clock := NewFakeClock()
+	gc := mustBuild(t, New(8).LRU().Clock(clock).
+		LoaderFunc(func(key interface{}) (interface{}, error) {
+			if key == "probe" {
+				<-release
+				return key, nil
+			}
+			return nil, errTransient
+		}).
+		CircuitBreaker(CircuitBreaker{Failures: 1, OpenTimeout: time.Second}))
+
+	gc.Get("a")
+	clock.Advance(time.Second)
+	res := getAsync(gc, "probe")
+	for gc.CircuitState() != CircuitHalfOpen {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := gc.Get("b"); err != CircuitOpenError {
+		t.Errorf("only the probe should go through, got %v", err)
+	}
+
+	close(release)
+	if r := <-res; r.err != nil {
+		t.Error(r.err)
+	}
+	if s := gc.CircuitState(); s != CircuitClosed {
+		t.Errorf("the circuit should be closed, got %v", s)
+	}
+}
+
+func TestCircuitBreakerIsFailure(t *testing.T) {
+	gc := mustBuild(t, New(8).LRU().
+		LoaderFunc(func(key interface{}) (interface{}, error) {
+			return nil, errNotFound
+		}).
+		CircuitBreaker(CircuitBreaker{
+			Failures:  1,
+			IsFailure: func(err error) bool { return !errors.Is(err, errNotFound) },
+		}))
+
+	gc.Get("a")
+	gc.Get("b")
+	if s := gc.CircuitState(); s != CircuitClosed {
+		t.Errorf("errors that aren't failures should not open the circuit, got %v", s)
+	}
+}
+
+func TestCircuitBreakerServesStale(t *testing.T) {
+	down := false
+	clock := NewFakeClock()
+	gc := mustBuild(t, New(8).LRU().Clock(clock).
+		Expiration(time.Minute).
+		LoaderFunc(flakyLoader(&down)).
+		StaleIfError(time.Hour).
+		CircuitBreaker(CircuitBreaker{Failures: 1, OpenTimeout: time.Hour}))
+
+	gc.Get("a")
+	down = true
+	gc.Get("b")
+	clock.Advance(2 * time.Minute)
+
+	v, err := gc.Get("a")
+	var stale *StaleError
+	if !errors.As(err, &stale) || stale.Err != CircuitOpenError || v != "a" {
+		t.Errorf("the stale value should be served while the circuit is open, got %v, %v", v, err)
+	}
+}
+
+func TestCircuitBreakerSharded(t *testing.T) {
+	gc := mustBuild(t, New(64).LRU().Shards(4).
+		LoaderFunc(failingLoader(new(int32), 100)).
+		CircuitBreaker(CircuitBreaker{Failures: 8}))
+
+	for i := 0; i < 8; i++ {
+		gc.Get(i)
+	}
+	if s := gc.CircuitState(); s != CircuitOpen {
+		t.Errorf("the shards should share the circuit breaker, got %v", s)
+	}
+	if _, err := gc.Get(100); err != CircuitOpenError {
+		t.Errorf("err should be CircuitOpenError, not %v", err)
+	}
+}
@@ -1,29 +1,52 @@
 package gcache
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"sync"
 	"time"
 )
 
 const (
-	TYPE_SIMPLE = "simple"
-	TYPE_LRU    = "lru"
-	TYPE_LFU    = "lfu"
-	TYPE_ARC    = "arc"
+	TYPE_SIMPLE  = "simple"
+	TYPE_LRU     = "lru"
+	TYPE_LFU     = "lfu"
+	TYPE_ARC     = "arc"
+	TYPE_TINYLFU = "tinylfu"
+	TYPE_RR      = "rr"
 )
 
 type Cache interface {
 	Set(interface{}, interface{}) error
 	SetWithExpire(interface{}, interface{}, time.Duration) error
+	SetWithExpireAfterAccess(interface{}, interface{}, time.Duration) error
 	Get(interface{}) (interface{}, error)
+	// GetContext is Get, but stops waiting on the loader once ctx is done.
+	// The loader keeps running for other callers waiting on the same key.
+	GetContext(context.Context, interface{}) (interface{}, error)
 	GetIFPresent(interface{}) (interface{}, error)
+	GetMany([]interface{}) (map[interface{}]interface{}, map[interface{}]error)
 	GetALL() map[interface{}]interface{}
 	Remove(interface{}) error
 	Purge()
 	Keys() []interface{}
 	Len() int
+	// Resize changes the capacity of the cache. Shrinking it evicts entries
+	// right away, in the order of the eviction policy.
+	Resize(int) error
+	// Snapshot writes the entries, their remaining TTL and the eviction
+	// policy metadata, with the codec set by SnapshotCodec.
+	Snapshot(io.Writer) error
+	// Restore replaces the contents of the cache with a Snapshot, skipping
+	// the entries that expired since it was taken. With a WAL, the cache is
+	// checkpointed right after, so that replaying the log doesn't undo it.
+	Restore(io.Reader) error
+
+	// Close releases the resources held by the cache, like its background janitor.
+	Close() error
 
 	Debug() map[string][]int
 	unsafeGet(interface{}, bool) (interface{}, error)
@@ -32,69 +55,158 @@ type Cache interface {
 }
 
 type (
-	LoaderFunc       func(interface{}) (interface{}, error)
-	LoaderExpireFunc func(interface{}) (interface{}, *time.Duration, error)
-	EvictedFunc      func(interface{}, interface{})
-	PurgeVisitorFunc func(interface{}, interface{})
-	AddedFunc        func(interface{}, interface{})
-	DeserializeFunc  func(interface{}, interface{}) (interface{}, error)
-	SerializeFunc    func(interface{}, interface{}) (interface{}, error)
+	LoaderFunc        func(interface{}) (interface{}, error)
+	LoaderContextFunc func(context.Context, interface{}) (interface{}, error)
+	LoaderExpireFunc  func(interface{}) (interface{}, *time.Duration, error)
+	BatchLoaderFunc   func(context.Context, []interface{}) (map[interface{}]interface{}, error)
+	EvictedFunc       func(interface{}, interface{})
+	RemovalListener   func(interface{}, interface{}, RemovalCause)
+	PurgeVisitorFunc  func(interface{}, interface{})
+	AddedFunc         func(interface{}, interface{})
+	DeserializeFunc   func(interface{}, interface{}) (interface{}, error)
+	SerializeFunc     func(interface{}, interface{}) (interface{}, error)
+	Weigher           func(interface{}, interface{}) int64
+
+	// loaderExpireContextFunc is what every kind of loader is turned into.
+	loaderExpireContextFunc func(context.Context, interface{}) (interface{}, *time.Duration, error)
 )
 
 type baseCache struct {
 	capacity int
 	size     int
 
-	loaderExpireFunc LoaderExpireFunc
+	loaderExpireFunc loaderExpireContextFunc
+	batchLoaderFunc  BatchLoaderFunc
 	evictedFunc      EvictedFunc
+	removalListener  RemovalListener
 	purgeVisitorFunc PurgeVisitorFunc
 	addedFunc        AddedFunc
 	deserializeFunc  DeserializeFunc
 	serializeFunc    SerializeFunc
+	weigher          Weigher
+	maxWeight        int64
+	codec            Codec
 
-	expiration *time.Duration
-	clock      Clock
+	expiration        *time.Duration
+	accessExpiration  *time.Duration
+	refreshAfterWrite *time.Duration
+	clock             Clock
 
 	*stats
 	mu        sync.RWMutex
 	loadGroup Group
+	// refreshing tracks the keys being reloaded by RefreshAfterWrite.
+	refreshing map[interface{}]struct{}
+	// writes numbers the writes of entries, see expiry.version.
+	writes uint64
+
+	wheel   *timerWheel
+	janitor *janitor
+	// reads is only set with BufferedReads.
+	reads    *readBuffer
+	pressure *memoryController
+	// notifier is only set with an asynchronous RemovalListener.
+	notifier   *removalNotifier
+	checkpoint *checkpointer
+	wal        *walWriter
+	// writer is only set in write-through mode, behind in write-behind.
+	writer CacheWriter
+	behind *writeBehind
+	// onDemote is set on the L1 of a Tiered cache.
+	onDemote  func(key, value interface{}, ttl *time.Duration)
+	negatives *negativeCache
+	stale     *staleCache
+	retry     *RetryPolicy
+	// breaker is shared by the shards of a cache.
+	breaker *circuitBreaker
+	early   *earlyRefresh
 }
 
 type CacheBuilder struct {
 	tp       string
 	capacity int
 
-	loaderExpireFunc LoaderExpireFunc
+	loaderExpireFunc loaderExpireContextFunc
+	batchLoaderFunc  BatchLoaderFunc
 	evictedFunc      EvictedFunc
+	removalListener  RemovalListener
+	removalQueue     int
 	purgeVisitorFunc PurgeVisitorFunc
 	addedFunc        AddedFunc
 	deserializeFunc  DeserializeFunc
 	serializeFunc    SerializeFunc
-
-	expiration *time.Duration
-	clock      Clock
+	weigher          Weigher
+	maxWeight        int64
+	codec            Codec
+
+	expiration        *time.Duration
+	accessExpiration  *time.Duration
+	refreshAfterWrite *time.Duration
+	clock             Clock
+	randSource        rand.Source
+
+	cleanupInterval time.Duration
+	shards          int
+	bufferedReads   bool
+	memoryPressure  *MemoryPressure
+	persistence     *Persistence
+	writer          CacheWriter
+	writeBehind     *WriteBehind
+	negativeCache   *NegativeCache
+	maxStale        time.Duration
+	retryPolicy     *RetryPolicy
+	circuitBreaker  *CircuitBreaker
+	earlyBeta       float64
+	// breaker and notifier are set by ShardedCache, to share them between
+	// the shards.
+	breaker  *circuitBreaker
+	notifier *removalNotifier
 }
 
 var KeyNotFoundError = errors.New("Key not found.")
 
+// EntryTooHeavyError is returned when setting a value weighing more than MaxWeight.
+var EntryTooHeavyError = errors.New("Entry is heavier than the maximum weight.")
+
 func New(capacity int) *CacheBuilder {
 	return &CacheBuilder{
 		tp:       TYPE_SIMPLE,
 		capacity: capacity,
 		clock:    NewRealClock(),
+		codec:    GobCodec,
 	}
 }
 
 // Set a loader function.
 // loaderFunc: create a new value with this function if cached value is expired.
 func (cb *CacheBuilder) LoaderFunc(loaderFunc LoaderFunc) *CacheBuilder {
-	cb.loaderExpireFunc = func(k interface{}) (interface{}, *time.Duration, error) {
+	cb.loaderExpireFunc = func(_ context.Context, k interface{}) (interface{}, *time.Duration, error) {
 		v, err := loaderFunc(k)
 		return v, nil, err
 	}
 	return cb
 }
 
+// Set a context-aware loader function.
+// The context comes from the GetContext call that triggered the load: it
+// carries its values and deadline, and is only cancelled once every caller
+// waiting on the load gave up. Loads triggered by Get use context.Background.
+func (cb *CacheBuilder) LoaderContextFunc(loaderFunc LoaderContextFunc) *CacheBuilder {
+	cb.loaderExpireFunc = func(ctx context.Context, k interface{}) (interface{}, *time.Duration, error) {
+		v, err := loaderFunc(ctx, k)
+		return v, nil, err
+	}
+	return cb
+}
+
+// Set a batch loader function, used by GetMany to load all the missing keys
+// in one call. Keys absent from the returned map are reported as not found.
+// Without a LoaderFunc, single-key loads go through it as well.
+func (cb *CacheBuilder) BatchLoaderFunc(batchLoaderFunc BatchLoaderFunc) *CacheBuilder {
+	cb.batchLoaderFunc = batchLoaderFunc
+	return cb
+}
+
 func (cb *CacheBuilder) EvictType(tp string) *CacheBuilder {
 	cb.tp = tp
 	return cb
@@ -116,11 +228,29 @@ func (cb *CacheBuilder) ARC() *CacheBuilder {
 	return cb.EvictType(TYPE_ARC)
 }
 
+func (cb *CacheBuilder) TinyLFU() *CacheBuilder {
+	return cb.EvictType(TYPE_TINYLFU)
+}
+
+func (cb *CacheBuilder) RR() *CacheBuilder {
+	return cb.EvictType(TYPE_RR)
+}
+
+// Set the random source used by TYPE_RR to pick eviction victims.
+// Mostly useful to get a deterministic eviction order in tests.
+func (cb *CacheBuilder) RandSource(src rand.Source) *CacheBuilder {
+	cb.randSource = src
+	return cb
+}
+
 // Set a loader function with expiration.
 // loaderExpireFunc: create a new value with this function if cached value is expired.
-// If nil returned instead of time.Duration from loaderExpireFunc than value will never expire.
+// The returned time.Duration is used as the entry's expiration. If nil is returned instead,
+// the builder's Expiration applies, and the value never expires if none was set.
 func (cb *CacheBuilder) LoaderExpireFunc(loaderExpireFunc LoaderExpireFunc) *CacheBuilder {
-	cb.loaderExpireFunc = loaderExpireFunc
+	cb.loaderExpireFunc = func(_ context.Context, k interface{}) (interface{}, *time.Duration, error) {
+		return loaderExpireFunc(k)
+	}
 	return cb
 }
 
@@ -129,6 +259,26 @@ func (cb *CacheBuilder) EvictedFunc(evictedFunc EvictedFunc) *CacheBuilder {
 	return cb
 }
 
+// Set a listener called for every entry leaving the cache, along with the
+// reason why. Unlike EvictedFunc, it is also called for values replaced by
+// Set and for entries dropped by Purge. It runs under the cache lock.
+func (cb *CacheBuilder) RemovalListener(listener RemovalListener) *CacheBuilder {
+	cb.removalListener = listener
+	cb.removalQueue = 0
+	return cb
+}
+
+// Like RemovalListener, but the listener runs on a background worker, in
+// the order of the removals. Up to queueSize removals wait for it, removing
+// more entries then blocks until it catches up, so the listener must not
+// wait on the cache. Call Close to deliver the pending removals and stop the
+// worker.
+func (cb *CacheBuilder) AsyncRemovalListener(listener RemovalListener, queueSize int) *CacheBuilder {
+	cb.removalListener = listener
+	cb.removalQueue = maxInt(queueSize, 1)
+	return cb
+}
+
 func (cb *CacheBuilder) PurgeVisitorFunc(purgeVisitorFunc PurgeVisitorFunc) *CacheBuilder {
 	cb.purgeVisitorFunc = purgeVisitorFunc
 	return cb
@@ -149,6 +299,22 @@ func (cb *CacheBuilder) SerializeFunc(serializeFunc SerializeFunc) *CacheBuilder
 	return cb
 }
 
+// Set a function computing the weight of an entry, like its size in bytes.
+// It is given values as stored, after SerializeFunc. The total weight is
+// reported by Weight, and bounded by MaxWeight.
+func (cb *CacheBuilder) Weigher(weigher Weigher) *CacheBuilder {
+	cb.weigher = weigher
+	return cb
+}
+
+// Set the maximum total weight of the cache. Entries are evicted until a new
+// one fits, and entries weighing more than that are rejected with
+// EntryTooHeavyError. The entry count is still bounded by the capacity.
+func (cb *CacheBuilder) MaxWeight(maxWeight int64) *CacheBuilder {
+	cb.maxWeight = maxWeight
+	return cb
+}
+
 func (cb *CacheBuilder) Clock(clock Clock) *CacheBuilder {
 	cb.clock = clock
 	return cb
@@ -159,11 +325,173 @@ func (cb *CacheBuilder) Expiration(expiration time.Duration) *CacheBuilder {
 	return cb
 }
 
+// Set an expire-after-access duration: entries expire once they have not been
+// read for that long. If Expiration is also set, it acts as an absolute
+// expire-after-write ceiling that reads can't extend.
+func (cb *CacheBuilder) ExpireAfterAccess(expiration time.Duration) *CacheBuilder {
+	cb.accessExpiration = &expiration
+	return cb
+}
+
+// Set a refresh-after-write duration: once an entry is older than that, reads
+// keep returning the current value while a single background reload runs
+// through the loader. The value is replaced if the reload succeeds and kept
+// otherwise. Only effective with a LoaderFunc or LoaderExpireFunc.
+func (cb *CacheBuilder) RefreshAfterWrite(refresh time.Duration) *CacheBuilder {
+	cb.refreshAfterWrite = &refresh
+	return cb
+}
+
+// Set the interval at which a background janitor removes expired entries.
+// Without it, expired entries are only removed when they are accessed.
+// Call Close to stop the janitor once the cache is no longer used.
+func (cb *CacheBuilder) CleanupInterval(interval time.Duration) *CacheBuilder {
+	cb.cleanupInterval = interval
+	return cb
+}
+
+// Split the cache into n independently locked shards, keys being routed to
+// a shard by their hash. Each shard is a cache of the configured EvictType
+// holding an equal part of the capacity, so eviction is only approximately
+// global. Useful to reduce lock contention on highly concurrent workloads.
+// MaxWeight is split the same way.
+func (cb *CacheBuilder) Shards(n int) *CacheBuilder {
+	cb.shards = n
+	return cb
+}
+
+// Serve cache hits under a shared lock instead of the exclusive one. Reads
+// are recorded in lossy buffers and applied to the eviction policy in
+// batches, so under heavy contention some of them are never seen by it and
+// LRU, LFU, ARC and TinyLFU ordering becomes approximate. Hits on
+// expire-after-access entries or entries due for a refresh still take the
+// exclusive lock.
+func (cb *CacheBuilder) BufferedReads() *CacheBuilder {
+	cb.bufferedReads = true
+	return cb
+}
+
+// Shrink the cache when the process gets close to its memory limit, and grow
+// it back up to its capacity once the pressure is gone, see MemoryPressure.
+// Both the capacity and MaxWeight are scaled, entries are evicted through
+// the cache's policy and reported to EvictedFunc. An unbounded TYPE_SIMPLE
+// cache is only shrunk through MaxWeight. Call Close to stop the controller.
+func (cb *CacheBuilder) MemoryPressure(mp MemoryPressure) *CacheBuilder {
+	cb.memoryPressure = &mp
+	return cb
+}
+
+// Checkpoint the cache to a local file on an interval and on Close, and load
+// the checkpoint at Build time, see Persistence. Entries that expired in the
+// meantime are skipped, according to the Clock. A checkpoint that can't be
+// loaded is reported to OnError and the cache starts empty.
+func (cb *CacheBuilder) Persistence(p Persistence) *CacheBuilder {
+	cb.persistence = &p
+	return cb
+}
+
+// Write the entries set or removed through the cache to w, before updating
+// the cache. A failed write rejects the update. The writer runs with the
+// cache lock held.
+func (cb *CacheBuilder) WriteThrough(w CacheWriter) *CacheBuilder {
+	cb.writer = w
+	cb.writeBehind = nil
+	return cb
+}
+
+// Write the entries set or removed through the cache to w in the background,
+// coalescing the mutations of every key, see WriteBehind. Close flushes the
+// pending mutations.
+func (cb *CacheBuilder) WriteBehind(w CacheWriter, wb WriteBehind) *CacheBuilder {
+	cb.writer = w
+	cb.writeBehind = &wb
+	return cb
+}
+
+// Set the codec used by Snapshot and Restore, GobCodec by default.
+func (cb *CacheBuilder) SnapshotCodec(codec Codec) *CacheBuilder {
+	cb.codec = codec
+	return cb
+}
+
+// Cache the errors returned by the loader for nc.TTL, see NegativeCache.
+// Get returns a cached error without calling the loader, until the key is
+// set or removed. Those hits are counted by NegativeHitCount.
+func (cb *CacheBuilder) NegativeCache(nc NegativeCache) *CacheBuilder {
+	cb.negativeCache = &nc
+	return cb
+}
+
+// Keep expired entries for maxStale, and return them along with a
+// *StaleError when the loader fails to reload them. Entries removed, evicted
+// or replaced are not kept. Combine with NegativeCache to not call the
+// loader on every Get while it fails.
+func (cb *CacheBuilder) StaleIfError(maxStale time.Duration) *CacheBuilder {
+	cb.maxStale = maxStale
+	return cb
+}
+
+// Retry the failed calls to the loader, see RetryPolicy. The retries are
+// counted by LoadRetryCount.
+func (cb *CacheBuilder) LoaderRetry(rp RetryPolicy) *CacheBuilder {
+	cb.retryPolicy = &rp
+	return cb
+}
+
+// Stop calling the loader after consecutive failures, see CircuitBreaker.
+// While the circuit is open, loads fail with CircuitOpenError, or return the
+// stale value with StaleIfError. A load that retries counts as one failure.
+func (cb *CacheBuilder) CircuitBreaker(cbr CircuitBreaker) *CacheBuilder {
+	cb.circuitBreaker = &cbr
+	return cb
+}
+
+// Reload entries in the background ahead of their expiration, so that hot
+// keys don't all expire at once. A hit on an entry loaded in delta reloads
+// it with a probability growing as its expiration gets closer, sooner with
+// a higher beta, 1 being the usual value. See XFetch in "Optimal
+// Probabilistic Cache Stampede Prevention". Early reloads are counted by
+// EarlyRefreshCount.
+func (cb *CacheBuilder) EarlyRefresh(beta float64) *CacheBuilder {
+	cb.earlyBeta = beta
+	return cb
+}
+
 func (cb *CacheBuilder) Build() (Cache, error) {
 	if cb.capacity <= 0 && cb.tp != TYPE_SIMPLE {
 		return nil, fmt.Errorf("gcache2: can't Build Cache, invalid Cache capacity (%v<=0)", cb.capacity)
 	}
 
+	if cb.maxWeight < 0 {
+		return nil, fmt.Errorf("gcache2: can't Build Cache, invalid maximum weight (%v<0)", cb.maxWeight)
+	}
+	if cb.maxWeight > 0 && cb.weigher == nil {
+		return nil, errors.New("gcache2: can't Build Cache, MaxWeight requires a Weigher")
+	}
+
+	if cb.persistence != nil && cb.persistence.Path == "" {
+		return nil, errors.New("gcache2: can't Build Cache, Persistence requires a Path")
+	}
+
+	if cb.negativeCache != nil && cb.negativeCache.TTL <= 0 {
+		return nil, fmt.Errorf("gcache2: can't Build Cache, invalid negative cache TTL (%v<=0)", cb.negativeCache.TTL)
+	}
+
+	if cb.earlyBeta < 0 {
+		return nil, fmt.Errorf("gcache2: can't Build Cache, invalid early refresh beta (%v<0)", cb.earlyBeta)
+	}
+
+	if cb.maxStale < 0 {
+		return nil, fmt.Errorf("gcache2: can't Build Cache, invalid maximum staleness (%v<0)", cb.maxStale)
+	}
+
+	if cb.shards < 0 {
+		return nil, fmt.Errorf("gcache2: can't Build Cache, invalid number of shards (%v<0)", cb.shards)
+	}
+	if cb.shards > 1 {
+		return newShardedCache(cb)
+	}
+
 	return cb.build()
 }
 
@@ -177,6 +505,10 @@ func (cb *CacheBuilder) build() (Cache, error) {
 		return newLFUCache(cb), nil
 	case TYPE_ARC:
 		return newARC(cb), nil
+	case TYPE_TINYLFU:
+		return newTinyLFU(cb), nil
+	case TYPE_RR:
+		return newRRCache(cb), nil
 	default:
 		return nil, fmt.Errorf("gcache2: can't build Cache, unknow Cache type (%s", cb.tp)
 	}
@@ -186,27 +518,121 @@ func buildCache(c *baseCache, cb *CacheBuilder) {
 	c.clock = cb.clock
 	c.capacity = cb.capacity
 	c.loaderExpireFunc = cb.loaderExpireFunc
+	c.batchLoaderFunc = cb.batchLoaderFunc
+	if c.loaderExpireFunc == nil && c.batchLoaderFunc != nil {
+		c.loaderExpireFunc = func(ctx context.Context, k interface{}) (interface{}, *time.Duration, error) {
+			values, err := c.batchLoaderFunc(ctx, []interface{}{k})
+			if err != nil {
+				return nil, nil, err
+			}
+			v, ok := values[k]
+			if !ok {
+				return nil, nil, KeyNotFoundError
+			}
+			return v, nil, nil
+		}
+	}
 	c.expiration = cb.expiration
+	c.accessExpiration = cb.accessExpiration
+	c.refreshAfterWrite = cb.refreshAfterWrite
+	c.refreshing = make(map[interface{}]struct{})
 	c.addedFunc = cb.addedFunc
 	c.deserializeFunc = cb.deserializeFunc
 	c.serializeFunc = cb.serializeFunc
+	c.weigher = cb.weigher
+	c.maxWeight = cb.maxWeight
+	c.codec = cb.codec
+	if cb.writer != nil && cb.writeBehind != nil {
+		c.behind = newWriteBehind(cb.writer, *cb.writeBehind, cb.clock)
+	} else {
+		c.writer = cb.writer
+	}
 	c.evictedFunc = cb.evictedFunc
+	if cb.notifier != nil {
+		c.notifier = cb.notifier
+	} else if cb.removalListener != nil && cb.removalQueue > 0 {
+		c.notifier = newRemovalNotifier(cb.removalListener, cb.removalQueue)
+	} else {
+		c.removalListener = cb.removalListener
+	}
 	c.purgeVisitorFunc = cb.purgeVisitorFunc
-	c.stats = &stats{}
+	if cb.negativeCache != nil {
+		c.negatives = newNegativeCache(*cb.negativeCache, cb.capacity)
+	}
+	if cb.maxStale > 0 {
+		c.stale = newStaleCache(cb.maxStale, cb.capacity)
+	}
+	if cb.retryPolicy != nil {
+		rp := cb.retryPolicy.withDefaults()
+		c.retry = &rp
+	}
+	if cb.earlyBeta > 0 {
+		c.early = newEarlyRefresh(cb.earlyBeta)
+	}
+	c.breaker = cb.breaker
+	if c.breaker == nil && cb.circuitBreaker != nil {
+		c.breaker = newCircuitBreaker(*cb.circuitBreaker, cb.clock)
+	}
+	c.stats = &stats{breaker: c.breaker}
+}
+
+// removed must be called whenever an entry leaves the cache, or its value is
+// replaced. e is the expiry of the entry, nil when it is replaced.
+func (c *baseCache) removed(key, value interface{}, e *expiry, cause RemovalCause) {
+	if cause != RemovalReplaced {
+		if c.wheel != nil {
+			c.wheel.cancel(key)
+		}
+		if c.evictedFunc != nil {
+			c.evictedFunc(key, value)
+		}
+	}
+	if c.onDemote != nil && (cause == RemovalSize || cause == RemovalResized) {
+		c.demoted(key, value, e)
+	}
+	if c.stale != nil && cause == RemovalExpired {
+		c.stale.add(key, value, e, c.clock.Now())
+	}
+	c.notifyRemoval(key, value, cause)
 }
 
 // load a new value using by specified key.
-func (c *baseCache) load(key interface{}, cb func(interface{}, *time.Duration, error) (interface{}, error), isWait bool) (interface{}, bool, error) {
-	v, called, err := c.loadGroup.Do(key, func() (v interface{}, e error) {
+func (c *baseCache) load(ctx context.Context, key interface{}, cb func(interface{}, *time.Duration, error) (interface{}, error), isWait bool) (interface{}, bool, error) {
+	if err, ok := c.negativeHit(key); ok {
+		return c.staleIfError(key, err, false)
+	}
+	v, called, err := c.loadGroup.DoContext(ctx, key, func(ctx context.Context) (v interface{}, e error) {
 		defer func() {
 			if r := recover(); r != nil {
 				e = fmt.Errorf("Loader panics: %v", r)
 			}
 		}()
-		return cb(c.loaderExpireFunc(key))
+		v, expiration, loadTime, err := c.callLoader(ctx, key)
+		if err != nil {
+			c.cacheError(key, err)
+			return nil, &loaderError{err}
+		}
+		if v, err = cb(v, expiration, nil); err != nil {
+			return nil, err
+		}
+		c.recordLoadTime(key, loadTime)
+		return v, nil
 	}, isWait)
+	if le, ok := err.(*loaderError); ok {
+		return c.staleIfError(key, le.err, called)
+	}
 	if err != nil {
 		return nil, called, err
 	}
 	return v, called, nil
 }
+
+// loaderError marks the errors returned by the loader, shared by the callers
+// waiting on it, from the other errors of a load.
+type loaderError struct {
+	err error
+}
+
+func (e *loaderError) Error() string {
+	return e.err.Error()
+}
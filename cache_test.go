@@ -2,6 +2,7 @@ package gcache
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"sync"
 	"sync/atomic"
@@ -16,6 +17,8 @@ func TestLoaderFunc(t *testing.T) {
 		New(size).LRU(),
 		New(size).LFU(),
 		New(size).ARC(),
+		New(size).TinyLFU(),
+		New(size).RR(),
 	}
 	for _, builder := range testCaches {
 		var testCounter int64
@@ -59,6 +62,8 @@ func TestLoaderExpireFuncWithoutExpire(t *testing.T) {
 		New(size).LRU(),
 		New(size).LFU(),
 		New(size).ARC(),
+		New(size).TinyLFU(),
+		New(size).RR(),
 	}
 	for _, builder := range testCaches {
 		var testCounter int64
@@ -94,6 +99,104 @@ func TestLoaderExpireFuncWithoutExpire(t *testing.T) {
 	}
 }
 
+func TestLoaderExpireFuncWithExpire(t *testing.T) {
+	size := 8
+	var testCaches = []*CacheBuilder{
+		New(size).Simple(),
+		New(size).LRU(),
+		New(size).LFU(),
+		New(size).ARC(),
+		New(size).TinyLFU(),
+		New(size).RR(),
+	}
+	for _, builder := range testCaches {
+		var testCounter int64
+		clock := NewFakeClock()
+		cache, err := builder.
+			Clock(clock).
+			Expiration(time.Hour).
+			LoaderExpireFunc(func(key interface{}) (interface{}, *time.Duration, error) {
+				atomic.AddInt64(&testCounter, 1)
+				if key == "builder" {
+					return key, nil, nil
+				}
+				d := time.Second
+				return key, &d, nil
+			}).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, key := range []string{"loader", "builder"} {
+			if _, err := cache.Get(key); err != nil {
+				t.Error(err)
+			}
+		}
+
+		// The loader's TTL wins over the builder's Expiration.
+		clock.Advance(2 * time.Second)
+		if _, err := cache.GetIFPresent("loader"); err != KeyNotFoundError {
+			t.Errorf("%s: loader TTL was ignored, err is %v", builder.tp, err)
+		}
+
+		// A nil TTL falls back to the builder's Expiration.
+		if _, err := cache.GetIFPresent("builder"); err != nil {
+			t.Errorf("%s: unexpected error %v", builder.tp, err)
+		}
+		clock.Advance(time.Hour)
+		if _, err := cache.GetIFPresent("builder"); err != KeyNotFoundError {
+			t.Errorf("%s: builder Expiration was ignored, err is %v", builder.tp, err)
+		}
+	}
+}
+
+func TestGetContext(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		release := make(chan struct{})
+		var deadlines int64
+		cache, err := New(8).
+			EvictType(tp).
+			LoaderContextFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+				if _, ok := ctx.Deadline(); ok {
+					atomic.AddInt64(&deadlines, 1)
+				}
+				<-release
+				return key, nil
+			}).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err = cache.GetContext(ctx, "key")
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Errorf("%s: err should be DeadlineExceeded, not %v", tp, err)
+		}
+		if atomic.LoadInt64(&deadlines) != 1 {
+			t.Errorf("%s: the deadline was not propagated to the loader", tp)
+		}
+
+		// A caller giving up does not affect the others.
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if v, err := cache.Get("other"); err != nil || v != "other" {
+				t.Errorf("%s: Get(other) = %v, %v", tp, v, err)
+			}
+		}()
+		ctx, cancel = context.WithCancel(context.Background())
+		go cancel()
+		if _, err := cache.GetContext(ctx, "other"); err != context.Canceled {
+			t.Errorf("%s: err should be Canceled, not %v", tp, err)
+		}
+		close(release)
+		<-done
+	}
+}
+
 func TestLoaderPurgeVisitorFunc(t *testing.T) {
 	size := 7
 	tests := []struct {
@@ -112,10 +215,18 @@ func TestLoaderPurgeVisitorFunc(t *testing.T) {
 			name:         "lfu",
 			cacheBuilder: New(size).LFU(),
 		},
-		/* {
-		 *     name:         "arc",
-		 *     cacheBuilder: New(size).ARC(),
-		 * }, */
+		{
+			name:         "tinylfu",
+			cacheBuilder: New(size).TinyLFU(),
+		},
+		{
+			name:         "rr",
+			cacheBuilder: New(size).RR(),
+		},
+		{
+			name:         "arc",
+			cacheBuilder: New(size).ARC(),
+		},
 	}
 
 	for _, test := range tests {
@@ -174,6 +285,8 @@ func TestDeserializeFunc(t *testing.T) {
 		{TYPE_LRU},
 		{TYPE_LFU},
 		{TYPE_ARC},
+		{TYPE_TINYLFU},
+		{TYPE_RR},
 	}
 
 	for _, cs := range cases {
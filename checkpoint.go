@@ -0,0 +1,254 @@
+package gcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Persistence configures checkpoints of the cache to a local file, see
+// CacheBuilder.Persistence.
+type Persistence struct {
+	// Path of the checkpoint file. It is replaced atomically, temporary
+	// files are written next to it.
+	Path string
+	// Interval is the time between two checkpoints. With no interval the
+	// cache is only checkpointed on Close.
+	Interval time.Duration
+	// OnError is called with the errors of loading the checkpoint at Build
+	// time and of background checkpoints.
+	OnError func(error)
+	// WAL also logs every Set, Remove and Purge to segment files next to
+	// the checkpoint, replayed on top of it at Build time. Checkpoints
+	// compact the log.
+	WAL *WAL
+}
+
+// CorruptCheckpointError is reported when the checkpoint file can't be
+// loaded, the cache then starts empty.
+var CorruptCheckpointError = errors.New("Checkpoint file is corrupt.")
+
+// A checkpoint file is the magic, a snapshot and the CRC-32 of the snapshot.
+const checkpointMagic = "gcache2\x01"
+
+// checkpointer writes the snapshot of a cache to a file on an interval and
+// on Close.
+type checkpointer struct {
+	cache  Cache
+	clock  Clock
+	config Persistence
+	wal    *writeAheadLog
+	// mu serializes the writes of the checkpoint file.
+	mu sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func (c *baseCache) startCheckpointer(cache Cache, p *Persistence) {
+	c.checkpoint = newCheckpointer(cache, []Cache{cache}, c.clock, c.codec, p)
+}
+
+// newCheckpointer loads the checkpoint of p into cache, replays the log on
+// its shards, and checkpoints it from then on. It returns nil if p is.
+func newCheckpointer(cache Cache, shards []Cache, clock Clock, codec Codec, p *Persistence) *checkpointer {
+	if p == nil {
+		return nil
+	}
+	cp := &checkpointer{
+		cache:  cache,
+		clock:  clock,
+		config: *p,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if err := cp.load(); err != nil {
+		cp.report(err)
+	}
+	if p.WAL != nil {
+		if err := cp.openLog(shards, codec); err != nil {
+			cp.report(fmt.Errorf("gcache2: can't open the WAL: %w", err))
+		}
+	}
+	if cp.config.Interval > 0 || cp.wal != nil {
+		go cp.run()
+	} else {
+		close(cp.done)
+	}
+	return cp
+}
+
+func (cp *checkpointer) openLog(shards []Cache, codec Codec) error {
+	seq, err := replayLog(cp.config.Path, codec, cp.clock, shards, cp.report)
+	if err != nil {
+		return err
+	}
+	cp.wal, err = openLog(cp.config.Path, *cp.config.WAL, codec, cp.clock, seq, cp.report)
+	if err != nil {
+		return err
+	}
+	for i, shard := range shards {
+		shard.(interface{ attachLog(*walWriter) }).attachLog(&walWriter{
+			log:    cp.wal,
+			shard:  i,
+			shards: len(shards),
+		})
+	}
+	return nil
+}
+
+func (cp *checkpointer) run() {
+	defer close(cp.done)
+	var tick <-chan time.Time
+	for {
+		if tick == nil && cp.config.Interval > 0 {
+			tick = after(cp.clock, cp.config.Interval)
+		}
+		select {
+		case <-cp.stop:
+			return
+		case <-tick:
+			tick = nil
+		case <-cp.wal.compactions():
+		}
+		if err := cp.checkpoint(); err != nil {
+			cp.report(err)
+		}
+	}
+}
+
+func (cp *checkpointer) report(err error) {
+	if cp.config.OnError != nil {
+		cp.config.OnError(err)
+	}
+}
+
+// load restores the checkpoint file, if there is one. Entries that expired
+// since it was written are skipped by Restore.
+func (cp *checkpointer) load() error {
+	data, err := os.ReadFile(cp.config.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("gcache2: can't load checkpoint: %w", err)
+	}
+
+	n := len(data) - crc32.Size
+	if n < len(checkpointMagic) || string(data[:len(checkpointMagic)]) != checkpointMagic {
+		return fmt.Errorf("gcache2: can't load checkpoint %s: %w", cp.config.Path, CorruptCheckpointError)
+	}
+	payload := data[len(checkpointMagic):n]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(data[n:]) {
+		return fmt.Errorf("gcache2: can't load checkpoint %s, checksum mismatch: %w", cp.config.Path, CorruptCheckpointError)
+	}
+	if err := cp.cache.Restore(bytes.NewReader(payload)); err != nil {
+		return fmt.Errorf("gcache2: can't load checkpoint %s, %v: %w", cp.config.Path, err, CorruptCheckpointError)
+	}
+	return nil
+}
+
+// checkpoint writes the snapshot of the cache to a temporary file, then
+// renames it over the checkpoint file and drops the log segments it covers.
+func (cp *checkpointer) checkpoint() error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if cp.wal == nil {
+		return cp.write()
+	}
+	seq, err := cp.wal.rotate()
+	if err != nil {
+		return fmt.Errorf("gcache2: can't rotate the WAL: %w", err)
+	}
+	if err := cp.write(); err != nil {
+		return err
+	}
+	if err := cp.wal.truncate(seq); err != nil {
+		return fmt.Errorf("gcache2: can't compact the WAL: %w", err)
+	}
+	return nil
+}
+
+func (cp *checkpointer) write() (err error) {
+	dir, base := filepath.Split(cp.config.Path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.CreateTemp(dir, base+".tmp*")
+	if err != nil {
+		return fmt.Errorf("gcache2: can't write checkpoint: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			err = fmt.Errorf("gcache2: can't write checkpoint: %w", err)
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+	if _, err := w.WriteString(checkpointMagic); err != nil {
+		return err
+	}
+	crc := crc32.NewIEEE()
+	if err := cp.cache.Snapshot(io.MultiWriter(w, crc)); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, crc.Sum32()); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), cp.config.Path); err != nil {
+		return err
+	}
+	// Persist the rename, not every platform can sync a directory.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// close stops the background checkpoints and writes a last one.
+// restored checkpoints the cache after a Restore, which isn't logged: the
+// segments written before it must not be replayed on top of it.
+func (cp *checkpointer) restored() error {
+	if cp == nil || cp.wal == nil {
+		return nil
+	}
+	return cp.checkpoint()
+}
+
+func (cp *checkpointer) close() error {
+	if cp == nil {
+		return nil
+	}
+	var err error
+	cp.once.Do(func() {
+		close(cp.stop)
+		<-cp.done
+		err = cp.checkpoint()
+		if e := cp.wal.close(); err == nil {
+			err = e
+		}
+	})
+	return err
+}
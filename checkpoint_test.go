@@ -0,0 +1,162 @@
+package gcache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type errorRecorder struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (r *errorRecorder) report(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, err)
+}
+
+func (r *errorRecorder) last() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.errs) == 0 {
+		return nil
+	}
+	return r.errs[len(r.errs)-1]
+}
+
+func TestCheckpointOnClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	for _, tp := range allEvictTypes {
+		gc := mustBuild(t, New(16).EvictType(tp).Persistence(Persistence{Path: path}))
+		testSetCache(t, gc, 8)
+		if err := gc.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		restored := mustBuild(t, New(16).EvictType(tp).Persistence(Persistence{Path: path}))
+		if l := restored.Len(); l != 8 {
+			t.Errorf("%v: Len should be 8, not %v", tp, l)
+		}
+		testGetCache(t, restored, 8)
+		restored.Close()
+	}
+
+	matches, _ := filepath.Glob(path + ".tmp*")
+	if len(matches) != 0 {
+		t.Errorf("temporary files should be renamed, found %v", matches)
+	}
+}
+
+func TestCheckpointInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	clock := NewFakeClock()
+	gc := mustBuild(t, New(16).LRU().Clock(clock).Persistence(Persistence{
+		Path:     path,
+		Interval: time.Minute,
+	}))
+	defer gc.Close()
+	gc.Set("a", 1)
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	clock.BlockUntil(1)
+
+	restored := mustBuild(t, New(16).LRU().Clock(clock).Persistence(Persistence{Path: path}))
+	if v, err := restored.Get("a"); err != nil || v != 1 {
+		t.Errorf("a should be checkpointed, got %v, %v", v, err)
+	}
+}
+
+func TestCheckpointSkipsExpired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	clock := NewFakeClock()
+	gc := mustBuild(t, New(16).LRU().Clock(clock).Persistence(Persistence{Path: path}))
+	gc.SetWithExpire("short", 1, time.Second)
+	gc.SetWithExpire("long", 2, time.Hour)
+	gc.Close()
+
+	clock.Advance(time.Minute)
+	restored := mustBuild(t, New(16).LRU().Clock(clock).Persistence(Persistence{Path: path}))
+	if has(restored, "short") {
+		t.Error("short expired while the cache was down, it should be skipped")
+	}
+	if !has(restored, "long") {
+		t.Error("long should be loaded")
+	}
+}
+
+func TestCheckpointCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cache")
+	gc := mustBuild(t, New(16).LRU().Persistence(Persistence{Path: path}))
+	testSetCache(t, gc, 8)
+	gc.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flipped := append([]byte(nil), data...)
+	flipped[len(flipped)/2] ^= 0xff
+	for name, content := range map[string][]byte{
+		"truncated": data[:len(data)/2],
+		"flipped":   flipped,
+		"garbage":   []byte("not a checkpoint"),
+		"empty":     nil,
+	} {
+		if err := os.WriteFile(path, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		rec := &errorRecorder{}
+		restored, err := New(16).LRU().Persistence(Persistence{Path: path, OnError: rec.report}).Build()
+		if err != nil {
+			t.Fatalf("%v: Build should not fail, got %v", name, err)
+		}
+		if !errors.Is(rec.last(), CorruptCheckpointError) {
+			t.Errorf("%v: CorruptCheckpointError should be reported, got %v", name, rec.last())
+		}
+		if l := restored.Len(); l != 0 {
+			t.Errorf("%v: the cache should start empty, got %v entries", name, l)
+		}
+	}
+}
+
+func TestCheckpointMissingFile(t *testing.T) {
+	rec := &errorRecorder{}
+	gc := mustBuild(t, New(16).LRU().Persistence(Persistence{
+		Path:    filepath.Join(t.TempDir(), "cache"),
+		OnError: rec.report,
+	}))
+	if err := rec.last(); err != nil {
+		t.Errorf("a missing checkpoint should not be reported, got %v", err)
+	}
+	if err := gc.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckpointSharded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	gc := mustBuild(t, New(64).LRU().Shards(4).Persistence(Persistence{Path: path}))
+	testSetCache(t, gc, 16)
+	gc.Close()
+
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*"))
+	if len(matches) != 1 {
+		t.Errorf("the shards should share a single checkpoint, found %v", matches)
+	}
+
+	restored := mustBuild(t, New(64).LRU().Shards(4).Persistence(Persistence{Path: path}))
+	testGetCache(t, restored, 16)
+}
+
+func TestPersistenceRequiresPath(t *testing.T) {
+	if _, err := New(16).LRU().Persistence(Persistence{}).Build(); err == nil {
+		t.Error("Build should fail without a Path")
+	}
+}
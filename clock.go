@@ -9,6 +9,19 @@ type Clock interface {
 	Now() time.Time
 }
 
+// timerClock is implemented by the clocks that also drive the timers of the
+// background workers, like FakeClock. Other clocks use time.After.
+type timerClock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+func after(clock Clock, d time.Duration) <-chan time.Time {
+	if tc, ok := clock.(timerClock); ok {
+		return tc.After(d)
+	}
+	return time.After(d)
+}
+
 type RealClock struct{}
 
 func NewRealClock() Clock {
@@ -20,23 +33,39 @@ func (rc RealClock) Now() time.Time {
 	return t
 }
 
+func (rc RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
 type FakeClock interface {
 	Clock
 
+	After(d time.Duration) <-chan time.Time
 	Advance(d time.Duration)
+	// BlockUntil blocks until at least n goroutines are waiting on After.
+	BlockUntil(n int)
 }
 
 func NewFakeClock() FakeClock {
-	return &fakeclock{
+	fc := &fakeclock{
 		// Taken from github.com/jonboulle/clockwork: use a fixture that does not fulfill Time.IsZero()
 		now: time.Date(1984, time.April, 4, 0, 0, 0, 0, time.UTC),
 	}
+	fc.cond = sync.NewCond(&fc.mutex)
+	return fc
 }
 
 type fakeclock struct {
-	now time.Time
+	now     time.Time
+	waiters []fakeWaiter
 
 	mutex sync.RWMutex
+	cond  *sync.Cond
+}
+
+type fakeWaiter struct {
+	until time.Time
+	ch    chan time.Time
 }
 
 func (fc *fakeclock) Now() time.Time {
@@ -46,8 +75,40 @@ func (fc *fakeclock) Now() time.Time {
 	return t
 }
 
+func (fc *fakeclock) After(d time.Duration) <-chan time.Time {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- fc.now
+		return ch
+	}
+	fc.waiters = append(fc.waiters, fakeWaiter{until: fc.now.Add(d), ch: ch})
+	fc.cond.Broadcast()
+	return ch
+}
+
 func (fc *fakeclock) Advance(d time.Duration) {
 	fc.mutex.Lock()
 	defer fc.mutex.Unlock()
 	fc.now = fc.now.Add(d)
+
+	waiters := fc.waiters[:0]
+	for _, w := range fc.waiters {
+		if w.until.After(fc.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- fc.now
+	}
+	fc.waiters = waiters
+}
+
+func (fc *fakeclock) BlockUntil(n int) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	for len(fc.waiters) < n {
+		fc.cond.Wait()
+	}
 }
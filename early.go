@@ -0,0 +1,53 @@
+package gcache
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// earlyRefresh reloads entries ahead of their expiration, at random, with
+// the XFetch algorithm of "Optimal Probabilistic Cache Stampede Prevention"
+// (Vattani et al.): a hit reloads the entry once
+//
+//	now - delta * beta * ln(rand()) >= expiration
+//
+// where delta is how long its last load took, kept on its expiry.
+type earlyRefresh struct {
+	beta float64
+}
+
+func newEarlyRefresh(beta float64) *earlyRefresh {
+	return &earlyRefresh{beta: beta}
+}
+
+func (r *earlyRefresh) due(delta time.Duration, expiration, now time.Time) bool {
+	gap := time.Duration(float64(delta) * r.beta * -math.Log(1-rand.Float64()))
+	return !now.Add(gap).Before(expiration)
+}
+
+// recordLoadTime keeps how long the loader took to load key on the entry it
+// was just stored in.
+func (c *baseCache) recordLoadTime(key interface{}, delta time.Duration) {
+	if c.early == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.loadGroup.cache.(entryStore).expiryOf(key); ok {
+		e.loadTime = delta
+	}
+}
+
+// refreshEarly tells whether a hit on the entry of key should reload it
+// early. Entries that were never loaded have no delta and are never
+// reloaded early. Called with the cache lock held.
+func (c *baseCache) refreshEarly(key interface{}, e *expiry, now time.Time) bool {
+	if c.early == nil || e.expiration == nil {
+		return false
+	}
+	if e.loadTime <= 0 {
+		return false
+	}
+	return c.early.due(e.loadTime, *e.expiration, now)
+}
@@ -0,0 +1,109 @@
+package gcache
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowLoader takes a second of clock to load a value expiring after ttl.
+func slowLoader(clock FakeClock, calls *int32, ttl time.Duration) LoaderExpireFunc {
+	return func(key interface{}) (interface{}, *time.Duration, error) {
+		atomic.AddInt32(calls, 1)
+		clock.Advance(time.Second)
+		return key, &ttl, nil
+	}
+}
+
+func TestEarlyRefresh(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		var calls int32
+		clock := NewFakeClock()
+		gc := mustBuild(t, New(8).EvictType(tp).Clock(clock).
+			LoaderExpireFunc(slowLoader(clock, &calls, time.Minute)).
+			EarlyRefresh(1e9))
+
+		gc.Get("a")
+		if v, err := gc.Get("a"); err != nil || v != "a" {
+			t.Errorf("%v: the current value should be served, got %v, %v", tp, v, err)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for atomic.LoadInt32(&calls) != 2 {
+			if time.Now().After(deadline) {
+				t.Fatalf("%v: a hit should reload a early", tp)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if n := gc.EarlyRefreshCount(); n != 1 {
+			t.Errorf("%v: EarlyRefreshCount should be 1, not %v", tp, n)
+		}
+	}
+}
+
+func TestEarlyRefreshFarFromExpiration(t *testing.T) {
+	var calls int32
+	clock := NewFakeClock()
+	gc := mustBuild(t, New(8).LRU().Clock(clock).
+		LoaderExpireFunc(slowLoader(clock, &calls, time.Hour)).
+		EarlyRefresh(1))
+
+	for i := 0; i < 100; i++ {
+		gc.Get("a")
+	}
+	if calls != 1 || gc.EarlyRefreshCount() != 0 {
+		t.Errorf("entries far from their expiration should not be reloaded, the loader ran %v times", calls)
+	}
+}
+
+func TestEarlyRefreshNotLoaded(t *testing.T) {
+	var calls int32
+	clock := NewFakeClock()
+	gc := mustBuild(t, New(8).LRU().Clock(clock).
+		LoaderExpireFunc(slowLoader(clock, &calls, time.Minute)).
+		EarlyRefresh(1e9))
+
+	gc.SetWithExpire("a", 1, time.Minute)
+	for i := 0; i < 10; i++ {
+		gc.Get("a")
+	}
+	if gc.EarlyRefreshCount() != 0 {
+		t.Error("entries that were never loaded should not be reloaded early")
+	}
+}
+
+func TestEarlyRefreshReplaced(t *testing.T) {
+	var calls int32
+	clock := NewFakeClock()
+	gc := mustBuild(t, New(8).LRU().Clock(clock).
+		LoaderExpireFunc(slowLoader(clock, &calls, time.Minute)).
+		EarlyRefresh(1e9))
+
+	gc.Get("a")
+	gc.SetWithExpire("a", 1, time.Minute)
+	for i := 0; i < 10; i++ {
+		gc.Get("a")
+	}
+	if gc.EarlyRefreshCount() != 0 {
+		t.Error("entries set since they were loaded should not be reloaded early")
+	}
+}
+
+func TestEarlyRefreshDue(t *testing.T) {
+	r := newEarlyRefresh(1)
+	now := time.Now()
+	for i := 0; i < 1000; i++ {
+		if !r.due(time.Second, now, now) {
+			t.Fatal("an entry at its expiration should always be due")
+		}
+		if r.due(time.Second, now.Add(time.Hour), now) {
+			t.Fatal("an entry 3600 deltas away from its expiration should not be due")
+		}
+	}
+}
+
+func TestEarlyRefreshBuildError(t *testing.T) {
+	if _, err := New(8).EarlyRefresh(-1).Build(); err == nil {
+		t.Error("Build should fail with a negative beta")
+	}
+}
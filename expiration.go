@@ -0,0 +1,90 @@
+package gcache
+
+import "time"
+
+// expiry holds the expiration state shared by the items of every cache type.
+type expiry struct {
+	clock      Clock
+	expiration *time.Time
+
+	// accessTTL, when set, pushes expiration back on every read.
+	accessTTL *time.Duration
+	// ceiling is the expire-after-write deadline, reads never extend past it.
+	ceiling *time.Time
+	// updated is when the entry was last written, see RefreshAfterWrite.
+	updated time.Time
+	// version tells the writes of the cache apart, even when the clock
+	// doesn't, so that a refresh doesn't overwrite a newer write.
+	version uint64
+	// loadTime is how long the loader took to load the entry, 0 if it was
+	// written otherwise, see EarlyRefresh.
+	loadTime time.Duration
+}
+
+func (e *expiry) isExpired(now *time.Time) bool {
+	if e.expiration == nil {
+		return false
+	}
+	if now == nil {
+		t := e.clock.Now()
+		now = &t
+	}
+	return e.expiration.Before(*now)
+}
+
+// slide recomputes expiration for an entry written or read at now.
+func (e *expiry) slide(now time.Time) {
+	if e.accessTTL == nil {
+		e.expiration = e.ceiling
+		return
+	}
+	t := now.Add(*e.accessTTL)
+	if e.ceiling != nil && e.ceiling.Before(t) {
+		t = *e.ceiling
+	}
+	e.expiration = &t
+}
+
+// expireAfterWrite applies the builder's expiration policy to an entry that
+// has just been written.
+func (c *baseCache) expireAfterWrite(key interface{}, e *expiry) {
+	now := c.clock.Now()
+	e.updated = now
+	c.writes++
+	e.version = c.writes
+	e.loadTime = 0
+	e.ceiling = nil
+	if c.expiration != nil {
+		t := now.Add(*c.expiration)
+		e.ceiling = &t
+	}
+	e.accessTTL = c.accessExpiration
+	e.slide(now)
+	c.scheduleExpiration(key, e.expiration)
+}
+
+// expireAt gives an entry a fixed expiration, overriding the builder's policy.
+func (c *baseCache) expireAt(key interface{}, e *expiry, expiration time.Duration) {
+	t := c.clock.Now().Add(expiration)
+	e.ceiling = &t
+	e.accessTTL = nil
+	e.expiration = &t
+	c.scheduleExpiration(key, e.expiration)
+}
+
+// expireAfterAccess makes an entry expire once it has not been read for
+// expiration, still bounded by the builder's expire-after-write Expiration.
+func (c *baseCache) expireAfterAccess(key interface{}, e *expiry, expiration time.Duration) {
+	e.accessTTL = &expiration
+	e.slide(c.clock.Now())
+	c.scheduleExpiration(key, e.expiration)
+}
+
+// accessed must be called on every successful read of an entry.
+func (c *baseCache) accessed(key interface{}, e *expiry) {
+	if e.accessTTL == nil {
+		return
+	}
+	e.slide(c.clock.Now())
+	c.scheduleExpiration(key, e.expiration)
+}
@@ -0,0 +1,136 @@
+package gcache
+
+import (
+	"testing"
+	"time"
+)
+
+var allEvictTypes = []string{TYPE_SIMPLE, TYPE_LRU, TYPE_LFU, TYPE_ARC, TYPE_TINYLFU, TYPE_RR}
+
+func TestExpireAfterAccess(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		clock := NewFakeClock()
+		gc, err := New(8).
+			EvictType(tp).
+			Clock(clock).
+			ExpireAfterAccess(time.Minute).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gc.Set("a", 1)
+		for i := 0; i < 5; i++ {
+			clock.Advance(50 * time.Second)
+			if _, err := gc.Get("a"); err != nil {
+				t.Fatalf("%s: reads should keep the entry alive: %v", tp, err)
+			}
+		}
+		clock.Advance(50 * time.Second)
+		if _, err := gc.GetIFPresent("a"); err != nil {
+			t.Fatalf("%s: GetIFPresent should keep the entry alive: %v", tp, err)
+		}
+
+		clock.Advance(61 * time.Second)
+		if _, err := gc.Get("a"); err != KeyNotFoundError {
+			t.Errorf("%s: err should be KeyNotFoundError, not %v", tp, err)
+		}
+	}
+}
+
+func TestExpireAfterAccessWithWriteCeiling(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		clock := NewFakeClock()
+		gc, err := New(8).
+			EvictType(tp).
+			Clock(clock).
+			ExpireAfterAccess(time.Minute).
+			Expiration(2 * time.Minute).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gc.Set("a", 1)
+		clock.Advance(50 * time.Second)
+		gc.Get("a")
+		clock.Advance(50 * time.Second)
+		gc.Get("a")
+		clock.Advance(21 * time.Second)
+		if _, err := gc.Get("a"); err != KeyNotFoundError {
+			t.Errorf("%s: reads should not extend past the write ceiling, err is %v", tp, err)
+		}
+
+		// Writing again resets the ceiling.
+		gc.Set("a", 2)
+		clock.Advance(100 * time.Second)
+		if _, err := gc.Get("a"); err != KeyNotFoundError {
+			t.Errorf("%s: err should be KeyNotFoundError, not %v", tp, err)
+		}
+	}
+}
+
+func TestSetWithExpireAfterAccess(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		clock := NewFakeClock()
+		gc, err := New(8).
+			EvictType(tp).
+			Clock(clock).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gc.SetWithExpireAfterAccess("sliding", 1, time.Minute)
+		gc.SetWithExpire("fixed", 2, time.Minute)
+		for i := 0; i < 3; i++ {
+			clock.Advance(40 * time.Second)
+			gc.Get("sliding")
+			gc.Get("fixed")
+		}
+
+		if v, err := gc.Get("sliding"); err != nil || v != 1 {
+			t.Errorf("%s: Get(sliding) = %v, %v", tp, v, err)
+		}
+		if _, err := gc.Get("fixed"); err != KeyNotFoundError {
+			t.Errorf("%s: err should be KeyNotFoundError, not %v", tp, err)
+		}
+
+		// A plain Set puts the entry back under the builder's policy.
+		gc.Set("sliding", 3)
+		clock.Advance(time.Hour)
+		if _, err := gc.Get("sliding"); err != nil {
+			t.Errorf("%s: unexpected error %v", tp, err)
+		}
+	}
+}
+
+func TestExpireAfterAccessJanitor(t *testing.T) {
+	clock := NewFakeClock()
+	gc, err := New(8).
+		LRU().
+		Clock(clock).
+		ExpireAfterAccess(time.Minute).
+		CleanupInterval(time.Second).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gc.Close()
+
+	gc.Set("a", 1)
+	gc.Set("b", 2)
+	for i := 0; i < 3; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(40 * time.Second)
+		clock.BlockUntil(1)
+		gc.Get("a")
+	}
+
+	if l := gc.Len(); l != 1 {
+		t.Errorf("%v != %v", l, 1)
+	}
+	if _, err := gc.GetIFPresent("a"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package gcache
+
+import (
+	"math"
+	"reflect"
+)
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// hashKey returns a 64-bit hash for an arbitrary (comparable) cache key.
+// Common key types are hashed directly; anything else goes through reflect,
+// see hashValue.
+func hashKey(key interface{}) uint64 {
+	switch k := key.(type) {
+	case string:
+		return hashString(k)
+	case int:
+		return mix64(uint64(k))
+	case int8:
+		return mix64(uint64(k))
+	case int16:
+		return mix64(uint64(k))
+	case int32:
+		return mix64(uint64(k))
+	case int64:
+		return mix64(uint64(k))
+	case uint:
+		return mix64(uint64(k))
+	case uint8:
+		return mix64(uint64(k))
+	case uint16:
+		return mix64(uint64(k))
+	case uint32:
+		return mix64(uint64(k))
+	case uint64:
+		return mix64(k)
+	case uintptr:
+		return mix64(uint64(k))
+	case float32:
+		return hashFloat(float64(k))
+	case float64:
+		return hashFloat(k)
+	case bool:
+		if k {
+			return mix64(1)
+		}
+		return mix64(0)
+	default:
+		return hashValue(reflect.ValueOf(key))
+	}
+}
+
+// hashValue hashes v consistently with ==: pointers, channels and funcs by
+// address, structs and arrays by their elements. The values of a type that
+// isn't comparable can't be keys, they all hash to 0.
+func hashValue(v reflect.Value) uint64 {
+	switch v.Kind() {
+	case reflect.String:
+		return hashString(v.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return mix64(uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return mix64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return hashFloat(v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		return combineHash(hashFloat(real(c)), hashFloat(imag(c)))
+	case reflect.Bool:
+		if v.Bool() {
+			return mix64(1)
+		}
+		return mix64(0)
+	case reflect.Pointer, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return mix64(uint64(v.Pointer()))
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		return hashValue(v.Elem())
+	case reflect.Struct:
+		h := uint64(fnvOffset64)
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).Name != "_" {
+				h = combineHash(h, hashValue(v.Field(i)))
+			}
+		}
+		return h
+	case reflect.Array:
+		h := uint64(fnvOffset64)
+		for i := 0; i < v.Len(); i++ {
+			h = combineHash(h, hashValue(v.Index(i)))
+		}
+		return h
+	default:
+		return 0
+	}
+}
+
+// hashFloat hashes 0 and -0, which are equal, the same.
+func hashFloat(f float64) uint64 {
+	if f == 0 {
+		f = 0
+	}
+	return mix64(math.Float64bits(f))
+}
+
+func combineHash(h, x uint64) uint64 {
+	return mix64((h ^ x) * fnvPrime64)
+}
+
+// hashString is the 64-bit FNV-1a hash of s.
+func hashString(s string) uint64 {
+	h := uint64(fnvOffset64)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= fnvPrime64
+	}
+	return mix64(h)
+}
+
+// mix64 is the splitmix64 finalizer, used to spread poorly distributed input.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
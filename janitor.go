@@ -0,0 +1,103 @@
+package gcache
+
+import (
+	"sync"
+	"time"
+)
+
+// expirer is implemented by every cache type, it removes key if its entry
+// has expired by now. Called with the cache lock held.
+type expirer interface {
+	expire(key interface{}, now time.Time)
+}
+
+// janitor proactively removes expired entries in the background, instead of
+// waiting for a read to stumble upon them.
+type janitor struct {
+	cache    expirer
+	interval time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func (c *baseCache) startJanitor(cache expirer, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	c.wheel = newTimerWheel(interval, c.clock.Now())
+	c.janitor = &janitor{
+		cache:    cache,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go c.runJanitor()
+}
+
+func (c *baseCache) runJanitor() {
+	defer close(c.janitor.done)
+	for {
+		select {
+		case <-c.janitor.stop:
+			return
+		case <-after(c.clock, c.janitor.interval):
+			c.cleanup()
+		}
+	}
+}
+
+// cleanup removes every entry whose timer fired.
+func (c *baseCache) cleanup() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	for _, key := range c.wheel.advance(now) {
+		c.janitor.cache.expire(key, now)
+	}
+}
+
+// scheduleExpiration keeps the janitor's timer of key in sync with the
+// entry's expiration. It is a no-op when no janitor is running.
+func (c *baseCache) scheduleExpiration(key interface{}, expiration *time.Time) {
+	if c.wheel == nil {
+		return
+	}
+	if expiration == nil {
+		c.wheel.cancel(key)
+		return
+	}
+	c.wheel.schedule(key, *expiration)
+}
+
+func (c *baseCache) resetExpirations() {
+	if c.wheel != nil {
+		c.wheel.reset()
+	}
+}
+
+// Close writes the last checkpoint, flushes the write-behind mutations and
+// stops the background janitor, memory controller and removal listener, if
+// any. The cache itself remains usable.
+func (c *baseCache) Close() error {
+	err := c.checkpoint.close()
+	c.closeNotifier()
+	if c.janitor != nil {
+		c.janitor.once.Do(func() {
+			close(c.janitor.stop)
+			<-c.janitor.done
+		})
+	}
+	if c.pressure != nil {
+		c.pressure.once.Do(func() {
+			close(c.pressure.stop)
+			<-c.pressure.done
+		})
+	}
+	if e := c.behind.close(); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,86 @@
+package gcache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJanitorRemovesExpiredEntries(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		clock := NewFakeClock()
+		var mu sync.Mutex
+		evicted := make(map[interface{}]interface{})
+		gc, err := New(16).
+			EvictType(tp).
+			Clock(clock).
+			Expiration(time.Minute).
+			CleanupInterval(time.Second).
+			EvictedFunc(func(key, value interface{}) {
+				mu.Lock()
+				evicted[key] = value
+				mu.Unlock()
+			}).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gc.Set("a", 1)
+		gc.Set("b", 2)
+		gc.SetWithExpire("c", 3, time.Hour)
+		gc.SetWithExpire("d", 4, time.Second)
+		gc.Set("d", 4)
+
+		clock.BlockUntil(1)
+		clock.Advance(2 * time.Minute)
+		// The janitor waits on the clock again once it is done.
+		clock.BlockUntil(1)
+
+		if l := gc.Len(); l != 1 {
+			t.Errorf("%s: %v != %v", tp, l, 1)
+		}
+		mu.Lock()
+		if len(evicted) != 3 || evicted["a"] != 1 || evicted["b"] != 2 || evicted["d"] != 4 {
+			t.Errorf("%s: unexpected evictions %v", tp, evicted)
+		}
+		mu.Unlock()
+
+		if err := gc.Close(); err != nil {
+			t.Error(err)
+		}
+		if err := gc.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestJanitorIgnoresRemovedEntries(t *testing.T) {
+	clock := NewFakeClock()
+	var evictions int
+	gc, err := New(16).
+		LRU().
+		Clock(clock).
+		CleanupInterval(time.Second).
+		EvictedFunc(func(key, value interface{}) {
+			evictions++
+		}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gc.Close()
+
+	gc.SetWithExpire("a", 1, time.Second)
+	gc.SetWithExpire("b", 2, time.Second)
+	gc.Remove("a")
+	gc.Purge()
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	clock.BlockUntil(1)
+
+	if evictions != 1 {
+		t.Errorf("%v != %v", evictions, 1)
+	}
+}
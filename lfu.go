@@ -2,6 +2,8 @@ package gcache
 
 import (
 	"container/list"
+	"context"
+	"io"
 	"time"
 )
 
@@ -17,19 +19,25 @@ type freqEntry struct {
 }
 
 type lfuItem struct {
-	clock       Clock
+	expiry
 	key         interface{}
 	value       interface{}
+	weight      int64
 	freqElement *list.Element
-	expiration  *time.Time
 }
 
 func newLFUCache(cb *CacheBuilder) *LFUCache {
 	c := &LFUCache{}
 	buildCache(&c.baseCache, cb)
+	if cb.bufferedReads {
+		c.reads = newReadBuffer(c.applyRead)
+	}
 
 	c.init()
 	c.loadGroup.cache = c
+	c.startJanitor(c, cb.cleanupInterval)
+	c.startMemoryController(c, cb.memoryPressure)
+	c.startCheckpointer(c, cb.persistence)
 	return c
 }
 
@@ -43,13 +51,18 @@ func (c *LFUCache) init() {
 }
 
 func (c *LFUCache) set(key, value interface{}) (interface{}, error) {
-	var err error
-	if c.serializeFunc != nil {
-		value, err = c.serializeFunc(key, value)
-		if err != nil {
-			return nil, err
-		}
+	value, w, err := c.prepare(key, value)
+	if err != nil {
+		return nil, err
 	}
+	return c.insert(key, value, w), nil
+}
+
+// insert stores value, prepared with its weight w.
+func (c *LFUCache) insert(key, value interface{}, w int64) interface{} {
+	c.drainReads()
+	c.forgetHeld(key)
+	c.makeRoom(c, key, w)
 
 	if c.addedFunc != nil {
 		defer c.addedFunc(key, value)
@@ -58,7 +71,7 @@ func (c *LFUCache) set(key, value interface{}) (interface{}, error) {
 	entry, exists := c.store[key]
 	if !exists {
 		if len(c.store) >= c.capacity {
-			c.evict(1)
+			c.evict(1, RemovalSize)
 		}
 
 		c.size++
@@ -67,7 +80,7 @@ func (c *LFUCache) set(key, value interface{}) (interface{}, error) {
 			key:         key,
 			value:       value,
 			freqElement: nil,
-			clock:       c.clock,
+			expiry:      expiry{clock: c.clock},
 		}
 
 		lfuEntry := c.freqList.Front()
@@ -77,14 +90,16 @@ func (c *LFUCache) set(key, value interface{}) (interface{}, error) {
 		c.store[key] = entry
 	}
 
+	if exists {
+		c.removed(key, entry.value, nil, RemovalReplaced)
+	}
 	entry.value = value
+	c.addWeight(w - entry.weight)
+	entry.weight = w
 
-	if c.expiration != nil {
-		t := c.clock.Now().Add(*c.expiration)
-		entry.expiration = &t
-	}
+	c.expireAfterWrite(key, &entry.expiry)
 
-	return entry, nil
+	return entry
 }
 
 // Set a new key-value pair
@@ -92,21 +107,48 @@ func (c *LFUCache) Set(key, value interface{}) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	_, err := c.set(key, value)
-	return err
+	stored, w, err := c.prepare(key, value)
+	if err != nil {
+		return err
+	}
+	if err := c.beforeSet(key, value); err != nil {
+		return err
+	}
+	c.insert(key, stored, w)
+	return nil
 }
 
 func (c *LFUCache) SetWithExpire(key, value interface{}, expiration time.Duration) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	item, err := c.set(key, value)
+	stored, w, err := c.prepare(key, value)
 	if err != nil {
 		return err
 	}
+	if err := c.beforeSetWithExpire(key, value, expiration); err != nil {
+		return err
+	}
+	item := c.insert(key, stored, w)
 
-	t := c.clock.Now().Add(expiration)
-	item.(*lfuItem).expiration = &t
+	c.expireAt(key, &item.(*lfuItem).expiry, expiration)
+	return nil
+}
+
+func (c *LFUCache) SetWithExpireAfterAccess(key, value interface{}, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, w, err := c.prepare(key, value)
+	if err != nil {
+		return err
+	}
+	if err := c.beforeSetWithExpireAfterAccess(key, value, expiration); err != nil {
+		return err
+	}
+	item := c.insert(key, stored, w)
+
+	c.expireAfterAccess(key, &item.(*lfuItem).expiry, expiration)
 	return nil
 }
 
@@ -121,7 +163,7 @@ func (c *LFUCache) get(key interface{}, onLoad bool) (interface{}, error) {
 	}
 
 	if item.isExpired(nil) {
-		c.removeItem(item)
+		c.removeItem(item, RemovalExpired)
 		return nil, KeyNotFoundError
 	}
 
@@ -130,6 +172,8 @@ func (c *LFUCache) get(key interface{}, onLoad bool) (interface{}, error) {
 	v := item.value
 	if !onLoad {
 		c.stats.IncrHitCount()
+		c.accessed(key, &item.expiry)
+		c.refreshIfStale(key, &item.expiry)
 	}
 
 	if c.deserializeFunc != nil {
@@ -139,46 +183,77 @@ func (c *LFUCache) get(key interface{}, onLoad bool) (interface{}, error) {
 	return v, nil
 }
 
-func (c *LFUCache) getWithLoader(key interface{}, isWait bool) (interface{}, error) {
+func (c *LFUCache) storeEntry(key, value interface{}, expiration, accessTTL *time.Duration) error {
+	item, err := c.set(key, value)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case expiration != nil:
+		c.expireAt(key, &item.(*lfuItem).expiry, *expiration)
+	case accessTTL != nil:
+		c.expireAfterAccess(key, &item.(*lfuItem).expiry, *accessTTL)
+	}
+	return nil
+}
+
+func (c *LFUCache) removeEntry(key interface{}) {
+	c.remove(key)
+}
+
+func (c *LFUCache) expiryOf(key interface{}) (*expiry, bool) {
+	if item, ok := c.store[key]; ok {
+		return &item.expiry, true
+	}
+	return nil, false
+}
+
+func (c *LFUCache) getWithLoader(ctx context.Context, key interface{}, isWait bool) (interface{}, error) {
 	if c.loaderExpireFunc == nil {
 		return nil, KeyNotFoundError
 	}
-	value, _, err := c.load(key, func(v interface{}, expiration *time.Duration, e error) (interface{}, error) {
+	value, _, err := c.load(ctx, key, func(v interface{}, expiration *time.Duration, e error) (interface{}, error) {
 		if e != nil {
 			return nil, e
 		}
 
-		err := c.Set(key, v)
-		if err != nil {
+		if err := c.setLoaded(key, v, expiration); err != nil {
 			return nil, err
 		}
-
 		return v, nil
 	}, isWait)
-	if err != nil {
-		return nil, err
-	}
-	return value, nil
+	return value, err
 }
 
 func (c *LFUCache) Get(key interface{}) (interface{}, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *LFUCache) GetContext(ctx context.Context, key interface{}) (interface{}, error) {
+	if v, ok := c.getShared(key); ok {
+		return c.deserialize(key, v)
+	}
 	c.mu.Lock()
 	v, err := c.get(key, false)
 	c.mu.Unlock()
 
 	if err == KeyNotFoundError {
-		return c.getWithLoader(key, true)
+		return c.getWithLoader(ctx, key, true)
 	}
 	return v, err
 }
 
 func (c *LFUCache) GetIFPresent(key interface{}) (interface{}, error) {
+	if v, ok := c.getShared(key); ok {
+		return c.deserialize(key, v)
+	}
 	c.mu.Lock()
 	v, err := c.get(key, false)
 	c.mu.Unlock()
 
 	if err == KeyNotFoundError {
-		return c.getWithLoader(key, false)
+		return c.getWithLoader(context.Background(), key, false)
 	}
 	return v, err
 }
@@ -200,16 +275,26 @@ func (c *LFUCache) GetALL() map[interface{}]interface{} {
 
 func (c *LFUCache) remove(key interface{}) error {
 	if item, ok := c.store[key]; ok {
-		c.removeItem(item)
+		c.removeItem(item, RemovalExplicit)
 		return nil
 	}
 	return KeyNotFoundError
 }
 
+func (c *LFUCache) expire(key interface{}, now time.Time) {
+	if item, ok := c.store[key]; ok && item.isExpired(&now) {
+		c.removeItem(item, RemovalExpired)
+	}
+}
+
 func (c *LFUCache) Remove(key interface{}) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if err := c.beforeRemove(key); err != nil {
+		return err
+	}
+	c.forgetHeld(key)
 	return c.remove(key)
 }
 
@@ -217,12 +302,20 @@ func (c *LFUCache) Purge() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.purgeVisitorFunc != nil {
+	c.logPurge()
+	c.purgeHeld()
+	c.purge()
+}
+
+func (c *LFUCache) purge() {
+	if c.purgeVisitorFunc != nil || c.listensRemovals() {
 		for key, item := range c.store {
-			c.purgeVisitorFunc(key, item.value)
+			c.purged(key, item.value)
 		}
 	}
 
+	c.resetExpirations()
+	c.resetWeight()
 	c.init()
 }
 
@@ -252,9 +345,18 @@ func (c *LFUCache) Keys() []interface{} {
 }
 
 func (c *LFUCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return len(c.store)
 }
 
+func (c *LFUCache) Resize(capacity int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.setCapacity(c, capacity)
+}
+
 func (c *LFUCache) increment(item *lfuItem) {
 	currentFreqElement := item.freqElement
 	currentFreqEntry := currentFreqElement.Value.(*freqEntry)
@@ -272,7 +374,7 @@ func (c *LFUCache) increment(item *lfuItem) {
 	item.freqElement = nextFreqElement
 }
 
-func (c *LFUCache) evict(count int) {
+func (c *LFUCache) evict(count int, cause RemovalCause) {
 	entry := c.freqList.Front()
 	for i := 0; i < count; {
 		if entry == nil {
@@ -282,7 +384,7 @@ func (c *LFUCache) evict(count int) {
 				if i >= count {
 					return
 				}
-				c.removeItem(item)
+				c.removeItem(item, cause)
 				i++
 			}
 			entry = entry.Next()
@@ -290,23 +392,30 @@ func (c *LFUCache) evict(count int) {
 	}
 }
 
-func (c *LFUCache) removeItem(item *lfuItem) {
-	delete(c.store, item.key)
-	delete(item.freqElement.Value.(*freqEntry).items, item)
-	if c.evictedFunc != nil {
-		c.evictedFunc(item.key, item.value)
+func (c *LFUCache) weightOf(key interface{}) int64 {
+	if item, ok := c.store[key]; ok {
+		return item.weight
 	}
+	return 0
 }
 
-func (it *lfuItem) isExpired(now *time.Time) bool {
-	if it.expiration == nil {
-		return false
-	}
-	if now == nil {
-		t := it.clock.Now()
-		now = &t
+func (c *LFUCache) evictOne(cause RemovalCause) bool {
+	n := len(c.store)
+	c.evict(1, cause)
+	return len(c.store) < n
+}
+
+func (c *LFUCache) resize(capacity int) {
+	c.capacity = capacity
+	for len(c.store) > capacity && c.evictOne(RemovalResized) {
 	}
-	return it.expiration.Before(*now)
+}
+
+func (c *LFUCache) removeItem(item *lfuItem, cause RemovalCause) {
+	delete(c.store, item.key)
+	delete(item.freqElement.Value.(*freqEntry).items, item)
+	c.addWeight(-item.weight)
+	c.removed(item.key, item.value, &item.expiry, cause)
 }
 
 func (c *LFUCache) Debug() map[string][]int {
@@ -315,6 +424,92 @@ func (c *LFUCache) Debug() map[string][]int {
 	return d
 }
 
+// getShared serves a hit under the shared lock when BufferedReads is set.
+func (c *LFUCache) getShared(key interface{}) (interface{}, bool) {
+	if c.reads == nil {
+		return nil, false
+	}
+	c.mu.RLock()
+	item, ok := c.store[key]
+	if !ok {
+		c.mu.RUnlock()
+		return nil, false
+	}
+	if !c.sharedRead(&item.expiry) {
+		c.mu.RUnlock()
+		return nil, false
+	}
+	v := item.value
+	c.mu.RUnlock()
+
+	c.stats.IncrHitCount()
+	c.recordRead(item)
+	return v, true
+}
+
+// applyRead replays a read buffered by getShared.
+func (c *LFUCache) applyRead(v interface{}) {
+	item := v.(*lfuItem)
+	if c.store[item.key] == item {
+		c.increment(item)
+	}
+}
+
+func (c *LFUCache) Snapshot(w io.Writer) error {
+	return c.snapshot(c, TYPE_LFU, w)
+}
+
+func (c *LFUCache) Restore(r io.Reader) error {
+	return c.restore(c, TYPE_LFU, r)
+}
+
+func (c *LFUCache) exportEntries(at time.Time) ([]SnapshotEntry, int) {
+	entries := make([]SnapshotEntry, 0, len(c.store))
+	for fe := c.freqList.Front(); fe != nil; fe = fe.Next() {
+		freq := fe.Value.(*freqEntry)
+		for item := range freq.items {
+			entry := SnapshotEntry{Key: item.key, Value: item.value, Freq: freq.freq}
+			item.export(at, &entry)
+			entries = append(entries, entry)
+		}
+	}
+	return entries, 0
+}
+
+func (c *LFUCache) importEntries(at time.Time, entries []SnapshotEntry, _ int) {
+	for i := range entries {
+		e := &entries[i]
+		w, ok := c.importWeight(e)
+		if !ok {
+			continue
+		}
+
+		// increment expects a node for every frequency.
+		fe := c.freqList.Front()
+		for fe.Value.(*freqEntry).freq < e.Freq {
+			next := fe.Next()
+			if next == nil {
+				next = c.freqList.InsertAfter(&freqEntry{
+					freq:  fe.Value.(*freqEntry).freq + 1,
+					items: make(map[*lfuItem]struct{}),
+				}, fe)
+			}
+			fe = next
+		}
+
+		item := &lfuItem{
+			expiry:      expiry{clock: c.clock},
+			key:         e.Key,
+			value:       e.Value,
+			weight:      w,
+			freqElement: fe,
+		}
+		item.restore(at, e)
+		fe.Value.(*freqEntry).items[item] = struct{}{}
+		c.store[e.Key] = item
+	}
+}
+
 func (c *LFUCache) unsafeGet(key interface{}, onLoad bool) (interface{}, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -2,6 +2,8 @@ package gcache
 
 import (
 	"container/list"
+	"context"
+	"io"
 	"time"
 )
 
@@ -12,18 +14,24 @@ type LRUCache struct {
 }
 
 type lruItem struct {
-	clock      Clock
-	key        interface{}
-	value      interface{}
-	expiration *time.Time
+	expiry
+	key    interface{}
+	value  interface{}
+	weight int64
 }
 
 func newLRUCache(cb *CacheBuilder) *LRUCache {
 	c := &LRUCache{}
 	buildCache(&c.baseCache, cb)
+	if cb.bufferedReads {
+		c.reads = newReadBuffer(c.applyRead)
+	}
 
 	c.init()
 	c.loadGroup.cache = c
+	c.startJanitor(c, cb.cleanupInterval)
+	c.startMemoryController(c, cb.memoryPressure)
+	c.startCheckpointer(c, cb.persistence)
 	return c
 }
 
@@ -33,62 +41,94 @@ func (c *LRUCache) init() {
 }
 
 func (c *LRUCache) set(key, value interface{}) (interface{}, error) {
-	var err error
-	if c.serializeFunc != nil {
-		value, err = c.serializeFunc(key, value)
-		if err != nil {
-			return nil, err
-		}
+	value, w, err := c.prepare(key, value)
+	if err != nil {
+		return nil, err
 	}
+	return c.insert(key, value, w), nil
+}
+
+// insert stores value, prepared with its weight w.
+func (c *LRUCache) insert(key, value interface{}, w int64) interface{} {
+	c.drainReads()
+	c.forgetHeld(key)
+	c.makeRoom(c, key, w)
 
 	var item *lruItem
 	if it, ok := c.store[key]; ok {
 		c.evictList.MoveToFront(it)
 		item = it.Value.(*lruItem)
+		c.removed(key, item.value, nil, RemovalReplaced)
 		item.value = value
 	} else {
 		if c.evictList.Len() >= c.capacity {
 			c.evict(1)
 		}
 		item = &lruItem{
-			clock: c.clock,
-			key:   key,
-			value: value,
+			expiry: expiry{clock: c.clock},
+			key:    key,
+			value:  value,
 		}
 		c.store[key] = c.evictList.PushFront(item)
 	}
 
-	if c.expiration != nil {
-		t := c.clock.Now().Add(*c.expiration)
-		item.expiration = &t
-	}
+	c.addWeight(w - item.weight)
+	item.weight = w
+	c.expireAfterWrite(key, &item.expiry)
 
 	if c.addedFunc != nil {
 		c.addedFunc(key, value)
 	}
 
-	return item, nil
+	return item
 }
 
 func (c *LRUCache) Set(key, value interface{}) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	_, err := c.set(key, value)
-	return err
+	stored, w, err := c.prepare(key, value)
+	if err != nil {
+		return err
+	}
+	if err := c.beforeSet(key, value); err != nil {
+		return err
+	}
+	c.insert(key, stored, w)
+	return nil
 }
 
 func (c *LRUCache) SetWithExpire(key, value interface{}, expiration time.Duration) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	item, err := c.set(key, value)
+	stored, w, err := c.prepare(key, value)
 	if err != nil {
 		return err
 	}
+	if err := c.beforeSetWithExpire(key, value, expiration); err != nil {
+		return err
+	}
+	item := c.insert(key, stored, w)
 
-	t := c.clock.Now().Add(expiration)
-	item.(*lruItem).expiration = &t
+	c.expireAt(key, &item.(*lruItem).expiry, expiration)
+	return nil
+}
+
+func (c *LRUCache) SetWithExpireAfterAccess(key, value interface{}, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, w, err := c.prepare(key, value)
+	if err != nil {
+		return err
+	}
+	if err := c.beforeSetWithExpireAfterAccess(key, value, expiration); err != nil {
+		return err
+	}
+	item := c.insert(key, stored, w)
+
+	c.expireAfterAccess(key, &item.(*lruItem).expiry, expiration)
 	return nil
 }
 
@@ -104,7 +144,7 @@ func (c *LRUCache) get(key interface{}, onLoad bool) (interface{}, error) {
 
 	item := entry.Value.(*lruItem)
 	if item.isExpired(nil) {
-		c.removeElement(entry)
+		c.removeElement(entry, RemovalExpired)
 		if !onLoad {
 			c.stats.IncrMissCount()
 		}
@@ -115,6 +155,8 @@ func (c *LRUCache) get(key interface{}, onLoad bool) (interface{}, error) {
 	c.evictList.MoveToFront(entry)
 	if !onLoad {
 		c.stats.IncrHitCount()
+		c.accessed(key, &item.expiry)
+		c.refreshIfStale(key, &item.expiry)
 	}
 
 	if c.deserializeFunc != nil {
@@ -124,47 +166,80 @@ func (c *LRUCache) get(key interface{}, onLoad bool) (interface{}, error) {
 	return item.value, nil
 }
 
-func (c *LRUCache) getWithLoader(key interface{}, isWait bool) (interface{}, error) {
+// storeEntry sets key without logging it or writing it through, with the
+// cache lock held.
+func (c *LRUCache) storeEntry(key, value interface{}, expiration, accessTTL *time.Duration) error {
+	item, err := c.set(key, value)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case expiration != nil:
+		c.expireAt(key, &item.(*lruItem).expiry, *expiration)
+	case accessTTL != nil:
+		c.expireAfterAccess(key, &item.(*lruItem).expiry, *accessTTL)
+	}
+	return nil
+}
+
+func (c *LRUCache) removeEntry(key interface{}) {
+	c.remove(key)
+}
+
+func (c *LRUCache) expiryOf(key interface{}) (*expiry, bool) {
+	if e, ok := c.store[key]; ok {
+		return &e.Value.(*lruItem).expiry, true
+	}
+	return nil, false
+}
+
+func (c *LRUCache) getWithLoader(ctx context.Context, key interface{}, isWait bool) (interface{}, error) {
 	if c.loaderExpireFunc == nil {
 		return nil, KeyNotFoundError
 	}
 
-	value, _, err := c.load(key, func(v interface{}, expiration *time.Duration, e error) (interface{}, error) {
+	value, _, err := c.load(ctx, key, func(v interface{}, expiration *time.Duration, e error) (interface{}, error) {
 		if e != nil {
 			return nil, e
 		}
 
-		err := c.Set(key, v)
-		if err != nil {
+		if err := c.setLoaded(key, v, expiration); err != nil {
 			return nil, err
 		}
-
 		return v, nil
 	}, isWait)
-	if err != nil {
-		return nil, err
-	}
-	return value, nil
+	return value, err
 }
 
 func (c *LRUCache) Get(key interface{}) (interface{}, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *LRUCache) GetContext(ctx context.Context, key interface{}) (interface{}, error) {
+	if v, ok := c.getShared(key); ok {
+		return c.deserialize(key, v)
+	}
 	c.mu.Lock()
 	v, err := c.get(key, false)
 	c.mu.Unlock()
 
 	if err == KeyNotFoundError {
-		return c.getWithLoader(key, true)
+		return c.getWithLoader(ctx, key, true)
 	}
 	return v, err
 }
 
 func (c *LRUCache) GetIFPresent(key interface{}) (interface{}, error) {
+	if v, ok := c.getShared(key); ok {
+		return c.deserialize(key, v)
+	}
 	c.mu.Lock()
 	v, err := c.get(key, false)
 	c.mu.Unlock()
 
 	if err == KeyNotFoundError {
-		return c.getWithLoader(key, false)
+		return c.getWithLoader(context.Background(), key, false)
 	}
 	return v, err
 }
@@ -186,16 +261,26 @@ func (c *LRUCache) GetALL() map[interface{}]interface{} {
 
 func (c *LRUCache) remove(key interface{}) error {
 	if ent, ok := c.store[key]; ok {
-		c.removeElement(ent)
+		c.removeElement(ent, RemovalExplicit)
 		return nil
 	}
 	return KeyNotFoundError
 }
 
+func (c *LRUCache) expire(key interface{}, now time.Time) {
+	if e, ok := c.store[key]; ok && e.Value.(*lruItem).isExpired(&now) {
+		c.removeElement(e, RemovalExpired)
+	}
+}
+
 func (c *LRUCache) Remove(key interface{}) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if err := c.beforeRemove(key); err != nil {
+		return err
+	}
+	c.forgetHeld(key)
 	return c.remove(key)
 }
 
@@ -203,14 +288,22 @@ func (c *LRUCache) Purge() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.purgeVisitorFunc != nil {
+	c.logPurge()
+	c.purgeHeld()
+	c.purge()
+}
+
+func (c *LRUCache) purge() {
+	if c.purgeVisitorFunc != nil || c.listensRemovals() {
 		for key, item := range c.store {
 			it := item.Value.(*lruItem)
 			v := it.value
-			c.purgeVisitorFunc(key, v)
+			c.purged(key, v)
 		}
 	}
 
+	c.resetExpirations()
+	c.resetWeight()
 	c.init()
 }
 func (c *LRUCache) keys() []interface{} {
@@ -239,39 +332,57 @@ func (c *LRUCache) Keys() []interface{} {
 }
 
 func (c *LRUCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return len(c.store)
 }
 
+func (c *LRUCache) Resize(capacity int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.setCapacity(c, capacity)
+}
+
 func (c *LRUCache) evict(count int) {
 	for i := 0; i < count; i++ {
 		ent := c.evictList.Back()
 		if ent == nil {
 			return
 		} else {
-			c.removeElement(ent)
+			c.removeElement(ent, RemovalSize)
 		}
 	}
 }
 
-func (c *LRUCache) removeElement(e *list.Element) {
-	c.evictList.Remove(e)
-	entry := e.Value.(*lruItem)
-	delete(c.store, entry.key)
-	if c.evictedFunc != nil {
-		entry := e.Value.(*lruItem)
-		c.evictedFunc(entry.key, entry.value)
+func (c *LRUCache) weightOf(key interface{}) int64 {
+	if e, ok := c.store[key]; ok {
+		return e.Value.(*lruItem).weight
 	}
+	return 0
 }
 
-func (it *lruItem) isExpired(now *time.Time) bool {
-	if it.expiration == nil {
+func (c *LRUCache) evictOne(cause RemovalCause) bool {
+	e := c.evictList.Back()
+	if e == nil {
 		return false
 	}
-	if now == nil {
-		t := it.clock.Now()
-		now = &t
+	c.removeElement(e, cause)
+	return true
+}
+
+func (c *LRUCache) resize(capacity int) {
+	c.capacity = capacity
+	for c.evictList.Len() > capacity && c.evictOne(RemovalResized) {
 	}
-	return it.expiration.Before(*now)
+}
+
+func (c *LRUCache) removeElement(e *list.Element, cause RemovalCause) {
+	c.evictList.Remove(e)
+	entry := e.Value.(*lruItem)
+	delete(c.store, entry.key)
+	c.addWeight(-entry.weight)
+	c.removed(entry.key, entry.value, &entry.expiry, cause)
 }
 
 func (c *LRUCache) Debug() map[string][]int {
@@ -280,6 +391,75 @@ func (c *LRUCache) Debug() map[string][]int {
 	return d
 }
 
+// getShared serves a hit under the shared lock when BufferedReads is set.
+func (c *LRUCache) getShared(key interface{}) (interface{}, bool) {
+	if c.reads == nil {
+		return nil, false
+	}
+	c.mu.RLock()
+	e, ok := c.store[key]
+	if !ok {
+		c.mu.RUnlock()
+		return nil, false
+	}
+	item := e.Value.(*lruItem)
+	if !c.sharedRead(&item.expiry) {
+		c.mu.RUnlock()
+		return nil, false
+	}
+	v := item.value
+	c.mu.RUnlock()
+
+	c.stats.IncrHitCount()
+	c.recordRead(item)
+	return v, true
+}
+
+// applyRead replays a read buffered by getShared.
+func (c *LRUCache) applyRead(v interface{}) {
+	item := v.(*lruItem)
+	if e, ok := c.store[item.key]; ok && e.Value == item {
+		c.evictList.MoveToFront(e)
+	}
+}
+
+func (c *LRUCache) Snapshot(w io.Writer) error {
+	return c.snapshot(c, TYPE_LRU, w)
+}
+
+func (c *LRUCache) Restore(r io.Reader) error {
+	return c.restore(c, TYPE_LRU, r)
+}
+
+func (c *LRUCache) exportEntries(at time.Time) ([]SnapshotEntry, int) {
+	entries := make([]SnapshotEntry, 0, len(c.store))
+	for e := c.evictList.Back(); e != nil; e = e.Prev() {
+		item := e.Value.(*lruItem)
+		entry := SnapshotEntry{Key: item.key, Value: item.value}
+		item.export(at, &entry)
+		entries = append(entries, entry)
+	}
+	return entries, 0
+}
+
+func (c *LRUCache) importEntries(at time.Time, entries []SnapshotEntry, _ int) {
+	for i := range entries {
+		e := &entries[i]
+		w, ok := c.importWeight(e)
+		if !ok {
+			continue
+		}
+		item := &lruItem{
+			expiry: expiry{clock: c.clock},
+			key:    e.Key,
+			value:  e.Value,
+			weight: w,
+		}
+		item.restore(at, e)
+		c.store[e.Key] = c.evictList.PushFront(item)
+	}
+}
+
 func (c *LRUCache) unsafeGet(key interface{}, onLoad bool) (interface{}, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
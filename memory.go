@@ -0,0 +1,165 @@
+package gcache
+
+import (
+	"math"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+// MemorySource reports how much memory the process uses, and the limit it
+// should stay under. A zero limit means there is none.
+type MemorySource func() (used, limit uint64)
+
+// MemoryPressure configures a controller that shrinks the cache when the
+// process gets close to its memory limit, and grows it back once memory is
+// available again. Zero fields take their default value.
+type MemoryPressure struct {
+	// High is the fraction of the limit above which the cache shrinks.
+	// Defaults to 0.9.
+	High float64
+	// Low is the fraction of the limit below which the cache grows back.
+	// Defaults to 0.7.
+	Low float64
+	// Step is the fraction of the configured capacity given back or taken
+	// at every check. Defaults to 0.1.
+	Step float64
+	// Floor is the smallest fraction of the configured capacity the cache
+	// is shrunk to. Defaults to 0.1.
+	Floor float64
+	// Interval is the time between two checks. Defaults to one second.
+	Interval time.Duration
+	// Source defaults to RuntimeMemory.
+	Source MemorySource
+}
+
+// RuntimeMemory is the MemorySource comparing the memory mapped by the Go
+// runtime and not yet returned to the OS to GOMEMLIMIT.
+func RuntimeMemory() (used, limit uint64) {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+		{Name: "/gc/gomemlimit:bytes"},
+	}
+	metrics.Read(samples)
+	for _, s := range samples {
+		if s.Value.Kind() != metrics.KindUint64 {
+			return 0, 0
+		}
+	}
+
+	used = samples[0].Value.Uint64() - samples[1].Value.Uint64()
+	limit = samples[2].Value.Uint64()
+	if limit == math.MaxInt64 {
+		// No GOMEMLIMIT set.
+		limit = 0
+	}
+	return used, limit
+}
+
+func (mp MemoryPressure) withDefaults() MemoryPressure {
+	if mp.High <= 0 {
+		mp.High = 0.9
+	}
+	if mp.Low <= 0 {
+		mp.Low = 0.7
+	}
+	if mp.Step <= 0 {
+		mp.Step = 0.1
+	}
+	if mp.Floor <= 0 {
+		mp.Floor = 0.1
+	}
+	if mp.Interval <= 0 {
+		mp.Interval = time.Second
+	}
+	if mp.Source == nil {
+		mp.Source = RuntimeMemory
+	}
+	return mp
+}
+
+// memoryController scales the capacity and MaxWeight of a cache, from the
+// values it was built with, according to the memory pressure.
+type memoryController struct {
+	cache     resizer
+	config    MemoryPressure
+	capacity  int
+	maxWeight int64
+	ratio     float64
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func (c *baseCache) startMemoryController(cache resizer, mp *MemoryPressure) {
+	if mp == nil {
+		return
+	}
+	c.pressure = &memoryController{
+		cache:     cache,
+		config:    mp.withDefaults(),
+		capacity:  c.capacity,
+		maxWeight: c.maxWeight,
+		ratio:     1,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go c.runMemoryController()
+}
+
+func (c *baseCache) runMemoryController() {
+	defer close(c.pressure.done)
+	for {
+		select {
+		case <-c.pressure.stop:
+			return
+		case <-after(c.clock, c.pressure.config.Interval):
+			c.checkMemory()
+		}
+	}
+}
+
+// checkMemory moves the cache one step towards the size the current memory
+// usage calls for.
+func (c *baseCache) checkMemory() {
+	mc := c.pressure
+	used, limit := mc.config.Source()
+	if limit == 0 {
+		return
+	}
+
+	ratio := mc.ratio
+	usage := float64(used) / float64(limit)
+	switch {
+	case usage > mc.config.High:
+		ratio = math.Max(ratio-mc.config.Step, mc.config.Floor)
+	case usage < mc.config.Low:
+		ratio = math.Min(ratio+mc.config.Step, 1)
+	}
+	if ratio == mc.ratio {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	mc.ratio = ratio
+	c.scale()
+}
+
+// scale applies the controller's ratio to the cache limits.
+func (c *baseCache) scale() {
+	mc := c.pressure
+	c.drainReads()
+
+	if mc.capacity > 0 {
+		capacity := int(math.Ceil(float64(mc.capacity) * mc.ratio))
+		mc.cache.resize(maxInt(capacity, 1))
+	}
+	if mc.maxWeight > 0 {
+		c.maxWeight = int64(math.Ceil(float64(mc.maxWeight) * mc.ratio))
+		for c.Weight() > c.maxWeight && mc.cache.evictOne(RemovalResized) {
+		}
+	}
+}
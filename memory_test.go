@@ -0,0 +1,141 @@
+package gcache
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeMemory struct {
+	used int64
+}
+
+func (m *fakeMemory) source() (uint64, uint64) {
+	return uint64(atomic.LoadInt64(&m.used)), 100
+}
+
+func (m *fakeMemory) set(used int64) {
+	atomic.StoreInt64(&m.used, used)
+}
+
+// checkMemoryOnce lets the memory controller waiting on clock run one check.
+func checkMemoryOnce(clock FakeClock) {
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+}
+
+func TestMemoryPressureShrink(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		clock := NewFakeClock()
+		mem := &fakeMemory{}
+		var evicted int64
+		gc, err := New(100).
+			EvictType(tp).
+			Clock(clock).
+			EvictedFunc(func(key, value interface{}) {
+				atomic.AddInt64(&evicted, 1)
+			}).
+			MemoryPressure(MemoryPressure{Floor: 0.5, Source: mem.source}).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 100; i++ {
+			gc.Set(i, i)
+		}
+
+		mem.set(95)
+		checkMemoryOnce(clock)
+		if l := gc.Len(); l != 90 {
+			t.Errorf("%s: %v != %v", tp, l, 90)
+		}
+		if n := atomic.LoadInt64(&evicted); n != 10 {
+			t.Errorf("%s: %v entries reported evicted, expected 10", tp, n)
+		}
+
+		for i := 0; i < 10; i++ {
+			checkMemoryOnce(clock)
+		}
+		if l := gc.Len(); l != 50 {
+			t.Errorf("%s: the cache should stop at its floor, got %v entries", tp, l)
+		}
+
+		// Between the thresholds, nothing changes.
+		mem.set(80)
+		checkMemoryOnce(clock)
+		for i := 100; i < 200; i++ {
+			gc.Set(i, i)
+		}
+		if l := gc.Len(); l > 50 {
+			t.Errorf("%s: %v entries exceed the shrunk capacity", tp, l)
+		}
+
+		mem.set(10)
+		for i := 0; i < 5; i++ {
+			checkMemoryOnce(clock)
+		}
+		for i := 200; i < 400; i++ {
+			gc.Set(i, i)
+		}
+		if l := gc.Len(); l != 100 {
+			t.Errorf("%s: the cache should be back to its capacity, got %v entries", tp, l)
+		}
+		gc.Close()
+	}
+}
+
+func TestMemoryPressureWeight(t *testing.T) {
+	clock := NewFakeClock()
+	mem := &fakeMemory{used: 99}
+	gc, err := New(100).
+		LRU().
+		Clock(clock).
+		Weigher(intWeigher).
+		MaxWeight(1000).
+		MemoryPressure(MemoryPressure{Step: 0.5, Source: mem.source}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gc.Close()
+
+	for i := 0; i < 10; i++ {
+		gc.Set(i, 100)
+	}
+	checkMemoryOnce(clock)
+	if w := gc.Weight(); w != 500 {
+		t.Errorf("%v != %v", w, 500)
+	}
+}
+
+func TestMemoryPressureNoLimit(t *testing.T) {
+	clock := NewFakeClock()
+	gc, err := New(10).
+		LRU().
+		Clock(clock).
+		MemoryPressure(MemoryPressure{Source: func() (uint64, uint64) {
+			return 1 << 40, 0
+		}}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gc.Close()
+
+	for i := 0; i < 10; i++ {
+		gc.Set(i, i)
+	}
+	checkMemoryOnce(clock)
+	if l := gc.Len(); l != 10 {
+		t.Errorf("%v != %v", l, 10)
+	}
+}
+
+func TestRuntimeMemory(t *testing.T) {
+	used, _ := RuntimeMemory()
+	if used == 0 {
+		t.Error("expected some memory to be in use")
+	}
+}
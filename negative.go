@@ -0,0 +1,140 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// NegativeCache configures the caching of loader errors, see
+// CacheBuilder.NegativeCache.
+type NegativeCache struct {
+	// TTL is how long an error is returned before the loader is called
+	// again for the same key.
+	TTL time.Duration
+	// Cacheable selects the errors to cache, all of them when nil. Use
+	// errors.Is to only cache a "not found" sentinel. Context errors are
+	// never cached.
+	Cacheable func(error) bool
+}
+
+// negativeCache holds the loader errors of a cache, guarded by its lock.
+type negativeCache struct {
+	config NegativeCache
+	errors *heldEntries
+}
+
+func newNegativeCache(config NegativeCache, capacity int) *negativeCache {
+	return &negativeCache{config: config, errors: newHeldEntries(capacity)}
+}
+
+func (n *negativeCache) get(key interface{}, now time.Time) (error, bool) {
+	err, ok := n.errors.get(key, now)
+	if !ok {
+		return nil, false
+	}
+	return err.(error), true
+}
+
+func (n *negativeCache) add(key interface{}, err error, now time.Time) {
+	if n.config.Cacheable != nil && !n.config.Cacheable(err) {
+		return
+	}
+	n.errors.add(key, err, now.Add(n.config.TTL), now)
+}
+
+func (n *negativeCache) forget(key interface{}) {
+	n.errors.forget(key)
+}
+
+func (n *negativeCache) purge() {
+	n.errors.purge()
+}
+
+type heldEntry struct {
+	value      interface{}
+	expiration time.Time
+}
+
+// heldEntries holds values next to the entries of a cache until they
+// expire, bounded by the capacity of the cache.
+type heldEntries struct {
+	capacity int
+	entries  map[interface{}]heldEntry
+}
+
+func newHeldEntries(capacity int) *heldEntries {
+	return &heldEntries{capacity: capacity, entries: make(map[interface{}]heldEntry)}
+}
+
+func (h *heldEntries) get(key interface{}, now time.Time) (interface{}, bool) {
+	entry, ok := h.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !now.Before(entry.expiration) {
+		delete(h.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (h *heldEntries) add(key, value interface{}, expiration, now time.Time) {
+	if _, ok := h.entries[key]; !ok && h.capacity > 0 && len(h.entries) >= h.capacity {
+		h.evict(now)
+	}
+	h.entries[key] = heldEntry{value: value, expiration: expiration}
+}
+
+// evict drops the expired values, or an arbitrary one if none expired.
+func (h *heldEntries) evict(now time.Time) {
+	for key, entry := range h.entries {
+		if !now.Before(entry.expiration) {
+			delete(h.entries, key)
+		}
+	}
+	if len(h.entries) < h.capacity {
+		return
+	}
+	for key := range h.entries {
+		delete(h.entries, key)
+		return
+	}
+}
+
+func (h *heldEntries) forget(key interface{}) {
+	delete(h.entries, key)
+}
+
+func (h *heldEntries) purge() {
+	h.entries = make(map[interface{}]heldEntry)
+}
+
+// negativeHit returns the cached loader error of key, if any.
+func (c *baseCache) negativeHit(key interface{}) (error, bool) {
+	if c.negatives == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err, ok := c.negatives.get(key, c.clock.Now())
+	if ok {
+		c.stats.IncrNegativeHitCount()
+	}
+	return err, ok
+}
+
+// cacheError caches an error of the loader. The errors of the circuit
+// breaker and of a done context say nothing about key, they aren't cached.
+func (c *baseCache) cacheError(key interface{}, err error) {
+	if c.negatives == nil || err == CircuitOpenError {
+		return
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.negatives.add(key, err, c.clock.Now())
+}
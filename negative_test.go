@@ -0,0 +1,163 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errNotFound = errors.New("not found")
+
+// notFoundLoader fails for the keys in missing, counting its calls.
+func notFoundLoader(calls *int32, missing ...interface{}) LoaderFunc {
+	return func(key interface{}) (interface{}, error) {
+		atomic.AddInt32(calls, 1)
+		for _, m := range missing {
+			if key == m {
+				return nil, errNotFound
+			}
+		}
+		return key, nil
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		var calls int32
+		clock := NewFakeClock()
+		gc := mustBuild(t, New(8).EvictType(tp).Clock(clock).
+			LoaderFunc(notFoundLoader(&calls, "missing")).
+			NegativeCache(NegativeCache{TTL: time.Second}))
+
+		for i := 0; i < 3; i++ {
+			if _, err := gc.Get("missing"); err != errNotFound {
+				t.Errorf("%v: err should be errNotFound, not %v", tp, err)
+			}
+		}
+		if calls != 1 {
+			t.Errorf("%v: the loader should run once, ran %v times", tp, calls)
+		}
+		if n := gc.NegativeHitCount(); n != 2 {
+			t.Errorf("%v: NegativeHitCount should be 2, not %v", tp, n)
+		}
+		if gc.Len() != 0 {
+			t.Errorf("%v: errors should not be cached as entries", tp)
+		}
+
+		clock.Advance(time.Second)
+		gc.Get("missing")
+		if calls != 2 {
+			t.Errorf("%v: the loader should run again once the error expired, ran %v times", tp, calls)
+		}
+	}
+}
+
+func TestNegativeCacheCacheable(t *testing.T) {
+	var calls int32
+	gc := mustBuild(t, New(8).LRU().
+		LoaderFunc(func(key interface{}) (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			if key == "down" {
+				return nil, errors.New("database down")
+			}
+			return nil, errNotFound
+		}).
+		NegativeCache(NegativeCache{
+			TTL:       time.Minute,
+			Cacheable: func(err error) bool { return errors.Is(err, errNotFound) },
+		}))
+
+	gc.Get("missing")
+	gc.Get("missing")
+	gc.Get("down")
+	gc.Get("down")
+	if calls != 3 {
+		t.Errorf("only errNotFound should be cached, the loader ran %v times", calls)
+	}
+}
+
+func TestNegativeCacheSetAndRemove(t *testing.T) {
+	var calls int32
+	gc := mustBuild(t, New(8).LRU().
+		LoaderFunc(notFoundLoader(&calls, "a", "b")).
+		NegativeCache(NegativeCache{TTL: time.Minute}))
+
+	gc.Get("a")
+	gc.Set("a", 1)
+	if v, err := gc.Get("a"); err != nil || v != 1 {
+		t.Errorf("Set should replace the cached error, got %v, %v", v, err)
+	}
+	gc.Remove("a")
+	gc.Get("a")
+	if calls != 2 {
+		t.Errorf("Remove should drop the cached error, the loader ran %v times", calls)
+	}
+
+	gc.Get("b")
+	gc.Purge()
+	gc.Get("b")
+	if calls != 4 {
+		t.Errorf("Purge should drop the cached errors, the loader ran %v times", calls)
+	}
+}
+
+func TestNegativeCacheBounded(t *testing.T) {
+	var calls int32
+	gc := mustBuild(t, New(4).LRU().
+		LoaderFunc(notFoundLoader(&calls, 0, 1, 2, 3, 4, 5, 6, 7)).
+		NegativeCache(NegativeCache{TTL: time.Minute}))
+	for i := 0; i < 8; i++ {
+		gc.Get(i)
+	}
+	if n := len(gc.(*LRUCache).negatives.errors.entries); n != 4 {
+		t.Errorf("the cached errors should be bounded by the capacity, got %v", n)
+	}
+}
+
+func TestNegativeCacheBuildError(t *testing.T) {
+	if _, err := New(8).NegativeCache(NegativeCache{}).Build(); err == nil {
+		t.Error("Build should fail without a TTL")
+	}
+}
+
+func TestNegativeCacheContextErrors(t *testing.T) {
+	for _, ctxErr := range []error{context.DeadlineExceeded, fmt.Errorf("query: %w", context.Canceled)} {
+		var calls int32
+		gc := mustBuild(t, New(8).LRU().
+			LoaderFunc(func(key interface{}) (interface{}, error) {
+				if atomic.AddInt32(&calls, 1) == 1 {
+					return nil, ctxErr
+				}
+				return key, nil
+			}).
+			NegativeCache(NegativeCache{TTL: time.Minute}))
+
+		if _, err := gc.Get("a"); err != ctxErr {
+			t.Fatalf("err should be %v, not %v", ctxErr, err)
+		}
+		if v, err := gc.Get("a"); err != nil || v != "a" {
+			t.Errorf("%v should not be cached, got %v, %v", ctxErr, v, err)
+		}
+		if n := gc.NegativeHitCount(); n != 0 {
+			t.Errorf("NegativeHitCount should be 0, not %v", n)
+		}
+	}
+}
+
+func TestNegativeCacheRestore(t *testing.T) {
+	var calls int32
+	gc := mustBuild(t, New(8).LRU().
+		LoaderFunc(failingLoader(&calls, 1)).
+		NegativeCache(NegativeCache{TTL: time.Hour}))
+
+	gc.Get("a")
+	if err := gc.Restore(snapshotOf(t, mustBuild(t, New(8).LRU()))); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := gc.Get("a"); err != nil || v != "a" {
+		t.Errorf("Restore should drop the cached errors, got %v, %v", v, err)
+	}
+}
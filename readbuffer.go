@@ -0,0 +1,109 @@
+package gcache
+
+import (
+	"math/rand/v2"
+	"runtime"
+	"sync"
+)
+
+const readStripeSize = 16
+
+// readBuffer records the entries read under the shared lock, so the eviction
+// policy can be updated later in batches, under the exclusive lock. It is
+// made of several stripes to spread concurrent readers, and is lossy: reads
+// recorded in a busy or full stripe are dropped.
+type readBuffer struct {
+	stripes []readStripe
+	mask    uint32
+	apply   func(interface{})
+}
+
+type readStripe struct {
+	mu    sync.Mutex
+	n     int
+	items [readStripeSize]interface{}
+	// Keeps stripes on separate cache lines.
+	_ [64]byte
+}
+
+func newReadBuffer(apply func(interface{})) *readBuffer {
+	n := nextPowerOfTwo(4 * runtime.GOMAXPROCS(0))
+	return &readBuffer{
+		stripes: make([]readStripe, n),
+		mask:    uint32(n - 1),
+		apply:   apply,
+	}
+}
+
+// record adds item to a stripe and reports whether that stripe is full and
+// should be drained.
+func (b *readBuffer) record(item interface{}) bool {
+	s := &b.stripes[rand.Uint32()&b.mask]
+	if !s.mu.TryLock() {
+		return false
+	}
+	defer s.mu.Unlock()
+	if s.n < readStripeSize {
+		s.items[s.n] = item
+		s.n++
+	}
+	return s.n == readStripeSize
+}
+
+// drain applies every recorded read. Called with the cache lock held.
+func (b *readBuffer) drain() {
+	for i := range b.stripes {
+		s := &b.stripes[i]
+		s.mu.Lock()
+		for j := 0; j < s.n; j++ {
+			b.apply(s.items[j])
+			s.items[j] = nil
+		}
+		s.n = 0
+		s.mu.Unlock()
+	}
+}
+
+// sharedRead reports whether a hit on e can be served under the shared lock:
+// expire-after-access entries and entries due for a refresh need the
+// exclusive one, like every expiring entry with EarlyRefresh.
+func (c *baseCache) sharedRead(e *expiry) bool {
+	if e.accessTTL != nil {
+		return false
+	}
+	if c.early != nil && e.expiration != nil {
+		return false
+	}
+	if e.expiration == nil && c.refreshAfterWrite == nil {
+		return true
+	}
+	now := c.clock.Now()
+	if e.isExpired(&now) {
+		return false
+	}
+	return c.refreshAfterWrite == nil || now.Sub(e.updated) < *c.refreshAfterWrite
+}
+
+// recordRead buffers a hit served under the shared lock, draining the buffer
+// if it filled up and the cache lock is free.
+func (c *baseCache) recordRead(item interface{}) {
+	if c.reads.record(item) && c.mu.TryLock() {
+		c.reads.drain()
+		c.mu.Unlock()
+	}
+}
+
+// drainReads brings the eviction policy up to date with the buffered reads.
+// Called with the cache lock held, before any eviction decision.
+func (c *baseCache) drainReads() {
+	if c.reads != nil {
+		c.reads.drain()
+	}
+}
+
+func (c *baseCache) deserialize(key, value interface{}) (interface{}, error) {
+	if c.deserializeFunc != nil {
+		return c.deserializeFunc(key, value)
+	}
+	return value, nil
+}
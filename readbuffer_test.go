@@ -0,0 +1,159 @@
+package gcache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestReadBufferDrain(t *testing.T) {
+	var applied []interface{}
+	b := newReadBuffer(func(v interface{}) {
+		applied = append(applied, v)
+	})
+
+	full := false
+	for i := 0; i < readStripeSize*len(b.stripes)+1; i++ {
+		full = b.record(i) || full
+	}
+	if !full {
+		t.Error("expected a stripe to fill up")
+	}
+
+	b.drain()
+	if len(applied) == 0 || len(applied) > readStripeSize*len(b.stripes) {
+		t.Errorf("unexpected number of applied reads: %v", len(applied))
+	}
+
+	applied = nil
+	b.drain()
+	if len(applied) != 0 {
+		t.Errorf("drain should have emptied the buffer, got %v", applied)
+	}
+}
+
+func TestBufferedReadsEviction(t *testing.T) {
+	for _, tp := range []string{TYPE_LRU, TYPE_LFU} {
+		cache, err := New(3).EvictType(tp).BufferedReads().Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.Set(1, 1)
+		cache.Set(2, 2)
+		cache.Set(3, 3)
+		cache.Get(1)
+		cache.Get(3)
+		// Set drains the buffered reads before evicting, so 2 goes.
+		cache.Set(4, 4)
+
+		if _, err := cache.GetIFPresent(2); err != KeyNotFoundError {
+			t.Errorf("%s: 2 should have been evicted", tp)
+		}
+		for _, k := range []int{1, 3, 4} {
+			if _, err := cache.GetIFPresent(k); err != nil {
+				t.Errorf("%s: %v should still be cached: %v", tp, k, err)
+			}
+		}
+	}
+}
+
+func TestBufferedReadsConcurrent(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		size := 100
+		gc, err := New(size).
+			EvictType(tp).
+			BufferedReads().
+			DeserializeFunc(func(k, v interface{}) (interface{}, error) {
+				return v, nil
+			}).
+			LoaderFunc(loader).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 1000; i++ {
+					key := fmt.Sprintf("Key-%d", (i*7+g)%(2*size))
+					v, err := gc.Get(key)
+					if err != nil {
+						t.Errorf("%s: %v", tp, err)
+						return
+					}
+					if expected, _ := loader(key); v != expected {
+						t.Errorf("%s: %v != %v", tp, v, expected)
+						return
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		if l := gc.Len(); l > size {
+			t.Errorf("%s: %v entries exceed the capacity %v", tp, l, size)
+		}
+		if gc.HitCount()+gc.MissCount() != 8000 {
+			t.Errorf("%s: unexpected lookup count %v", tp, gc.LookupCount())
+		}
+	}
+}
+
+func TestBufferedReadsExpiration(t *testing.T) {
+	for _, tp := range []string{TYPE_LRU, TYPE_LFU, TYPE_ARC, TYPE_TINYLFU} {
+		clock := NewFakeClock()
+		gc, _ := New(8).EvictType(tp).BufferedReads().Clock(clock).Build()
+		gc.SetWithExpire("key", "value", 1)
+		gc.SetWithExpireAfterAccess("sliding", "value", 10)
+
+		clock.Advance(5)
+		if _, err := gc.Get("key"); err != KeyNotFoundError {
+			t.Errorf("%s: expired entry was served: %v", tp, err)
+		}
+		if _, err := gc.Get("sliding"); err != nil {
+			t.Errorf("%s: %v", tp, err)
+		}
+		clock.Advance(7)
+		// The previous read pushed the expiration back.
+		if _, err := gc.Get("sliding"); err != nil {
+			t.Errorf("%s: %v", tp, err)
+		}
+	}
+}
+
+func benchmarkParallelGet(b *testing.B, tp string, buffered bool) {
+	cb := New(1000).EvictType(tp)
+	if buffered {
+		cb.BufferedReads()
+	}
+	gc, err := cb.Build()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		gc.Set(i, i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			gc.Get(i % 1000)
+			i++
+		}
+	})
+}
+
+func BenchmarkParallelGet(b *testing.B) {
+	for _, tp := range []string{TYPE_LRU, TYPE_LFU, TYPE_ARC, TYPE_TINYLFU} {
+		b.Run(tp+"/locked", func(b *testing.B) {
+			benchmarkParallelGet(b, tp, false)
+		})
+		b.Run(tp+"/buffered", func(b *testing.B) {
+			benchmarkParallelGet(b, tp, true)
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package gcache
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// refreshIfStale starts a background reload of key when its entry is older
+// than the RefreshAfterWrite duration, or is picked for an EarlyRefresh. The
+// current value keeps being served until the reload succeeds. Called with
+// the cache lock held.
+func (c *baseCache) refreshIfStale(key interface{}, e *expiry) {
+	if c.loaderExpireFunc == nil {
+		return
+	}
+	now := c.clock.Now()
+	stale := c.refreshAfterWrite != nil && now.Sub(e.updated) >= *c.refreshAfterWrite
+	if !stale && !c.refreshEarly(key, e, now) {
+		return
+	}
+	if _, ok := c.refreshing[key]; ok {
+		return
+	}
+	if !stale {
+		c.stats.IncrEarlyRefreshCount()
+	}
+	c.refreshing[key] = struct{}{}
+	go c.refresh(key, e.version)
+}
+
+// refresh reloads the entry of key, written as version.
+func (c *baseCache) refresh(key interface{}, version uint64) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.refreshing, key)
+		c.mu.Unlock()
+	}()
+
+	c.loadGroup.do(context.Background(), key, func(ctx context.Context) (v interface{}, e error) {
+		defer func() {
+			if r := recover(); r != nil {
+				e = fmt.Errorf("Loader panics: %v", r)
+			}
+		}()
+		v, expiration, loadTime, err := c.callLoader(ctx, key)
+		if err != nil {
+			// Keep serving the current value.
+			return nil, err
+		}
+		stored, err := c.setRefreshed(key, v, expiration, version)
+		if err != nil {
+			return nil, err
+		}
+		if stored {
+			c.recordLoadTime(key, loadTime)
+		}
+		return v, nil
+	}, true, false)
+}
+
+// entryStore is implemented by every cache type, to update its entries
+// without going through the hooks of the public methods.
+type entryStore interface {
+	storeEntry(key, value interface{}, expiration, accessTTL *time.Duration) error
+	removeEntry(key interface{})
+	expiryOf(key interface{}) (*expiry, bool)
+}
+
+// setLoaded saves a loaded value in the cache, logging it without writing it
+// back through the CacheWriter.
+func (c *baseCache) setLoaded(key, value interface{}, expiration *time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.storeLoaded(key, value, expiration)
+}
+
+// setRefreshed is setLoaded for a refresh of the entry written as version.
+// It reports false, storing nothing, if the entry was removed or written
+// again during the reload.
+func (c *baseCache) setRefreshed(key, value interface{}, expiration *time.Duration, version uint64) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.loadGroup.cache.(entryStore).expiryOf(key)
+	if !ok || e.version != version {
+		return false, nil
+	}
+	return true, c.storeLoaded(key, value, expiration)
+}
+
+func (c *baseCache) storeLoaded(key, value interface{}, expiration *time.Duration) error {
+	if err := c.logLoaded(key, value, expiration); err != nil {
+		return err
+	}
+	return c.loadGroup.cache.(entryStore).storeEntry(key, value, expiration, nil)
+}
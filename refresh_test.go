@@ -0,0 +1,149 @@
+package gcache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRefreshAfterWrite(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		clock := NewFakeClock()
+		var loads int64
+		release := make(chan struct{})
+		gc, err := New(8).
+			EvictType(tp).
+			Clock(clock).
+			RefreshAfterWrite(time.Minute).
+			LoaderFunc(func(key interface{}) (interface{}, error) {
+				n := atomic.AddInt64(&loads, 1)
+				if n > 1 {
+					<-release
+				}
+				return n, nil
+			}).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v, err := gc.Get("a"); err != nil || v != int64(1) {
+			t.Fatalf("%s: Get(a) = %v, %v", tp, v, err)
+		}
+
+		clock.Advance(2 * time.Minute)
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// The stale value is served without waiting on the reload.
+				if v, err := gc.Get("a"); err != nil || v != int64(1) {
+					t.Errorf("%s: Get(a) = %v, %v", tp, v, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		close(release)
+		waitFor(t, func() bool {
+			v, _ := gc.GetIFPresent("a")
+			return v == int64(2)
+		})
+		if n := atomic.LoadInt64(&loads); n != 2 {
+			t.Errorf("%s: loader called %v times, expected 2", tp, n)
+		}
+	}
+}
+
+func TestRefreshAfterWriteKeepsValueOnError(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		clock := NewFakeClock()
+		var loads int64
+		gc, err := New(8).
+			EvictType(tp).
+			Clock(clock).
+			RefreshAfterWrite(time.Minute).
+			LoaderFunc(func(key interface{}) (interface{}, error) {
+				n := atomic.AddInt64(&loads, 1)
+				if n == 2 {
+					return nil, errors.New("backend down")
+				}
+				return n, nil
+			}).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gc.Get("a")
+		clock.Advance(2 * time.Minute)
+		gc.Get("a")
+		waitFor(t, func() bool { return atomic.LoadInt64(&loads) == 2 })
+
+		// The failed reload leaves the old value in place, and the next read
+		// tries again.
+		waitFor(t, func() bool {
+			v, err := gc.GetIFPresent("a")
+			if err != nil || (v != int64(1) && v != int64(3)) {
+				t.Fatalf("%s: GetIFPresent(a) = %v, %v", tp, v, err)
+			}
+			return v == int64(3)
+		})
+	}
+}
+
+func (c *baseCache) refreshes() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.refreshing)
+}
+
+func TestRefreshAfterWriteRacingWrites(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		clock := NewFakeClock()
+		var loads int64
+		release := make(chan struct{})
+		gc := mustBuild(t, New(8).EvictType(tp).Clock(clock).
+			RefreshAfterWrite(time.Minute).
+			LoaderFunc(func(key interface{}) (interface{}, error) {
+				if atomic.AddInt64(&loads, 1) > 2 {
+					<-release
+				}
+				return "loaded", nil
+			}))
+		refreshes := gc.(interface{ refreshes() int }).refreshes
+
+		gc.Get("a")
+		gc.Get("b")
+		clock.Advance(2 * time.Minute)
+		gc.Get("a")
+		gc.Get("b")
+		waitFor(t, func() bool { return atomic.LoadInt64(&loads) == 4 })
+
+		gc.Set("a", "set")
+		gc.Remove("b")
+		close(release)
+		waitFor(t, func() bool { return refreshes() == 0 })
+
+		if v, err := gc.GetIFPresent("a"); err != nil || v != "set" {
+			t.Errorf("%v: a Set during a refresh should win, got %v, %v", tp, v, err)
+		}
+		if has(gc, "b") {
+			t.Errorf("%v: a Remove during a refresh should win", tp)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package gcache
+
+import "sync"
+
+// RemovalCause tells why an entry left the cache.
+type RemovalCause int
+
+const (
+	// RemovalExplicit is for entries removed by Remove.
+	RemovalExplicit RemovalCause = iota
+	// RemovalReplaced is for values overwritten by a Set on their key.
+	RemovalReplaced
+	// RemovalExpired is for entries whose expiration passed.
+	RemovalExpired
+	// RemovalSize is for entries evicted to stay within the capacity or
+	// MaxWeight.
+	RemovalSize
+	// RemovalPurged is for entries dropped by Purge.
+	RemovalPurged
+	// RemovalResized is for entries evicted because the cache was shrunk,
+	// by Resize or under memory pressure.
+	RemovalResized
+)
+
+func (rc RemovalCause) String() string {
+	switch rc {
+	case RemovalExplicit:
+		return "explicit"
+	case RemovalReplaced:
+		return "replaced"
+	case RemovalExpired:
+		return "expired"
+	case RemovalSize:
+		return "size"
+	case RemovalPurged:
+		return "purged"
+	case RemovalResized:
+		return "resized"
+	default:
+		return "unknown"
+	}
+}
+
+// removalNotifier runs an asynchronous RemovalListener on a single worker,
+// shared by the shards of a cache.
+type removalNotifier struct {
+	listener RemovalListener
+	events   chan removal
+	// mu guards closed, removals notified after Close are delivered
+	// synchronously, one at a time.
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+	once   sync.Once
+}
+
+type removal struct {
+	key, value interface{}
+	cause      RemovalCause
+}
+
+func newRemovalNotifier(listener RemovalListener, queueSize int) *removalNotifier {
+	n := &removalNotifier{
+		listener: listener,
+		events:   make(chan removal, queueSize),
+		done:     make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+func (n *removalNotifier) run() {
+	defer close(n.done)
+	for r := range n.events {
+		n.listener(r.key, r.value, r.cause)
+	}
+}
+
+func (n *removalNotifier) notify(r removal) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		n.listener(r.key, r.value, r.cause)
+		return
+	}
+	n.events <- r
+}
+
+// close delivers the pending removals and stops the worker.
+func (n *removalNotifier) close() {
+	n.once.Do(func() {
+		n.mu.Lock()
+		n.closed = true
+		close(n.events)
+		n.mu.Unlock()
+		<-n.done
+	})
+}
+
+// notifyRemoval reports a removal to the RemovalListener, if any. Called with
+// the cache lock held.
+func (c *baseCache) notifyRemoval(key, value interface{}, cause RemovalCause) {
+	if c.removalListener != nil {
+		c.removalListener(key, value, cause)
+		return
+	}
+	if c.notifier != nil {
+		c.notifier.notify(removal{key: key, value: value, cause: cause})
+	}
+}
+
+// listensRemovals reports whether removals need to be notified.
+func (c *baseCache) listensRemovals() bool {
+	return c.removalListener != nil || c.notifier != nil
+}
+
+// purged must be called for every entry dropped by Purge.
+func (c *baseCache) purged(key, value interface{}) {
+	if c.purgeVisitorFunc != nil {
+		c.purgeVisitorFunc(key, value)
+	}
+	c.notifyRemoval(key, value, RemovalPurged)
+}
+
+func (c *baseCache) closeNotifier() {
+	if c.notifier != nil {
+		c.notifier.close()
+	}
+}
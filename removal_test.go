@@ -0,0 +1,208 @@
+package gcache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type removalRecorder struct {
+	mu      sync.Mutex
+	causes  map[interface{}]RemovalCause
+	ordered []interface{}
+}
+
+func newRemovalRecorder() *removalRecorder {
+	return &removalRecorder{causes: make(map[interface{}]RemovalCause)}
+}
+
+func (r *removalRecorder) listen(key, value interface{}, cause RemovalCause) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.causes[key] = cause
+	r.ordered = append(r.ordered, key)
+}
+
+func (r *removalRecorder) cause(key interface{}) (RemovalCause, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cause, ok := r.causes[key]
+	return cause, ok
+}
+
+func (r *removalRecorder) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.ordered)
+}
+
+func TestRemovalCauses(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		clock := NewFakeClock()
+		rec := newRemovalRecorder()
+		evicted := map[interface{}]bool{}
+		gc, err := New(4).
+			EvictType(tp).
+			Clock(clock).
+			EvictedFunc(func(key, value interface{}) {
+				evicted[key] = true
+			}).
+			RemovalListener(rec.listen).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gc.Set("replaced", 1)
+		gc.Set("replaced", 2)
+		gc.Set("explicit", 1)
+		gc.Remove("explicit")
+		gc.SetWithExpire("expired", 1, time.Second)
+		clock.Advance(2 * time.Second)
+		gc.Get("expired")
+
+		expected := map[interface{}]RemovalCause{
+			"replaced": RemovalReplaced,
+			"explicit": RemovalExplicit,
+			"expired":  RemovalExpired,
+		}
+		for key, cause := range expected {
+			if got, ok := rec.cause(key); !ok || got != cause {
+				t.Errorf("%s: %v removed as %v, expected %v", tp, key, got, cause)
+			}
+		}
+		if evicted["replaced"] {
+			t.Errorf("%s: EvictedFunc should not see replaced values", tp)
+		}
+
+		for i := 0; i < 10; i++ {
+			gc.Set(i, i)
+		}
+		sized := 0
+		for i := 0; i < 10; i++ {
+			if cause, ok := rec.cause(i); ok {
+				if cause != RemovalSize {
+					t.Errorf("%s: %v removed as %v, expected %v", tp, i, cause, RemovalSize)
+				}
+				sized++
+			}
+		}
+		if sized == 0 {
+			t.Errorf("%s: no entry was removed for size", tp)
+		}
+
+		gc.Resize(1)
+		resized := 0
+		for i := 0; i < 10; i++ {
+			if cause, _ := rec.cause(i); cause == RemovalResized {
+				resized++
+			}
+		}
+		if gc.Len() != 1 || resized == 0 {
+			t.Errorf("%s: expected entries removed by Resize, %v left", tp, gc.Len())
+		}
+
+		var left interface{}
+		for _, k := range gc.Keys() {
+			left = k
+		}
+		gc.Purge()
+		if cause, _ := rec.cause(left); cause != RemovalPurged {
+			t.Errorf("%s: %v removed as %v, expected %v", tp, left, cause, RemovalPurged)
+		}
+		if evicted[left] && tp != TYPE_TINYLFU {
+			// TinyLFU may have evicted it before, through admission.
+			t.Errorf("%s: EvictedFunc should not see purged entries", tp)
+		}
+	}
+}
+
+func TestRemovalARCGhost(t *testing.T) {
+	rec := newRemovalRecorder()
+	gc, err := New(2).ARC().RemovalListener(rec.listen).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gc.Set(1, 1)
+	gc.Get(1)
+	gc.Set(2, 2)
+	gc.Set(3, 3)
+
+	if cause, ok := rec.cause(2); !ok || cause != RemovalSize {
+		t.Errorf("ghosted entry removed as %v, expected %v", cause, RemovalSize)
+	}
+}
+
+func TestAsyncRemovalListener(t *testing.T) {
+	rec := newRemovalRecorder()
+	release := make(chan struct{})
+	gc, err := New(100).
+		LRU().
+		AsyncRemovalListener(func(key, value interface{}, cause RemovalCause) {
+			<-release
+			rec.listen(key, value, cause)
+		}, 1000).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		gc.Set(i, i)
+		// Returns right away, even though the listener is blocked.
+		gc.Remove(i)
+	}
+	if n := rec.len(); n != 0 {
+		t.Errorf("the listener should still be blocked, got %v removals", n)
+	}
+
+	close(release)
+	gc.Close()
+	if n := rec.len(); n != 100 {
+		t.Fatalf("Close should deliver every pending removal, got %v", n)
+	}
+	for i, key := range rec.ordered {
+		if key != i {
+			t.Fatalf("removals delivered out of order: %v", rec.ordered)
+		}
+	}
+
+	// Removals after Close are delivered synchronously.
+	gc.Set("key", 1)
+	gc.Remove("key")
+	if cause, ok := rec.cause("key"); !ok || cause != RemovalExplicit {
+		t.Errorf("unexpected removal %v, %v", cause, ok)
+	}
+}
+
+func TestAsyncRemovalListenerSharded(t *testing.T) {
+	// Not safe for concurrent calls, -race reports them.
+	var removed []interface{}
+	gc := mustBuild(t, New(100).LRU().Shards(8).
+		AsyncRemovalListener(func(key, value interface{}, cause RemovalCause) {
+			removed = append(removed, key)
+		}, 1000))
+
+	for i := 0; i < 100; i++ {
+		gc.Set(i, i)
+		gc.Remove(i)
+	}
+	gc.Close()
+	if len(removed) != 100 {
+		t.Fatalf("Close should deliver every pending removal, got %v", len(removed))
+	}
+	for i, key := range removed {
+		if key != i {
+			t.Fatalf("removals delivered out of order: %v", removed)
+		}
+	}
+}
+
+func TestRemovalCauseString(t *testing.T) {
+	if s := RemovalSize.String(); s != "size" {
+		t.Errorf("%v != %v", s, "size")
+	}
+	if s := RemovalCause(42).String(); s != "unknown" {
+		t.Errorf("%v != %v", s, "unknown")
+	}
+}
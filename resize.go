@@ -0,0 +1,28 @@
+package gcache
+
+import "fmt"
+
+// resizer is implemented by every cache type, it evicts entries through the
+// cache's policy until at most capacity remain. Called with the cache lock
+// held.
+type resizer interface {
+	weighted
+	resize(capacity int)
+}
+
+// setCapacity changes the capacity the cache was built with. Under memory
+// pressure, the controller's ratio still applies on top of it. Called with
+// the cache lock held.
+func (c *baseCache) setCapacity(cache resizer, capacity int) error {
+	if capacity <= 0 {
+		return fmt.Errorf("gcache2: can't Resize Cache, invalid Cache capacity (%v<=0)", capacity)
+	}
+	if c.pressure != nil {
+		c.pressure.capacity = capacity
+		c.scale()
+		return nil
+	}
+	c.drainReads()
+	cache.resize(capacity)
+	return nil
+}
@@ -0,0 +1,141 @@
+package gcache
+
+import (
+	"testing"
+)
+
+func TestResize(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		var evicted int
+		gc, err := New(100).
+			EvictType(tp).
+			EvictedFunc(func(key, value interface{}) {
+				evicted++
+			}).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			gc.Set(i, i)
+		}
+
+		if err := gc.Resize(40); err != nil {
+			t.Fatal(err)
+		}
+		if l := gc.Len(); l != 40 {
+			t.Errorf("%s: %v != %v", tp, l, 40)
+		}
+		if evicted != 60 {
+			t.Errorf("%s: %v entries reported evicted, expected 60", tp, evicted)
+		}
+		for i := 100; i < 200; i++ {
+			gc.Set(i, i)
+		}
+		if l := gc.Len(); l != 40 {
+			t.Errorf("%s: %v != %v", tp, l, 40)
+		}
+
+		if err := gc.Resize(150); err != nil {
+			t.Fatal(err)
+		}
+		for i := 200; i < 400; i++ {
+			gc.Set(i, i)
+		}
+		if l := gc.Len(); l != 150 {
+			t.Errorf("%s: %v != %v", tp, l, 150)
+		}
+
+		if err := gc.Resize(0); tp != TYPE_SIMPLE && err == nil {
+			t.Errorf("%s: expected an error for a zero capacity", tp)
+		}
+	}
+}
+
+func TestResizeLRUOrder(t *testing.T) {
+	gc, err := New(4).LRU().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		gc.Set(i, i)
+	}
+	gc.Get(0)
+	gc.Get(1)
+
+	gc.Resize(2)
+	for _, k := range []int{0, 1} {
+		if _, err := gc.GetIFPresent(k); err != nil {
+			t.Errorf("%v should have been kept: %v", k, err)
+		}
+	}
+}
+
+func TestResizeARC(t *testing.T) {
+	cache, err := New(10).ARC().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	arc := cache.(*ARC)
+	for i := 0; i < 10; i++ {
+		arc.Set(i, i)
+	}
+	for i := 0; i < 5; i++ {
+		arc.Get(i)
+	}
+	for i := 10; i < 20; i++ {
+		arc.Set(i, i)
+	}
+	arc.split = 6
+
+	arc.Resize(4)
+	if arc.split != 2 {
+		t.Errorf("split should be scaled down, got %v", arc.split)
+	}
+	if n := arc.t1.Len() + arc.t2.Len(); n != 4 || arc.Len() != 4 {
+		t.Errorf("%v residents left, expected 4", n)
+	}
+	if arc.t1.Len()+arc.b1.Len() > 4 {
+		t.Errorf("t1+b1 = %v exceeds the capacity", arc.t1.Len()+arc.b1.Len())
+	}
+	if total := arc.t1.Len() + arc.t2.Len() + arc.b1.Len() + arc.b2.Len(); total > 8 || total != len(arc.store) {
+		t.Errorf("unexpected number of tracked keys %v (%v in store)", total, len(arc.store))
+	}
+}
+
+func TestResizeWithMemoryPressure(t *testing.T) {
+	clock := NewFakeClock()
+	mem := &fakeMemory{used: 95}
+	gc, err := New(100).
+		LRU().
+		Clock(clock).
+		MemoryPressure(MemoryPressure{Step: 0.5, Source: mem.source}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gc.Close()
+
+	checkMemoryOnce(clock)
+	gc.Resize(40)
+	for i := 0; i < 100; i++ {
+		gc.Set(i, i)
+	}
+	if l := gc.Len(); l != 20 {
+		t.Errorf("the memory pressure should still apply, got %v entries", l)
+	}
+}
+
+func TestShardedResize(t *testing.T) {
+	gc, err := New(100).LRU().Shards(4).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		gc.Set(i, i)
+	}
+	gc.Resize(20)
+	if l := gc.Len(); l > 20 {
+		t.Errorf("%v entries exceed the capacity", l)
+	}
+}
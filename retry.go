@@ -0,0 +1,123 @@
+package gcache
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy configures the retries of a failed load, see
+// CacheBuilder.LoaderRetry. Zero fields take their default value.
+type RetryPolicy struct {
+	// Attempts is the number of times the loader is called, the first one
+	// included. Defaults to 3.
+	Attempts int
+	// Backoff is the time before the first retry, doubled for every other
+	// one. Defaults to 100ms.
+	Backoff time.Duration
+	// MaxBackoff caps the time between two retries. Defaults to 10 seconds.
+	MaxBackoff time.Duration
+	// Jitter shortens every backoff by a random fraction of it, up to
+	// Jitter, so that caches failing together don't retry together.
+	Jitter float64
+	// Retryable selects the errors worth a retry, all of them when nil.
+	Retryable func(error) bool
+}
+
+func (rp RetryPolicy) withDefaults() RetryPolicy {
+	if rp.Attempts <= 0 {
+		rp.Attempts = 3
+	}
+	if rp.Backoff <= 0 {
+		rp.Backoff = 100 * time.Millisecond
+	}
+	if rp.MaxBackoff <= 0 {
+		rp.MaxBackoff = 10 * time.Second
+	}
+	if rp.Jitter < 0 {
+		rp.Jitter = 0
+	} else if rp.Jitter > 1 {
+		rp.Jitter = 1
+	}
+	return rp
+}
+
+// backoff returns the time to wait before the retry following attempt.
+func (rp *RetryPolicy) backoff(attempt int) time.Duration {
+	d := rp.Backoff
+	for i := 1; i < attempt && d < rp.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > rp.MaxBackoff {
+		d = rp.MaxBackoff
+	}
+	if rp.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * rp.Jitter * float64(d))
+	}
+	return d
+}
+
+func (rp *RetryPolicy) retryable(err error) bool {
+	return rp.Retryable == nil || rp.Retryable(err)
+}
+
+// callLoader calls the loader through the circuit breaker and the retry
+// policy of the cache. It also returns how long the load took, for
+// EarlyRefresh.
+func (c *baseCache) callLoader(ctx context.Context, key interface{}) (v interface{}, expiration *time.Duration, loadTime time.Duration, err error) {
+	start := c.clock.Now()
+	err = c.guardLoad(ctx, func() error {
+		v, expiration, err = c.loaderExpireFunc(ctx, key)
+		return err
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return v, expiration, c.clock.Now().Sub(start), nil
+}
+
+// callBatchLoader calls the batch loader like callLoader, a batch counting
+// as one load for the circuit breaker.
+func (c *baseCache) callBatchLoader(ctx context.Context, keys []interface{}) (loaded map[interface{}]interface{}, err error) {
+	err = c.guardLoad(ctx, func() error {
+		loaded, err = c.batchLoaderFunc(ctx, keys)
+		return err
+	})
+	return loaded, err
+}
+
+func (c *baseCache) guardLoad(ctx context.Context, load func() error) error {
+	if c.breaker == nil {
+		return c.retryLoad(ctx, load)
+	}
+	if err := c.breaker.allow(); err != nil {
+		c.stats.IncrCircuitRejectCount()
+		return err
+	}
+	// Stays set if the loader panics.
+	failed := true
+	defer func() {
+		c.breaker.done(failed)
+	}()
+	err := c.retryLoad(ctx, load)
+	failed = c.breaker.isFailure(err)
+	return err
+}
+
+func (c *baseCache) retryLoad(ctx context.Context, load func() error) error {
+	if c.retry == nil {
+		return load()
+	}
+	for attempt := 1; ; attempt++ {
+		err := load()
+		if err == nil || attempt >= c.retry.Attempts || !c.retry.retryable(err) {
+			return err
+		}
+		c.stats.IncrLoadRetryCount()
+		select {
+		case <-ctx.Done():
+			return err
+		case <-after(c.clock, c.retry.backoff(attempt)):
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package gcache
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("transient")
+
+// failingLoader fails its first failures calls.
+func failingLoader(calls *int32, failures int32) LoaderFunc {
+	return func(key interface{}) (interface{}, error) {
+		if atomic.AddInt32(calls, 1) <= failures {
+			return nil, errTransient
+		}
+		return key, nil
+	}
+}
+
+type getResult struct {
+	v   interface{}
+	err error
+}
+
+func getAsync(gc Cache, key interface{}) <-chan getResult {
+	ch := make(chan getResult, 1)
+	go func() {
+		v, err := gc.Get(key)
+		ch <- getResult{v, err}
+	}()
+	return ch
+}
+
+func TestLoaderRetry(t *testing.T) {
+	var calls int32
+	clock := NewFakeClock()
+	gc := mustBuild(t, New(8).LRU().Clock(clock).
+		LoaderFunc(failingLoader(&calls, 2)).
+		LoaderRetry(RetryPolicy{Attempts: 3, Backoff: time.Second}))
+
+	res := getAsync(gc, "a")
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+	clock.Advance(2 * time.Second)
+
+	if r := <-res; r.err != nil || r.v != "a" {
+		t.Errorf("a should be loaded by the last attempt, got %v, %v", r.v, r.err)
+	}
+	if n := gc.LoadRetryCount(); n != 2 {
+		t.Errorf("LoadRetryCount should be 2, not %v", n)
+	}
+}
+
+func TestLoaderRetryGivesUp(t *testing.T) {
+	var calls int32
+	clock := NewFakeClock()
+	gc := mustBuild(t, New(8).LRU().Clock(clock).
+		LoaderFunc(failingLoader(&calls, 10)).
+		LoaderRetry(RetryPolicy{Attempts: 2, Backoff: time.Second}))
+
+	res := getAsync(gc, "a")
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	if r := <-res; r.err != errTransient {
+		t.Errorf("err should be the last loader error, not %v", r.err)
+	}
+	if calls != 2 {
+		t.Errorf("the loader should be called twice, not %v times", calls)
+	}
+}
+
+func TestLoaderRetryable(t *testing.T) {
+	var calls int32
+	gc := mustBuild(t, New(8).LRU().
+		LoaderFunc(failingLoader(&calls, 10)).
+		LoaderRetry(RetryPolicy{Retryable: func(err error) bool { return err != errTransient }}))
+
+	if _, err := gc.Get("a"); err != errTransient {
+		t.Errorf("err should be errTransient, not %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("errors that aren't retryable should not be retried, the loader ran %v times", calls)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	rp := RetryPolicy{Backoff: time.Second, MaxBackoff: 3 * time.Second}.withDefaults()
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if d := rp.backoff(attempt + 1); d != expected {
+			t.Errorf("backoff after attempt %v should be %v, not %v", attempt+1, expected, d)
+		}
+	}
+
+	rp.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := rp.backoff(2); d < time.Second || d > 2*time.Second {
+			t.Fatalf("the jitter should shorten the backoff by up to half, got %v", d)
+		}
+	}
+}
+
+// nowClock only implements Clock, without After.
+type nowClock struct{}
+
+func (nowClock) Now() time.Time {
+	return time.Now()
+}
+
+func TestLoaderRetryClockWithoutAfter(t *testing.T) {
+	var calls int32
+	gc := mustBuild(t, New(8).LRU().Clock(nowClock{}).
+		LoaderFunc(failingLoader(&calls, 1)).
+		LoaderRetry(RetryPolicy{Backoff: time.Millisecond}))
+
+	if v, err := gc.Get("a"); err != nil || v != "a" {
+		t.Errorf("a clock without After should wait on time.After, got %v, %v", v, err)
+	}
+}
@@ -0,0 +1,430 @@
+package gcache
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// RRCache evicts a uniformly random resident entry when full.
+// Entries are kept in a dense slice so that picking and removing a victim
+// is O(1).
+type RRCache struct {
+	baseCache
+	store map[interface{}]int
+	items []*rrItem
+	rand  *rand.Rand
+}
+
+type rrItem struct {
+	expiry
+	key    interface{}
+	value  interface{}
+	weight int64
+}
+
+func newRRCache(cb *CacheBuilder) *RRCache {
+	c := &RRCache{}
+	buildCache(&c.baseCache, cb)
+
+	src := cb.randSource
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	c.rand = rand.New(src)
+
+	c.init()
+	c.loadGroup.cache = c
+	c.startJanitor(c, cb.cleanupInterval)
+	c.startMemoryController(c, cb.memoryPressure)
+	c.startCheckpointer(c, cb.persistence)
+	return c
+}
+
+func (c *RRCache) init() {
+	c.store = make(map[interface{}]int, c.capacity+1)
+	c.items = make([]*rrItem, 0, c.capacity)
+}
+
+func (c *RRCache) set(key, value interface{}) (interface{}, error) {
+	value, w, err := c.prepare(key, value)
+	if err != nil {
+		return nil, err
+	}
+	return c.insert(key, value, w), nil
+}
+
+// insert stores value, prepared with its weight w.
+func (c *RRCache) insert(key, value interface{}, w int64) interface{} {
+	c.forgetHeld(key)
+	c.makeRoom(c, key, w)
+
+	var item *rrItem
+	if idx, ok := c.store[key]; ok {
+		item = c.items[idx]
+		c.removed(key, item.value, nil, RemovalReplaced)
+		item.value = value
+	} else {
+		if len(c.items) >= c.capacity {
+			c.evict(1, RemovalSize)
+		}
+		item = &rrItem{
+			expiry: expiry{clock: c.clock},
+			key:    key,
+			value:  value,
+		}
+		c.store[key] = len(c.items)
+		c.items = append(c.items, item)
+	}
+
+	c.addWeight(w - item.weight)
+	item.weight = w
+	c.expireAfterWrite(key, &item.expiry)
+
+	if c.addedFunc != nil {
+		c.addedFunc(key, value)
+	}
+
+	return item
+}
+
+func (c *RRCache) Set(key, value interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, w, err := c.prepare(key, value)
+	if err != nil {
+		return err
+	}
+	if err := c.beforeSet(key, value); err != nil {
+		return err
+	}
+	c.insert(key, stored, w)
+	return nil
+}
+
+func (c *RRCache) SetWithExpire(key, value interface{}, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, w, err := c.prepare(key, value)
+	if err != nil {
+		return err
+	}
+	if err := c.beforeSetWithExpire(key, value, expiration); err != nil {
+		return err
+	}
+	item := c.insert(key, stored, w)
+
+	c.expireAt(key, &item.(*rrItem).expiry, expiration)
+	return nil
+}
+
+func (c *RRCache) SetWithExpireAfterAccess(key, value interface{}, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, w, err := c.prepare(key, value)
+	if err != nil {
+		return err
+	}
+	if err := c.beforeSetWithExpireAfterAccess(key, value, expiration); err != nil {
+		return err
+	}
+	item := c.insert(key, stored, w)
+
+	c.expireAfterAccess(key, &item.(*rrItem).expiry, expiration)
+	return nil
+}
+
+func (c *RRCache) get(key interface{}, onLoad bool) (interface{}, error) {
+	idx, exists := c.store[key]
+	if !exists {
+		if !onLoad {
+			c.stats.IncrMissCount()
+		}
+		return nil, KeyNotFoundError
+	}
+
+	item := c.items[idx]
+	if item.isExpired(nil) {
+		c.removeAt(idx, RemovalExpired)
+		if !onLoad {
+			c.stats.IncrMissCount()
+		}
+		return nil, KeyNotFoundError
+	}
+
+	if !onLoad {
+		c.stats.IncrHitCount()
+		c.accessed(key, &item.expiry)
+		c.refreshIfStale(key, &item.expiry)
+	}
+
+	if c.deserializeFunc != nil {
+		return c.deserializeFunc(key, item.value)
+	}
+
+	return item.value, nil
+}
+
+func (c *RRCache) storeEntry(key, value interface{}, expiration, accessTTL *time.Duration) error {
+	item, err := c.set(key, value)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case expiration != nil:
+		c.expireAt(key, &item.(*rrItem).expiry, *expiration)
+	case accessTTL != nil:
+		c.expireAfterAccess(key, &item.(*rrItem).expiry, *accessTTL)
+	}
+	return nil
+}
+
+func (c *RRCache) removeEntry(key interface{}) {
+	c.remove(key)
+}
+
+func (c *RRCache) expiryOf(key interface{}) (*expiry, bool) {
+	if idx, ok := c.store[key]; ok {
+		return &c.items[idx].expiry, true
+	}
+	return nil, false
+}
+
+func (c *RRCache) getWithLoader(ctx context.Context, key interface{}, isWait bool) (interface{}, error) {
+	if c.loaderExpireFunc == nil {
+		return nil, KeyNotFoundError
+	}
+
+	value, _, err := c.load(ctx, key, func(v interface{}, expiration *time.Duration, e error) (interface{}, error) {
+		if e != nil {
+			return nil, e
+		}
+
+		if err := c.setLoaded(key, v, expiration); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}, isWait)
+	return value, err
+}
+
+func (c *RRCache) Get(key interface{}) (interface{}, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *RRCache) GetContext(ctx context.Context, key interface{}) (interface{}, error) {
+	c.mu.Lock()
+	v, err := c.get(key, false)
+	c.mu.Unlock()
+
+	if err == KeyNotFoundError {
+		return c.getWithLoader(ctx, key, true)
+	}
+	return v, err
+}
+
+func (c *RRCache) GetIFPresent(key interface{}) (interface{}, error) {
+	c.mu.Lock()
+	v, err := c.get(key, false)
+	c.mu.Unlock()
+
+	if err == KeyNotFoundError {
+		return c.getWithLoader(context.Background(), key, false)
+	}
+	return v, err
+}
+
+func (c *RRCache) GetALL() map[interface{}]interface{} {
+	c.mu.Lock()
+	allKeys := c.keys()
+	c.mu.Unlock()
+
+	m := make(map[interface{}]interface{})
+	for _, k := range allKeys {
+		v, err := c.GetIFPresent(k)
+		if err == nil {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+func (c *RRCache) remove(key interface{}) error {
+	if idx, ok := c.store[key]; ok {
+		c.removeAt(idx, RemovalExplicit)
+		return nil
+	}
+	return KeyNotFoundError
+}
+
+func (c *RRCache) expire(key interface{}, now time.Time) {
+	if idx, ok := c.store[key]; ok && c.items[idx].isExpired(&now) {
+		c.removeAt(idx, RemovalExpired)
+	}
+}
+
+func (c *RRCache) Remove(key interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.beforeRemove(key); err != nil {
+		return err
+	}
+	c.forgetHeld(key)
+	return c.remove(key)
+}
+
+func (c *RRCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logPurge()
+	c.purgeHeld()
+	c.purge()
+}
+
+func (c *RRCache) purge() {
+	if c.purgeVisitorFunc != nil || c.listensRemovals() {
+		for _, item := range c.items {
+			c.purged(item.key, item.value)
+		}
+	}
+
+	c.resetExpirations()
+	c.resetWeight()
+	c.init()
+}
+
+func (c *RRCache) keys() []interface{} {
+	keys := make([]interface{}, len(c.items))
+	for i, item := range c.items {
+		keys[i] = item.key
+	}
+	return keys
+}
+
+func (c *RRCache) Keys() []interface{} {
+	c.mu.Lock()
+	allKeys := c.keys()
+	c.mu.Unlock()
+
+	keys := []interface{}{}
+	for _, k := range allKeys {
+		_, err := c.GetIFPresent(k)
+		if err == nil {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func (c *RRCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.items)
+}
+
+func (c *RRCache) Resize(capacity int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.setCapacity(c, capacity)
+}
+
+func (c *RRCache) evict(count int, cause RemovalCause) {
+	for i := 0; i < count && len(c.items) > 0; i++ {
+		c.removeAt(c.rand.Intn(len(c.items)), cause)
+	}
+}
+
+func (c *RRCache) weightOf(key interface{}) int64 {
+	if idx, ok := c.store[key]; ok {
+		return c.items[idx].weight
+	}
+	return 0
+}
+
+func (c *RRCache) evictOne(cause RemovalCause) bool {
+	if len(c.items) == 0 {
+		return false
+	}
+	c.evict(1, cause)
+	return true
+}
+
+func (c *RRCache) resize(capacity int) {
+	c.capacity = capacity
+	for len(c.items) > capacity && c.evictOne(RemovalResized) {
+	}
+}
+
+// removeAt drops the item at idx by moving the last item into its slot.
+func (c *RRCache) removeAt(idx int, cause RemovalCause) {
+	item := c.items[idx]
+	last := len(c.items) - 1
+	if idx != last {
+		c.items[idx] = c.items[last]
+		c.store[c.items[idx].key] = idx
+	}
+	c.items[last] = nil
+	c.items = c.items[:last]
+	delete(c.store, item.key)
+
+	c.addWeight(-item.weight)
+	c.removed(item.key, item.value, &item.expiry, cause)
+}
+
+func (c *RRCache) Debug() map[string][]int {
+	d := make(map[string][]int)
+	d["rr"] = []int{len(c.store), len(c.items)}
+	return d
+}
+
+func (c *RRCache) Snapshot(w io.Writer) error {
+	return c.snapshot(c, TYPE_RR, w)
+}
+
+func (c *RRCache) Restore(r io.Reader) error {
+	return c.restore(c, TYPE_RR, r)
+}
+
+func (c *RRCache) exportEntries(at time.Time) ([]SnapshotEntry, int) {
+	entries := make([]SnapshotEntry, 0, len(c.items))
+	for _, item := range c.items {
+		entry := SnapshotEntry{Key: item.key, Value: item.value}
+		item.export(at, &entry)
+		entries = append(entries, entry)
+	}
+	return entries, 0
+}
+
+func (c *RRCache) importEntries(at time.Time, entries []SnapshotEntry, _ int) {
+	for i := range entries {
+		e := &entries[i]
+		w, ok := c.importWeight(e)
+		if !ok {
+			continue
+		}
+		item := &rrItem{
+			expiry: expiry{clock: c.clock},
+			key:    e.Key,
+			value:  e.Value,
+			weight: w,
+		}
+		item.restore(at, e)
+		c.store[e.Key] = len(c.items)
+		c.items = append(c.items, item)
+	}
+}
+
+func (c *RRCache) unsafeGet(key interface{}, onLoad bool) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.get(key, onLoad)
+}
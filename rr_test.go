@@ -0,0 +1,159 @@
+package gcache
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func evictedFuncForRR(key, value interface{}) {
+	fmt.Printf("[RR] Key:%v Value:%v will be evicted.\n", key, value)
+}
+
+func buildRRCache(size int) (Cache, error) {
+	return New(size).
+		RR().
+		EvictedFunc(evictedFuncForRR).
+		Expiration(time.Second).
+		Build()
+}
+
+func buildLoadingRRCache(size int, loader LoaderFunc) (Cache, error) {
+	return New(size).
+		RR().
+		LoaderFunc(loader).
+		EvictedFunc(evictedFuncForRR).
+		Expiration(time.Second).
+		Build()
+}
+
+func TestRRGet(t *testing.T) {
+	size := 1000
+	gc, err := buildRRCache(size)
+	if err != nil {
+		t.Error(err)
+	}
+
+	testSetCache(t, gc, size)
+	testGetCache(t, gc, size)
+}
+
+func TestLoadingRRGet(t *testing.T) {
+	size := 1000
+	gc, err := buildLoadingRRCache(size, loader)
+	if err != nil {
+		t.Error(err)
+	}
+
+	testGetCache(t, gc, size)
+}
+
+func TestRRLength(t *testing.T) {
+	gc, err := buildLoadingRRCache(1000, loader)
+	if err != nil {
+		t.Error(err)
+	}
+
+	gc.Get("test1")
+	gc.Get("test2")
+	length := gc.Len()
+	expectedLength := 2
+	if length != expectedLength {
+		t.Errorf("Expected length is %v, not %v", length, expectedLength)
+	}
+}
+
+func TestRREvictItem(t *testing.T) {
+	cacheSize := 10
+	numbers := 11
+	gc, err := buildLoadingRRCache(cacheSize, loader)
+	if err != nil {
+		t.Error(err)
+	}
+
+	for i := 0; i < numbers; i++ {
+		_, err := gc.Get(fmt.Sprintf("Key-%d", i))
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	if gc.Len() != cacheSize {
+		t.Errorf("Expected length is %v, not %v", cacheSize, gc.Len())
+	}
+}
+
+func TestRRDeterministicEviction(t *testing.T) {
+	evictions := func() []interface{} {
+		var evicted []interface{}
+		gc, err := New(4).
+			RR().
+			RandSource(rand.NewSource(42)).
+			EvictedFunc(func(key, value interface{}) {
+				evicted = append(evicted, key)
+			}).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 20; i++ {
+			gc.Set(i, i)
+		}
+		return evicted
+	}
+
+	first, second := evictions(), evictions()
+	if len(first) != 16 {
+		t.Fatalf("%v != %v", len(first), 16)
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("eviction order differs: %v != %v", first, second)
+		}
+	}
+}
+
+func TestRRUniformEviction(t *testing.T) {
+	size := 4
+	counts := make(map[interface{}]int)
+	gc, err := New(size).
+		RR().
+		RandSource(rand.NewSource(1)).
+		EvictedFunc(func(key, value interface{}) {
+			counts[key]++
+		}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < size; i++ {
+		gc.Set(i, i)
+	}
+	rounds := 4000
+	for i := 0; i < rounds; i++ {
+		// Inserting a new key evicts one of the originals, put it back.
+		gc.Set("new", i)
+		for k := 0; k < size; k++ {
+			if _, err := gc.GetIFPresent(k); err == KeyNotFoundError {
+				gc.Remove("new")
+				gc.Set(k, k)
+			}
+		}
+	}
+
+	expected := rounds / size
+	for k := 0; k < size; k++ {
+		if counts[k] < expected*8/10 || counts[k] > expected*12/10 {
+			t.Errorf("key %v evicted %v times, expected about %v", k, counts[k], expected)
+		}
+	}
+}
+
+func TestRRGetIFPresent(t *testing.T) {
+	testGetIFPresent(t, TYPE_RR)
+}
+
+func TestRRGetALL(t *testing.T) {
+	testGetALL(t, TYPE_RR)
+}
@@ -0,0 +1,321 @@
+package gcache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ShardedCache spreads keys over several caches, each with its own lock.
+type ShardedCache struct {
+	shards []Cache
+	tp     string
+	codec  Codec
+	clock  Clock
+
+	checkpoint *checkpointer
+}
+
+func newShardedCache(cb *CacheBuilder) (*ShardedCache, error) {
+	sb := *cb
+	sb.shards = 0
+	// The shards are checkpointed together.
+	sb.persistence = nil
+	if cb.capacity > 0 {
+		// Round up so that the total capacity is never below the requested one.
+		sb.capacity = (cb.capacity + cb.shards - 1) / cb.shards
+	}
+	if cb.circuitBreaker != nil {
+		sb.breaker = newCircuitBreaker(*cb.circuitBreaker, cb.clock)
+	}
+	if cb.removalListener != nil && cb.removalQueue > 0 {
+		sb.notifier = newRemovalNotifier(cb.removalListener, cb.removalQueue)
+	}
+	if cb.maxWeight > 0 {
+		sb.maxWeight = (cb.maxWeight + int64(cb.shards) - 1) / int64(cb.shards)
+	}
+
+	c := &ShardedCache{
+		shards: make([]Cache, cb.shards),
+		tp:     cb.tp,
+		codec:  cb.codec,
+		clock:  cb.clock,
+	}
+	for i := range c.shards {
+		shard, err := sb.build()
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.shards[i] = shard
+	}
+	c.checkpoint = newCheckpointer(c, c.shards, cb.clock, cb.codec, cb.persistence)
+	return c, nil
+}
+
+func (c *ShardedCache) shard(key interface{}) Cache {
+	return c.shards[hashKey(key)%uint64(len(c.shards))]
+}
+
+func (c *ShardedCache) Set(key, value interface{}) error {
+	return c.shard(key).Set(key, value)
+}
+
+func (c *ShardedCache) SetWithExpire(key, value interface{}, expiration time.Duration) error {
+	return c.shard(key).SetWithExpire(key, value, expiration)
+}
+
+func (c *ShardedCache) SetWithExpireAfterAccess(key, value interface{}, expiration time.Duration) error {
+	return c.shard(key).SetWithExpireAfterAccess(key, value, expiration)
+}
+
+func (c *ShardedCache) Get(key interface{}) (interface{}, error) {
+	return c.shard(key).Get(key)
+}
+
+func (c *ShardedCache) GetContext(ctx context.Context, key interface{}) (interface{}, error) {
+	return c.shard(key).GetContext(ctx, key)
+}
+
+func (c *ShardedCache) GetIFPresent(key interface{}) (interface{}, error) {
+	return c.shard(key).GetIFPresent(key)
+}
+
+// GetMany splits keys by shard and gets them from the shards concurrently, a
+// BatchLoaderFunc is called once per shard with missing keys.
+func (c *ShardedCache) GetMany(keys []interface{}) (map[interface{}]interface{}, map[interface{}]error) {
+	byShard := make(map[Cache][]interface{})
+	for _, key := range keys {
+		shard := c.shard(key)
+		byShard[shard] = append(byShard[shard], key)
+	}
+
+	values := make(map[interface{}]interface{}, len(keys))
+	errs := make(map[interface{}]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for shard, keys := range byShard {
+		wg.Add(1)
+		go func(shard Cache, keys []interface{}) {
+			defer wg.Done()
+			v, e := shard.GetMany(keys)
+			mu.Lock()
+			defer mu.Unlock()
+			for key, value := range v {
+				values[key] = value
+			}
+			for key, err := range e {
+				errs[key] = err
+			}
+		}(shard, keys)
+	}
+	wg.Wait()
+	return values, errs
+}
+
+func (c *ShardedCache) GetALL() map[interface{}]interface{} {
+	m := make(map[interface{}]interface{})
+	for _, shard := range c.shards {
+		for k, v := range shard.GetALL() {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+func (c *ShardedCache) Remove(key interface{}) error {
+	return c.shard(key).Remove(key)
+}
+
+func (c *ShardedCache) Purge() {
+	for _, shard := range c.shards {
+		shard.Purge()
+	}
+}
+
+func (c *ShardedCache) Keys() []interface{} {
+	keys := []interface{}{}
+	for _, shard := range c.shards {
+		keys = append(keys, shard.Keys()...)
+	}
+	return keys
+}
+
+func (c *ShardedCache) Len() int {
+	n := 0
+	for _, shard := range c.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+// Resize gives each shard an equal part of capacity.
+func (c *ShardedCache) Resize(capacity int) error {
+	if capacity > 0 {
+		capacity = (capacity + len(c.shards) - 1) / len(c.shards)
+	}
+	for _, shard := range c.shards {
+		if err := shard.Resize(capacity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Snapshot writes the snapshot of every shard, see Cache.Snapshot.
+func (c *ShardedCache) Snapshot(w io.Writer) error {
+	header := &snapshot{header: SnapshotHeader{
+		Version: snapshotVersion,
+		Type:    c.tp,
+		Time:    c.clock.Now(),
+		Shards:  len(c.shards),
+	}}
+	snapshots := []*snapshot{header}
+	for _, shard := range c.shards {
+		s := shard.(snapshotter)
+		snapshot := s.takeSnapshot(s, c.tp)
+		header.header.Count += snapshot.header.Count
+		snapshots = append(snapshots, snapshot)
+	}
+	return encodeSnapshots(c.codec.NewEncoder(w), snapshots...)
+}
+
+// Restore accepts the snapshot of any cache, entries are spread over the
+// shards by their key unless it comes from as many shards.
+func (c *ShardedCache) Restore(r io.Reader) error {
+	d, err := decodeSnapshot(c.codec.NewDecoder(r))
+	if err != nil {
+		return err
+	}
+
+	snapshots := d.shards
+	if len(snapshots) != len(c.shards) {
+		merged := d.merge()
+		merged.header.Split = 0
+		snapshots = make([]*snapshot, len(c.shards))
+		for i := range snapshots {
+			snapshots[i] = &snapshot{header: merged.header}
+		}
+		for _, e := range merged.entries {
+			i := hashKey(e.Key) % uint64(len(c.shards))
+			snapshots[i].entries = append(snapshots[i].entries, e)
+		}
+	}
+	for i, shard := range c.shards {
+		s := shard.(snapshotter)
+		s.applySnapshot(s, c.tp, snapshots[i])
+	}
+	return c.checkpoint.restored()
+}
+
+func (c *ShardedCache) Close() error {
+	err := c.checkpoint.close()
+	for _, shard := range c.shards {
+		if shard == nil {
+			continue
+		}
+		if e := shard.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (c *ShardedCache) Debug() map[string][]int {
+	d := make(map[string][]int)
+	for i, shard := range c.shards {
+		for name, v := range shard.Debug() {
+			d[fmt.Sprintf("%s/%d", name, i)] = v
+		}
+	}
+	return d
+}
+
+func (c *ShardedCache) unsafeGet(key interface{}, onLoad bool) (interface{}, error) {
+	return c.shard(key).unsafeGet(key, onLoad)
+}
+
+// HitCount returns hit count
+func (c *ShardedCache) HitCount() uint64 {
+	var n uint64
+	for _, shard := range c.shards {
+		n += shard.HitCount()
+	}
+	return n
+}
+
+// MissCount returns miss count
+func (c *ShardedCache) MissCount() uint64 {
+	var n uint64
+	for _, shard := range c.shards {
+		n += shard.MissCount()
+	}
+	return n
+}
+
+// NegativeHitCount returns the number of cached loader errors returned
+func (c *ShardedCache) NegativeHitCount() uint64 {
+	var n uint64
+	for _, shard := range c.shards {
+		n += shard.NegativeHitCount()
+	}
+	return n
+}
+
+// LoadRetryCount returns the number of retried loads of every shard
+func (c *ShardedCache) LoadRetryCount() uint64 {
+	var n uint64
+	for _, shard := range c.shards {
+		n += shard.LoadRetryCount()
+	}
+	return n
+}
+
+// CircuitRejectCount returns the number of loads failed by the circuit breaker
+func (c *ShardedCache) CircuitRejectCount() uint64 {
+	var n uint64
+	for _, shard := range c.shards {
+		n += shard.CircuitRejectCount()
+	}
+	return n
+}
+
+// CircuitState returns the state of the circuit breaker shared by the shards
+func (c *ShardedCache) CircuitState() CircuitState {
+	return c.shards[0].CircuitState()
+}
+
+// EarlyRefreshCount returns the number of early reloads of every shard
+func (c *ShardedCache) EarlyRefreshCount() uint64 {
+	var n uint64
+	for _, shard := range c.shards {
+		n += shard.EarlyRefreshCount()
+	}
+	return n
+}
+
+// LookupCount returns lookup count
+func (c *ShardedCache) LookupCount() uint64 {
+	return c.HitCount() + c.MissCount()
+}
+
+// HitRate returns rate for cache hitting
+func (c *ShardedCache) HitRate() float64 {
+	hc, mc := c.HitCount(), c.MissCount()
+	total := hc + mc
+	if total == 0 {
+		return 0.0
+	}
+	return float64(hc) / float64(total)
+}
+
+// Weight returns the total weight of the entries of every shard
+func (c *ShardedCache) Weight() int64 {
+	var w int64
+	for _, shard := range c.shards {
+		w += shard.Weight()
+	}
+	return w
+}
@@ -0,0 +1,144 @@
+package gcache
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestShardedGet(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		size := 1000
+		gc, err := New(size).EvictType(tp).Shards(8).Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := gc.(*ShardedCache); !ok {
+			t.Fatalf("%s: expected a *ShardedCache, got %T", tp, gc)
+		}
+
+		testSetCache(t, gc, size/2)
+		testGetCache(t, gc, size/2)
+		if hits := gc.HitCount(); hits != uint64(size/2) {
+			t.Errorf("%s: %v != %v", tp, hits, size/2)
+		}
+
+		if l := gc.Len(); l != size/2 {
+			t.Errorf("%s: %v != %v", tp, l, size/2)
+		}
+		if keys := gc.Keys(); len(keys) != size/2 {
+			t.Errorf("%s: %v != %v", tp, len(keys), size/2)
+		}
+		if all := gc.GetALL(); len(all) != size/2 {
+			t.Errorf("%s: %v != %v", tp, len(all), size/2)
+		}
+
+		gc.Purge()
+		if l := gc.Len(); l != 0 {
+			t.Errorf("%s: %v != %v", tp, l, 0)
+		}
+	}
+}
+
+func TestShardedCapacity(t *testing.T) {
+	size := 100
+	gc, err := New(size).LRU().Shards(8).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10*size; i++ {
+		gc.Set(i, i)
+	}
+	// Each shard holds ceil(100/8) entries.
+	if l := gc.Len(); l > 8*13 || l < size*9/10 {
+		t.Errorf("unexpected length %v", l)
+	}
+}
+
+func TestShardedLoaderDeduplication(t *testing.T) {
+	var loads int64
+	gc, err := New(64).
+		LFU().
+		Shards(4).
+		LoaderFunc(func(key interface{}) (interface{}, error) {
+			atomic.AddInt64(&loads, 1)
+			return fmt.Sprint(key), nil
+		}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if v, err := gc.Get(i % 10); err != nil || v != fmt.Sprint(i%10) {
+				t.Errorf("Get(%v) = %v, %v", i%10, v, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt64(&loads); n != 10 {
+		t.Errorf("loader called %v times, expected 10", n)
+	}
+
+	values, errs := gc.GetMany([]interface{}{1, 2, 30, 40})
+	if len(values) != 4 || len(errs) != 0 {
+		t.Errorf("unexpected result %v, %v", values, errs)
+	}
+}
+
+func TestShardedInvalidShards(t *testing.T) {
+	if _, err := New(8).Shards(-1).Build(); err == nil {
+		t.Error("expected an error for a negative number of shards")
+	}
+	gc, err := New(8).LRU().Shards(1).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gc.(*LRUCache); !ok {
+		t.Errorf("a single shard should not be wrapped, got %T", gc)
+	}
+}
+
+type stringerKey struct{ n int }
+
+var stringerCalls int32
+
+func (k stringerKey) String() string {
+	atomic.AddInt32(&stringerCalls, 1)
+	return fmt.Sprint(k.n)
+}
+
+func TestShardedPointerKey(t *testing.T) {
+	gc := mustBuild(t, New(1024).LRU().Shards(16))
+
+	type mutable struct{ n int }
+	keys := make([]*mutable, 32)
+	for i := range keys {
+		keys[i] = &mutable{n: i}
+		gc.Set(keys[i], i)
+	}
+	for i, k := range keys {
+		k.n += 100
+		if v, err := gc.Get(k); err != nil || v != i {
+			t.Errorf("a mutated pointer key should still be found, got %v, %v", v, err)
+		}
+	}
+	if l := gc.Len(); l != len(keys) {
+		t.Errorf("%v != %v", l, len(keys))
+	}
+
+	gc.Set(stringerKey{n: 1}, 1)
+	if v, err := gc.Get(stringerKey{n: 1}); err != nil || v != 1 {
+		t.Errorf("got %v, %v", v, err)
+	}
+	if n := atomic.LoadInt32(&stringerCalls); n != 0 {
+		t.Errorf("hashing a key should not call its String method, called %v times", n)
+	}
+}
@@ -1,6 +1,10 @@
 package gcache
 
-import "time"
+import (
+	"context"
+	"io"
+	"time"
+)
 
 type SimpleCache struct {
 	baseCache
@@ -8,9 +12,9 @@ type SimpleCache struct {
 }
 
 type simpleItem struct {
-	clock      Clock
-	value      interface{}
-	expiration *time.Time
+	expiry
+	value  interface{}
+	weight int64
 }
 
 func newSimpleCache(cb *CacheBuilder) *SimpleCache {
@@ -19,6 +23,9 @@ func newSimpleCache(cb *CacheBuilder) *SimpleCache {
 
 	c.init()
 	c.loadGroup.cache = c
+	c.startJanitor(c, cb.cleanupInterval)
+	c.startMemoryController(c, cb.memoryPressure)
+	c.startCheckpointer(c, cb.persistence)
 	return c
 }
 
@@ -31,13 +38,17 @@ func (c *SimpleCache) init() {
 }
 
 func (c *SimpleCache) set(key, value interface{}) (interface{}, error) {
-	var err error
-	if c.serializeFunc != nil {
-		value, err = c.serializeFunc(key, value)
-		if err != nil {
-			return nil, err
-		}
+	value, w, err := c.prepare(key, value)
+	if err != nil {
+		return nil, err
 	}
+	return c.insert(key, value, w), nil
+}
+
+// insert stores value, prepared with its weight w.
+func (c *SimpleCache) insert(key, value interface{}, w int64) interface{} {
+	c.forgetHeld(key)
+	c.makeRoom(c, key, w)
 
 	if c.addedFunc != nil {
 		defer c.addedFunc(key, value)
@@ -47,53 +58,88 @@ func (c *SimpleCache) set(key, value interface{}) (interface{}, error) {
 	if !exists {
 		// for TYPE_SIMPLE, when capacity < 0 we do not bound the cache capacity
 		if len(c.store) >= c.capacity && c.capacity > 0 {
-			c.evict(1)
+			c.evict(1, RemovalSize)
 		}
 
 		entry = &simpleItem{
-			clock: c.clock,
-			value: value,
+			expiry: expiry{clock: c.clock},
+			value:  value,
 		}
 		c.store[key] = entry
 	}
 
+	if exists {
+		c.removed(key, entry.value, nil, RemovalReplaced)
+	}
 	entry.value = value
+	c.addWeight(w - entry.weight)
+	entry.weight = w
 
-	if c.expiration != nil {
-		t := c.clock.Now().Add(*c.expiration)
-		entry.expiration = &t
-	}
+	c.expireAfterWrite(key, &entry.expiry)
 
-	return entry, nil
+	return entry
 }
 
 func (c *SimpleCache) Set(key, value interface{}) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	_, err := c.set(key, value)
-	return err
+
+	stored, w, err := c.prepare(key, value)
+	if err != nil {
+		return err
+	}
+	if err := c.beforeSet(key, value); err != nil {
+		return err
+	}
+	c.insert(key, stored, w)
+	return nil
 }
 
 func (c *SimpleCache) SetWithExpire(key, value interface{}, expiration time.Duration) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	item, err := c.set(key, value)
+
+	stored, w, err := c.prepare(key, value)
+	if err != nil {
+		return err
+	}
+	if err := c.beforeSetWithExpire(key, value, expiration); err != nil {
+		return err
+	}
+	item := c.insert(key, stored, w)
+
+	c.expireAt(key, &item.(*simpleItem).expiry, expiration)
+	return nil
+}
+
+func (c *SimpleCache) SetWithExpireAfterAccess(key, value interface{}, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, w, err := c.prepare(key, value)
 	if err != nil {
 		return err
 	}
+	if err := c.beforeSetWithExpireAfterAccess(key, value, expiration); err != nil {
+		return err
+	}
+	item := c.insert(key, stored, w)
 
-	t := c.clock.Now().Add(expiration)
-	item.(*simpleItem).expiration = &t
+	c.expireAfterAccess(key, &item.(*simpleItem).expiry, expiration)
 	return nil
 }
 
 func (c *SimpleCache) Get(key interface{}) (interface{}, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *SimpleCache) GetContext(ctx context.Context, key interface{}) (interface{}, error) {
 	c.mu.Lock()
 	v, err := c.get(key, false)
 	c.mu.Unlock()
 
 	if err == KeyNotFoundError {
-		return c.getWithLoader(key, true)
+		return c.getWithLoader(ctx, key, true)
 	}
 	return v, err
 }
@@ -103,7 +149,7 @@ func (c *SimpleCache) GetIFPresent(key interface{}) (interface{}, error) {
 	v, err := c.get(key, false)
 	c.mu.Unlock()
 	if err == KeyNotFoundError {
-		return c.getWithLoader(key, false)
+		return c.getWithLoader(context.Background(), key, false)
 	}
 	return v, nil
 }
@@ -117,14 +163,16 @@ func (c *SimpleCache) get(key interface{}, onLoad bool) (interface{}, error) {
 		return nil, KeyNotFoundError
 	}
 
-	if item.IsExpired(nil) {
-		c.remove(key)
+	if item.isExpired(nil) {
+		c.remove(key, RemovalExpired)
 		return nil, KeyNotFoundError
 	}
 
 	v := item.value
 	if !onLoad {
 		c.stats.IncrHitCount()
+		c.accessed(key, &item.expiry)
+		c.refreshIfStale(key, &item.expiry)
 	}
 
 	if c.deserializeFunc != nil {
@@ -133,29 +181,50 @@ func (c *SimpleCache) get(key interface{}, onLoad bool) (interface{}, error) {
 	return v, nil
 }
 
-func (c *SimpleCache) getWithLoader(key interface{}, isWait bool) (interface{}, error) {
+func (c *SimpleCache) storeEntry(key, value interface{}, expiration, accessTTL *time.Duration) error {
+	item, err := c.set(key, value)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case expiration != nil:
+		c.expireAt(key, &item.(*simpleItem).expiry, *expiration)
+	case accessTTL != nil:
+		c.expireAfterAccess(key, &item.(*simpleItem).expiry, *accessTTL)
+	}
+	return nil
+}
+
+func (c *SimpleCache) removeEntry(key interface{}) {
+	c.remove(key, RemovalExplicit)
+}
+
+func (c *SimpleCache) expiryOf(key interface{}) (*expiry, bool) {
+	if item, ok := c.store[key]; ok {
+		return &item.expiry, true
+	}
+	return nil, false
+}
+
+func (c *SimpleCache) getWithLoader(ctx context.Context, key interface{}, isWait bool) (interface{}, error) {
 	if c.loaderExpireFunc == nil {
 		return nil, KeyNotFoundError
 	}
-	value, _, err := c.load(key, func(v interface{}, expiration *time.Duration, e error) (interface{}, error) {
+	value, _, err := c.load(ctx, key, func(v interface{}, expiration *time.Duration, e error) (interface{}, error) {
 		if e != nil {
 			return nil, e
 		}
 
-		err := c.Set(key, v)
-		if err != nil {
+		if err := c.setLoaded(key, v, expiration); err != nil {
 			return nil, err
 		}
-
 		return v, nil
 	}, isWait)
-	if err != nil {
-		return nil, err
-	}
-	return value, nil
+	return value, err
 }
 
-func (c *SimpleCache) evict(count int) {
+func (c *SimpleCache) evict(count int, cause RemovalCause) {
 	now := c.clock.Now()
 	current := 0
 	for key, item := range c.store {
@@ -163,26 +232,63 @@ func (c *SimpleCache) evict(count int) {
 			return
 		}
 		if item.expiration == nil || now.After(*item.expiration) {
-			defer c.remove(key)
+			defer c.remove(key, cause)
 			current++
 		}
 	}
 }
 
+func (c *SimpleCache) weightOf(key interface{}) int64 {
+	if item, ok := c.store[key]; ok {
+		return item.weight
+	}
+	return 0
+}
+
+// evictOne falls back to any entry when evict finds none it is willing to
+// drop, as a heavy entry must make room.
+func (c *SimpleCache) evictOne(cause RemovalCause) bool {
+	n := len(c.store)
+	c.evict(1, cause)
+	if len(c.store) < n {
+		return true
+	}
+	for key := range c.store {
+		c.remove(key, cause)
+		return true
+	}
+	return false
+}
+
+func (c *SimpleCache) resize(capacity int) {
+	c.capacity = capacity
+	for len(c.store) > capacity && c.evictOne(RemovalResized) {
+	}
+}
+
+func (c *SimpleCache) expire(key interface{}, now time.Time) {
+	if item, ok := c.store[key]; ok && item.isExpired(&now) {
+		c.remove(key, RemovalExpired)
+	}
+}
+
 func (c *SimpleCache) Remove(key interface{}) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	return c.remove(key)
+	if err := c.beforeRemove(key); err != nil {
+		return err
+	}
+	c.forgetHeld(key)
+	return c.remove(key, RemovalExplicit)
 }
 
-func (c *SimpleCache) remove(key interface{}) error {
+func (c *SimpleCache) remove(key interface{}, cause RemovalCause) error {
 	item, ok := c.store[key]
 	if ok {
 		delete(c.store, key)
-		if c.evictedFunc != nil {
-			c.evictedFunc(key, item.value)
-		}
+		c.addWeight(-item.weight)
+		c.removed(key, item.value, &item.expiry, cause)
 		return nil
 	}
 	return KeyNotFoundError
@@ -223,31 +329,46 @@ func (c *SimpleCache) GetALL() map[interface{}]interface{} {
 }
 
 func (c *SimpleCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return len(c.store)
 }
 
-func (c *SimpleCache) Purge() {
+// Resize accepts a capacity <= 0, making the cache unbounded as it does at
+// Build time.
+func (c *SimpleCache) Resize(capacity int) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.purgeVisitorFunc != nil {
-		for key, item := range c.store {
-			c.purgeVisitorFunc(key, item.value)
+	if capacity <= 0 {
+		c.capacity = capacity
+		if c.pressure != nil {
+			c.pressure.capacity = capacity
 		}
+		return nil
 	}
+	return c.setCapacity(c, capacity)
+}
 
-	c.init()
+func (c *SimpleCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logPurge()
+	c.purgeHeld()
+	c.purge()
 }
 
-func (si *simpleItem) IsExpired(now *time.Time) bool {
-	if si.expiration == nil {
-		return false
-	}
-	if now == nil {
-		t := si.clock.Now()
-		now = &t
+func (c *SimpleCache) purge() {
+	if c.purgeVisitorFunc != nil || c.listensRemovals() {
+		for key, item := range c.store {
+			c.purged(key, item.value)
+		}
 	}
-	return si.expiration.Before(*now)
+
+	c.resetExpirations()
+	c.resetWeight()
+	c.init()
 }
 
 func (c *SimpleCache) Debug() map[string][]int {
@@ -256,6 +377,41 @@ func (c *SimpleCache) Debug() map[string][]int {
 	return d
 }
 
+func (c *SimpleCache) Snapshot(w io.Writer) error {
+	return c.snapshot(c, TYPE_SIMPLE, w)
+}
+
+func (c *SimpleCache) Restore(r io.Reader) error {
+	return c.restore(c, TYPE_SIMPLE, r)
+}
+
+func (c *SimpleCache) exportEntries(at time.Time) ([]SnapshotEntry, int) {
+	entries := make([]SnapshotEntry, 0, len(c.store))
+	for key, item := range c.store {
+		entry := SnapshotEntry{Key: key, Value: item.value}
+		item.export(at, &entry)
+		entries = append(entries, entry)
+	}
+	return entries, 0
+}
+
+func (c *SimpleCache) importEntries(at time.Time, entries []SnapshotEntry, _ int) {
+	for i := range entries {
+		e := &entries[i]
+		w, ok := c.importWeight(e)
+		if !ok {
+			continue
+		}
+		item := &simpleItem{
+			expiry: expiry{clock: c.clock},
+			value:  e.Value,
+			weight: w,
+		}
+		item.restore(at, e)
+		c.store[e.Key] = item
+	}
+}
+
 func (c *SimpleCache) unsafeGet(key interface{}, onLoad bool) (interface{}, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -19,13 +19,21 @@ limitations under the License.
 // This module provides a duplicate function call suppression
 // mechanism.
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 // call is an in-flight or completed Do call
 type call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+	done chan struct{}
+	val  interface{}
+	err  error
+
+	// waiters counts the callers blocked on the call. When all of them gave
+	// up on their context, the loader's context is cancelled too.
+	waiters int
+	cancel  context.CancelFunc
 }
 
 // Group represents a class of work and forms a namespace in which
@@ -41,42 +49,194 @@ type Group struct {
 // time. If a duplicate comes in, the duplicate caller waits for the
 // original to complete and receives the same results.
 func (g *Group) Do(key interface{}, fn func() (interface{}, error), isWait bool) (interface{}, bool, error) {
+	return g.do(context.Background(), key, func(context.Context) (interface{}, error) {
+		return fn()
+	}, isWait, true)
+}
+
+// DoContext is like Do, but a caller stops waiting and returns ctx.Err()
+// once ctx is done. This never cancels the call for the other callers
+// waiting on it. fn receives a context carrying the values and deadline of
+// the ctx that started the call, which is only cancelled when every caller
+// gave up.
+func (g *Group) DoContext(ctx context.Context, key interface{}, fn func(context.Context) (interface{}, error), isWait bool) (interface{}, bool, error) {
+	return g.do(ctx, key, fn, isWait, true)
+}
+
+// do is DoContext, with lookup controlling whether a value already present
+// in the cache short-circuits fn. Refreshes need fn to run even though it is.
+func (g *Group) do(ctx context.Context, key interface{}, fn func(context.Context) (interface{}, error), isWait, lookup bool) (interface{}, bool, error) {
 	g.mu.Lock()
-	v, err := g.cache.unsafeGet(key, true)
-	if err == nil {
-		g.mu.Unlock()
-		return v, false, nil
+	if lookup {
+		v, err := g.cache.unsafeGet(key, true)
+		if err == nil {
+			g.mu.Unlock()
+			return v, false, nil
+		}
 	}
 	if g.m == nil {
 		g.m = make(map[interface{}]*call)
 	}
 	if c, ok := g.m[key]; ok {
-		g.mu.Unlock()
 		if !isWait {
+			g.mu.Unlock()
 			return nil, false, KeyNotFoundError
 		}
-		c.wg.Wait()
-		return c.val, false, c.err
+		c.waiters++
+		g.mu.Unlock()
+		v, err := g.wait(ctx, c, key)
+		return v, false, err
+	}
+
+	c := &call{done: make(chan struct{})}
+	callCtx := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		callCtx, c.cancel = context.WithDeadline(callCtx, deadline)
+	} else {
+		callCtx, c.cancel = context.WithCancel(callCtx)
+	}
+	if isWait {
+		c.waiters = 1
 	}
-	c := new(call)
-	c.wg.Add(1)
 	g.m[key] = c
 	g.mu.Unlock()
+
+	go g.call(callCtx, c, key, fn)
 	if !isWait {
-		go g.call(c, key, fn)
 		return nil, false, KeyNotFoundError
 	}
-	v, err = g.call(c, key, fn)
+	v, err := g.wait(ctx, c, key)
 	return v, true, err
 }
 
-func (g *Group) call(c *call, key interface{}, fn func() (interface{}, error)) (interface{}, error) {
-	c.val, c.err = fn()
-	c.wg.Done()
+func (g *Group) wait(ctx context.Context, c *call, key interface{}) (interface{}, error) {
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-c.done:
+		// The call completed meanwhile, don't waste its result.
+		return c.val, c.err
+	default:
+	}
+	c.waiters--
+	if c.waiters == 0 {
+		c.cancel()
+		if g.m[key] == c {
+			delete(g.m, key)
+		}
+	}
+	return nil, ctx.Err()
+}
+
+func (g *Group) call(ctx context.Context, c *call, key interface{}, fn func(context.Context) (interface{}, error)) {
+	c.val, c.err = fn(ctx)
+	c.cancel()
+	close(c.done)
+
+	g.mu.Lock()
+	if g.m[key] == c {
+		delete(g.m, key)
+	}
+	g.mu.Unlock()
+}
+
+// doMany resolves several keys at once. Keys already present in the cache
+// are returned right away and keys with an in-flight call wait on it; the
+// remaining ones are handed to a single fn call, during which concurrent
+// callers asking for any of them wait on that batch instead of starting
+// their own call.
+func (g *Group) doMany(ctx context.Context, keys []interface{}, fn func(context.Context, []interface{}) (map[interface{}]interface{}, error)) (map[interface{}]interface{}, map[interface{}]error) {
+	values := make(map[interface{}]interface{}, len(keys))
+	errs := make(map[interface{}]error)
+	waiting := make(map[interface{}]*call, len(keys))
+
+	var ownKeys []interface{}
+	var ownCalls []*call
+	callCtx := context.WithoutCancel(ctx)
+	var cancel context.CancelFunc
+	if deadline, ok := ctx.Deadline(); ok {
+		callCtx, cancel = context.WithDeadline(callCtx, deadline)
+	} else {
+		callCtx, cancel = context.WithCancel(callCtx)
+	}
+	// The batch is cancelled once every one of its calls was abandoned.
+	abandoned := 0
+	cancelOne := func() {
+		abandoned++
+		if abandoned == len(ownCalls) {
+			cancel()
+		}
+	}
 
 	g.mu.Lock()
-	delete(g.m, key)
+	if g.m == nil {
+		g.m = make(map[interface{}]*call)
+	}
+	for _, key := range keys {
+		if _, ok := waiting[key]; ok {
+			continue
+		}
+		if v, err := g.cache.unsafeGet(key, true); err == nil {
+			values[key] = v
+			continue
+		}
+		c, ok := g.m[key]
+		if ok {
+			c.waiters++
+		} else {
+			c = &call{done: make(chan struct{}), waiters: 1, cancel: cancelOne}
+			g.m[key] = c
+			ownKeys = append(ownKeys, key)
+			ownCalls = append(ownCalls, c)
+		}
+		waiting[key] = c
+	}
 	g.mu.Unlock()
 
-	return c.val, c.err
+	if len(ownKeys) > 0 {
+		go g.callMany(callCtx, cancel, ownKeys, ownCalls, fn)
+	} else {
+		cancel()
+	}
+
+	for key, c := range waiting {
+		v, err := g.wait(ctx, c, key)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		values[key] = v
+	}
+	return values, errs
+}
+
+func (g *Group) callMany(ctx context.Context, cancel context.CancelFunc, keys []interface{}, calls []*call, fn func(context.Context, []interface{}) (map[interface{}]interface{}, error)) {
+	values, err := fn(ctx, keys)
+	cancel()
+
+	for i, key := range keys {
+		c := calls[i]
+		if err != nil {
+			c.err = err
+		} else if v, ok := values[key]; ok {
+			c.val = v
+		} else {
+			c.err = KeyNotFoundError
+		}
+		close(c.done)
+	}
+
+	g.mu.Lock()
+	for i, key := range keys {
+		if g.m[key] == calls[i] {
+			delete(g.m, key)
+		}
+	}
+	g.mu.Unlock()
 }
@@ -17,6 +17,7 @@ limitations under the License.
 */
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -102,3 +103,82 @@ func TestDoDupSuppress(t *testing.T) {
 		t.Errorf("number of calls = %d; want 1", got)
 	}
 }
+
+func TestDoContextWaiterCancel(t *testing.T) {
+	var g Group
+	gc, err := New(32).Build()
+	if err != nil {
+		t.Error(err)
+	}
+	g.cache = gc
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var loaderCtx context.Context
+	fn := func(ctx context.Context) (interface{}, error) {
+		loaderCtx = ctx
+		close(started)
+		<-release
+		return "bar", nil
+	}
+
+	result := make(chan error)
+	go func() {
+		v, _, err := g.DoContext(context.Background(), "key", fn, true)
+		if v != "bar" {
+			t.Errorf("got %q; want %q", v, "bar")
+		}
+		result <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	abandoned := make(chan error)
+	go func() {
+		_, _, err := g.DoContext(ctx, "key", fn, true)
+		abandoned <- err
+	}()
+	cancel()
+	if err := <-abandoned; err != context.Canceled {
+		t.Errorf("Do error = %v; want context.Canceled", err)
+	}
+	if err := loaderCtx.Err(); err != nil {
+		t.Errorf("loader context should still be alive, got %v", err)
+	}
+
+	close(release)
+	if err := <-result; err != nil {
+		t.Errorf("Do error = %v", err)
+	}
+}
+
+func TestDoContextAllWaitersGone(t *testing.T) {
+	var g Group
+	gc, err := New(32).Build()
+	if err != nil {
+		t.Error(err)
+	}
+	g.cache = gc
+
+	cancelled := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, _, err := g.DoContext(ctx, "key", fn, true); err != context.Canceled {
+		t.Errorf("Do error = %v; want context.Canceled", err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("loader context was not cancelled")
+	}
+}
@@ -0,0 +1,136 @@
+package gcache
+
+const (
+	sketchDepth   = 4
+	sketchMaxFreq = 15
+)
+
+// cmSketch is a count-min sketch used by TinyLFU to estimate how often a key
+// has been seen. Counters saturate at 15 (as 4-bit counters would) and are all
+// halved once sampleSize increments were recorded, so that old popularity fades.
+type cmSketch struct {
+	rows       [sketchDepth][]uint8
+	mask       uint64
+	additions  int
+	sampleSize int
+
+	door *doorkeeper
+}
+
+func newCMSketch(capacity int) *cmSketch {
+	width := nextPowerOfTwo(maxInt(capacity, 16))
+	s := &cmSketch{
+		mask:       uint64(width - 1),
+		sampleSize: 10 * width,
+		door:       newDoorkeeper(width),
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// increment records one occurrence of the key hash h. The first occurrence of
+// a key within a sample period only lands in the doorkeeper, which keeps
+// one-hit wonders from polluting the sketch.
+func (s *cmSketch) increment(h uint64) {
+	if !s.door.add(h) {
+		return
+	}
+
+	h1, h2 := h, h>>32|h<<32
+	for i := range s.rows {
+		idx := (h1 + uint64(i)*h2) & s.mask
+		if s.rows[i][idx] < sketchMaxFreq {
+			s.rows[i][idx]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.reset()
+	}
+}
+
+// estimate returns the approximate frequency of the key hash h.
+func (s *cmSketch) estimate(h uint64) int {
+	h1, h2 := h, h>>32|h<<32
+	min := uint8(sketchMaxFreq)
+	for i := range s.rows {
+		idx := (h1 + uint64(i)*h2) & s.mask
+		if s.rows[i][idx] < min {
+			min = s.rows[i][idx]
+		}
+	}
+	freq := int(min)
+	if s.door.contains(h) {
+		freq++
+	}
+	return freq
+}
+
+// reset ages the sketch by halving every counter and clearing the doorkeeper.
+func (s *cmSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.door.clear()
+	s.additions /= 2
+}
+
+// doorkeeper is a small bloom filter placed in front of the sketch.
+type doorkeeper struct {
+	bits []uint64
+	mask uint64
+}
+
+func newDoorkeeper(width int) *doorkeeper {
+	// Use 8 bits per expected entry, with at least one word.
+	nbits := nextPowerOfTwo(maxInt(width*8, 64))
+	return &doorkeeper{
+		bits: make([]uint64, nbits/64),
+		mask: uint64(nbits - 1),
+	}
+}
+
+// add sets the bits for h and reports whether they were all already set.
+func (d *doorkeeper) add(h uint64) bool {
+	present := true
+	for _, idx := range d.indexes(h) {
+		word, bit := idx/64, idx%64
+		if d.bits[word]&(1<<bit) == 0 {
+			present = false
+			d.bits[word] |= 1 << bit
+		}
+	}
+	return present
+}
+
+func (d *doorkeeper) contains(h uint64) bool {
+	for _, idx := range d.indexes(h) {
+		if d.bits[idx/64]&(1<<(idx%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *doorkeeper) indexes(h uint64) [2]uint64 {
+	return [2]uint64{h & d.mask, (h >> 32) & d.mask}
+}
+
+func (d *doorkeeper) clear() {
+	for i := range d.bits {
+		d.bits[i] = 0
+	}
+}
+
+func nextPowerOfTwo(x int) int {
+	n := 1
+	for n < x {
+		n <<= 1
+	}
+	return n
+}
@@ -0,0 +1,38 @@
+package gcache
+
+import "testing"
+
+func TestCMSketchEstimate(t *testing.T) {
+	s := newCMSketch(64)
+	h := hashKey("key")
+
+	if f := s.estimate(h); f != 0 {
+		t.Errorf("%v != %v", f, 0)
+	}
+	for i := 0; i < 5; i++ {
+		s.increment(h)
+	}
+	// The first increment only goes to the doorkeeper.
+	if f := s.estimate(h); f != 5 {
+		t.Errorf("%v != %v", f, 5)
+	}
+	for i := 0; i < 100; i++ {
+		s.increment(h)
+	}
+	if f := s.estimate(h); f != sketchMaxFreq+1 {
+		t.Errorf("%v != %v", f, sketchMaxFreq+1)
+	}
+}
+
+func TestCMSketchReset(t *testing.T) {
+	s := newCMSketch(16)
+	h := hashKey(1)
+	for i := 0; i < 9; i++ {
+		s.increment(h)
+	}
+	s.reset()
+
+	if f := s.estimate(h); f != 4 {
+		t.Errorf("%v != %v", f, 4)
+	}
+}
@@ -0,0 +1,325 @@
+package gcache
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Codec encodes and decodes snapshots, see CacheBuilder.SnapshotCodec.
+type Codec interface {
+	NewEncoder(io.Writer) Encoder
+	NewDecoder(io.Reader) Decoder
+}
+
+type Encoder interface {
+	Encode(interface{}) error
+}
+
+type Decoder interface {
+	Decode(interface{}) error
+}
+
+// GobCodec is the default Codec. Keys and values of types other than the
+// predeclared ones must be registered with gob.Register.
+var GobCodec Codec = gobCodec{}
+
+type gobCodec struct{}
+
+func (gobCodec) NewEncoder(w io.Writer) Encoder {
+	return gob.NewEncoder(w)
+}
+
+func (gobCodec) NewDecoder(r io.Reader) Decoder {
+	return gob.NewDecoder(r)
+}
+
+const snapshotVersion = 1
+
+// A snapshot is a SnapshotHeader followed by Count SnapshotEntry. Snapshots
+// of sharded caches are a SnapshotHeader with Shards set, followed by the
+// snapshot of every shard.
+type SnapshotHeader struct {
+	Version int
+	Type    string
+	// Time is when the snapshot was taken, according to the cache's Clock.
+	Time   time.Time
+	Count  int
+	Shards int
+	// Split is the ARC learning parameter.
+	Split int
+}
+
+// SnapshotEntry holds an entry and its policy metadata. Durations are
+// relative to the header's Time.
+type SnapshotEntry struct {
+	Key   interface{}
+	Value interface{}
+
+	// TTL is the time left before the entry expires, nil if it doesn't.
+	TTL *time.Duration
+	// Ceiling is the time left before the expire-after-write deadline.
+	Ceiling   *time.Duration
+	AccessTTL *time.Duration
+	// Age is the time since the value was written.
+	Age time.Duration
+
+	// Freq is the LFU frequency, or TinyLFU's estimate.
+	Freq uint
+	// Segment is the ARC list or the TinyLFU segment of the entry.
+	Segment int
+	// Ghost marks the keys of ARC's ghost lists, they have no value.
+	Ghost bool
+}
+
+// snapshotter is implemented by every cache type. Called with the cache lock
+// held, exportEntries lists the entries from the first to the last to be
+// evicted, and importEntries adds them back to an empty cache, in order.
+type snapshotter interface {
+	resizer
+	exportEntries(at time.Time) ([]SnapshotEntry, int)
+	importEntries(at time.Time, entries []SnapshotEntry, split int)
+	purge()
+
+	takeSnapshot(cache snapshotter, tp string) *snapshot
+	applySnapshot(cache snapshotter, tp string, s *snapshot)
+}
+
+type snapshot struct {
+	header  SnapshotHeader
+	entries []SnapshotEntry
+}
+
+func (e *expiry) export(at time.Time, entry *SnapshotEntry) {
+	if e.expiration != nil {
+		ttl := e.expiration.Sub(at)
+		entry.TTL = &ttl
+	}
+	if e.ceiling != nil {
+		ceiling := e.ceiling.Sub(at)
+		entry.Ceiling = &ceiling
+	}
+	entry.AccessTTL = e.accessTTL
+	entry.Age = at.Sub(e.updated)
+}
+
+func (e *expiry) restore(at time.Time, entry *SnapshotEntry) {
+	if entry.TTL != nil {
+		t := at.Add(*entry.TTL)
+		e.expiration = &t
+	}
+	if entry.Ceiling != nil {
+		t := at.Add(*entry.Ceiling)
+		e.ceiling = &t
+	}
+	e.accessTTL = entry.AccessTTL
+	e.updated = at.Add(-entry.Age)
+}
+
+// takeSnapshot copies the entries of the cache that haven't expired.
+func (c *baseCache) takeSnapshot(cache snapshotter, tp string) *snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.drainReads()
+	now := c.clock.Now()
+	entries, split := cache.exportEntries(now)
+	live := entries[:0]
+	for _, e := range entries {
+		if e.TTL == nil || *e.TTL >= 0 {
+			live = append(live, e)
+		}
+	}
+	return &snapshot{
+		header: SnapshotHeader{
+			Version: snapshotVersion,
+			Type:    tp,
+			Time:    now,
+			Count:   len(live),
+			Split:   split,
+		},
+		entries: live,
+	}
+}
+
+// applySnapshot replaces the contents of the cache with the snapshot.
+// Entries that expired since it was taken are skipped, and the policy
+// metadata is only kept if the snapshot comes from a cache of the same type.
+func (c *baseCache) applySnapshot(cache snapshotter, tp string, s *snapshot) {
+	now := c.clock.Now()
+	sameType := s.header.Type == tp
+	split := 0
+	if sameType {
+		split = s.header.Split
+	}
+
+	entries := make([]SnapshotEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		if e.TTL != nil && s.header.Time.Add(*e.TTL).Before(now) {
+			continue
+		}
+		if !sameType {
+			if e.Ghost {
+				continue
+			}
+			e.Freq = 0
+			e.Segment = 0
+		}
+		entries = append(entries, e)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.purgeHeld()
+	cache.purge()
+	cache.importEntries(s.header.Time, entries, split)
+	for _, e := range entries {
+		if !e.Ghost {
+			c.scheduleExpiration(e.Key, expirationOf(s.header.Time, &e))
+		}
+	}
+
+	// The snapshot may come from a bigger cache.
+	if c.capacity > 0 {
+		cache.resize(c.capacity)
+	}
+	for c.maxWeight > 0 && c.Weight() > c.maxWeight && cache.evictOne(RemovalResized) {
+	}
+}
+
+func expirationOf(at time.Time, e *SnapshotEntry) *time.Time {
+	if e.TTL == nil {
+		return nil
+	}
+	t := at.Add(*e.TTL)
+	return &t
+}
+
+// importWeight returns the weight of a restored entry, and false if it is
+// too heavy to be kept.
+func (c *baseCache) importWeight(e *SnapshotEntry) (int64, bool) {
+	w, err := c.weigh(e.Key, e.Value)
+	if err != nil {
+		return 0, false
+	}
+	c.addWeight(w)
+	return w, true
+}
+
+func (c *baseCache) snapshot(cache snapshotter, tp string, w io.Writer) error {
+	return encodeSnapshots(c.codec.NewEncoder(w), cache.takeSnapshot(cache, tp))
+}
+
+func (c *baseCache) restore(cache snapshotter, tp string, r io.Reader) error {
+	s, err := decodeSnapshot(c.codec.NewDecoder(r))
+	if err != nil {
+		return err
+	}
+	cache.applySnapshot(cache, tp, s.merge())
+	return c.checkpoint.restored()
+}
+
+func encodeSnapshots(enc Encoder, snapshots ...*snapshot) error {
+	for _, s := range snapshots {
+		if err := enc.Encode(&s.header); err != nil {
+			return err
+		}
+		for i := range s.entries {
+			if err := enc.Encode(&s.entries[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decodedSnapshot is a snapshot, or the snapshots of the shards of a
+// sharded cache.
+type decodedSnapshot struct {
+	header SnapshotHeader
+	shards []*snapshot
+}
+
+func decodeSnapshot(dec Decoder) (*decodedSnapshot, error) {
+	d := &decodedSnapshot{}
+	if err := dec.Decode(&d.header); err != nil {
+		return nil, fmt.Errorf("gcache2: can't decode snapshot: %v", err)
+	}
+	if d.header.Version != snapshotVersion {
+		return nil, fmt.Errorf("gcache2: unsupported snapshot version %v", d.header.Version)
+	}
+	if d.header.Shards == 0 {
+		s, err := decodeEntries(dec, d.header)
+		if err != nil {
+			return nil, err
+		}
+		d.shards = []*snapshot{s}
+		return d, nil
+	}
+
+	for i := 0; i < d.header.Shards; i++ {
+		var h SnapshotHeader
+		if err := dec.Decode(&h); err != nil {
+			return nil, fmt.Errorf("gcache2: can't decode snapshot: %v", err)
+		}
+		s, err := decodeEntries(dec, h)
+		if err != nil {
+			return nil, err
+		}
+		d.shards = append(d.shards, s)
+	}
+	return d, nil
+}
+
+func decodeEntries(dec Decoder, h SnapshotHeader) (*snapshot, error) {
+	if h.Count < 0 {
+		return nil, fmt.Errorf("gcache2: invalid snapshot entry count %v", h.Count)
+	}
+	s := &snapshot{header: h}
+	for i := 0; i < h.Count; i++ {
+		var e SnapshotEntry
+		if err := dec.Decode(&e); err != nil {
+			return nil, fmt.Errorf("gcache2: can't decode snapshot entry: %v", err)
+		}
+		s.entries = append(s.entries, e)
+	}
+	return s, nil
+}
+
+// merge turns the snapshots of several shards into a single one. ARC's split
+// is only kept when there was a single shard.
+func (d *decodedSnapshot) merge() *snapshot {
+	if len(d.shards) == 1 {
+		return d.shards[0]
+	}
+	s := &snapshot{header: d.header}
+	s.header.Shards = 0
+	s.header.Split = 0
+	for _, shard := range d.shards {
+		s.entries = append(s.entries, shard.entries...)
+		// Every shard snapshot has its own time, keep TTLs relative to
+		// the outer header.
+		offset := shard.header.Time.Sub(d.header.Time)
+		for i := len(s.entries) - len(shard.entries); i < len(s.entries); i++ {
+			s.entries[i].rebase(offset)
+		}
+	}
+	s.header.Count = len(s.entries)
+	return s
+}
+
+// rebase makes the durations of e relative to a time offset earlier than
+// the one they were taken at.
+func (e *SnapshotEntry) rebase(offset time.Duration) {
+	if e.TTL != nil {
+		ttl := *e.TTL + offset
+		e.TTL = &ttl
+	}
+	if e.Ceiling != nil {
+		ceiling := *e.Ceiling + offset
+		e.Ceiling = &ceiling
+	}
+	e.Age -= offset
+}
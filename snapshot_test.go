@@ -0,0 +1,257 @@
+package gcache
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustBuild(t *testing.T, cb *CacheBuilder) Cache {
+	gc, err := cb.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gc
+}
+
+func has(gc Cache, key interface{}) bool {
+	_, err := gc.GetIFPresent(key)
+	return err == nil
+}
+
+func snapshotOf(t *testing.T, gc Cache) *bytes.Buffer {
+	var buf bytes.Buffer
+	if err := gc.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		gc := mustBuild(t, New(16).EvictType(tp))
+		testSetCache(t, gc, 8)
+		buf := snapshotOf(t, gc)
+
+		restored := mustBuild(t, New(16).EvictType(tp))
+		restored.Set("stale", 1)
+		if err := restored.Restore(buf); err != nil {
+			t.Fatalf("%v: %v", tp, err)
+		}
+		if l := restored.Len(); l != 8 {
+			t.Errorf("%v: Len should be 8, not %v", tp, l)
+		}
+		if _, err := restored.GetIFPresent("stale"); err != KeyNotFoundError {
+			t.Errorf("%v: Restore should replace the contents of the cache", tp)
+		}
+		testGetCache(t, restored, 8)
+	}
+}
+
+func TestSnapshotLRUOrder(t *testing.T) {
+	gc := mustBuild(t, New(3).LRU())
+	gc.Set("a", 1)
+	gc.Set("b", 2)
+	gc.Set("c", 3)
+	gc.Get("a")
+
+	restored := mustBuild(t, New(3).LRU())
+	if err := restored.Restore(snapshotOf(t, gc)); err != nil {
+		t.Fatal(err)
+	}
+	restored.Set("d", 4)
+	if has(restored, "b") {
+		t.Error("b was the least recently used entry, it should be evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if !has(restored, key) {
+			t.Errorf("%v should not be evicted", key)
+		}
+	}
+}
+
+func TestSnapshotLFUFrequencies(t *testing.T) {
+	gc := mustBuild(t, New(2).LFU())
+	gc.Set("a", 1)
+	gc.Set("b", 2)
+	for i := 0; i < 3; i++ {
+		gc.Get("a")
+	}
+	gc.Get("b")
+
+	restored := mustBuild(t, New(2).LFU())
+	if err := restored.Restore(snapshotOf(t, gc)); err != nil {
+		t.Fatal(err)
+	}
+	restored.Get("b")
+	restored.Set("c", 3)
+	if has(restored, "b") {
+		t.Error("b was used less than a, it should be evicted")
+	}
+	if !has(restored, "a") {
+		t.Error("a should not be evicted")
+	}
+}
+
+func TestSnapshotARCLists(t *testing.T) {
+	gc := mustBuild(t, New(4).ARC())
+	for i := 0; i < 6; i++ {
+		gc.Set(i, i)
+	}
+	gc.Get(4)
+	// Hit on a ghost to move split.
+	gc.Set(0, 0)
+
+	restored := mustBuild(t, New(4).ARC())
+	if err := restored.Restore(snapshotOf(t, gc)); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := gc.Debug(), restored.Debug(); !reflect.DeepEqual(want, got) {
+		t.Errorf("ARC lists should be restored as %v, not %v", want, got)
+	}
+}
+
+func TestSnapshotExpiration(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		clock := NewFakeClock()
+		gc := mustBuild(t, New(8).EvictType(tp).Clock(clock))
+		gc.SetWithExpire("short", 1, time.Second)
+		gc.SetWithExpire("long", 2, 3*time.Second)
+		gc.Set("forever", 3)
+		clock.Advance(2 * time.Second)
+		buf := snapshotOf(t, gc)
+
+		clock.Advance(500 * time.Millisecond)
+		restored := mustBuild(t, New(8).EvictType(tp).Clock(clock))
+		if err := restored.Restore(buf); err != nil {
+			t.Fatal(err)
+		}
+		if l := restored.Len(); l != 2 {
+			t.Errorf("%v: Len should be 2, not %v", tp, l)
+		}
+
+		clock.Advance(time.Second)
+		if has(restored, "long") {
+			t.Errorf("%v: long should expire with its remaining TTL", tp)
+		}
+		if !has(restored, "forever") {
+			t.Errorf("%v: forever should not expire", tp)
+		}
+	}
+}
+
+func TestSnapshotSkipsExpiredOnRestore(t *testing.T) {
+	clock := NewFakeClock()
+	gc := mustBuild(t, New(8).LRU().Clock(clock))
+	gc.SetWithExpire("a", 1, time.Second)
+	gc.Set("b", 2)
+	buf := snapshotOf(t, gc)
+
+	clock.Advance(2 * time.Second)
+	restored := mustBuild(t, New(8).LRU().Clock(clock).CleanupInterval(time.Second))
+	defer restored.Close()
+	if err := restored.Restore(buf); err != nil {
+		t.Fatal(err)
+	}
+	if keys := restored.Keys(); len(keys) != 1 || keys[0] != "b" {
+		t.Errorf("only b should be restored, got %v", keys)
+	}
+}
+
+func TestSnapshotAcrossTypes(t *testing.T) {
+	for _, from := range allEvictTypes {
+		for _, to := range allEvictTypes {
+			gc := mustBuild(t, New(8).EvictType(from))
+			testSetCache(t, gc, 8)
+			gc.Get("Key-0")
+
+			restored := mustBuild(t, New(8).EvictType(to))
+			if err := restored.Restore(snapshotOf(t, gc)); err != nil {
+				t.Fatalf("%v to %v: %v", from, to, err)
+			}
+			testGetCache(t, restored, 8)
+		}
+	}
+}
+
+func TestSnapshotIntoSmallerCache(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		gc := mustBuild(t, New(16).EvictType(tp))
+		testSetCache(t, gc, 16)
+
+		restored := mustBuild(t, New(4).EvictType(tp))
+		if err := restored.Restore(snapshotOf(t, gc)); err != nil {
+			t.Fatal(err)
+		}
+		if l := restored.Len(); l != 4 {
+			t.Errorf("%v: Len should be trimmed to 4, not %v", tp, l)
+		}
+	}
+}
+
+func TestSnapshotSharded(t *testing.T) {
+	gc := mustBuild(t, New(64).LRU().Shards(4))
+	testSetCache(t, gc, 16)
+	buf := snapshotOf(t, gc)
+	data := buf.Bytes()
+
+	for _, shards := range []int{0, 3, 4} {
+		restored := mustBuild(t, New(64).LRU().Shards(shards))
+		if err := restored.Restore(bytes.NewReader(data)); err != nil {
+			t.Fatalf("%v shards: %v", shards, err)
+		}
+		if l := restored.Len(); l != 16 {
+			t.Errorf("%v shards: Len should be 16, not %v", shards, l)
+		}
+		testGetCache(t, restored, 16)
+	}
+
+	single := mustBuild(t, New(64).LRU())
+	testSetCache(t, single, 16)
+	restored := mustBuild(t, New(64).LRU().Shards(4))
+	if err := restored.Restore(snapshotOf(t, single)); err != nil {
+		t.Fatal(err)
+	}
+	testGetCache(t, restored, 16)
+}
+
+func TestSnapshotCorrupt(t *testing.T) {
+	gc := mustBuild(t, New(8).LRU())
+	gc.Set("a", 1)
+	if err := gc.Restore(strings.NewReader("not a snapshot")); err == nil {
+		t.Error("Restore should fail on a corrupt snapshot")
+	}
+	if !has(gc, "a") {
+		t.Error("A failed Restore should leave the cache untouched")
+	}
+}
+
+type countingCodec struct {
+	encoders, decoders int
+}
+
+func (c *countingCodec) NewEncoder(w io.Writer) Encoder {
+	c.encoders++
+	return GobCodec.NewEncoder(w)
+}
+
+func (c *countingCodec) NewDecoder(r io.Reader) Decoder {
+	c.decoders++
+	return GobCodec.NewDecoder(r)
+}
+
+func TestSnapshotCodec(t *testing.T) {
+	codec := &countingCodec{}
+	gc := mustBuild(t, New(8).LRU().SnapshotCodec(codec))
+	gc.Set("a", 1)
+	buf := snapshotOf(t, gc)
+	if err := gc.Restore(buf); err != nil {
+		t.Fatal(err)
+	}
+	if codec.encoders != 1 || codec.decoders != 1 {
+		t.Errorf("the codec should be used once each way, got %v encoders and %v decoders", codec.encoders, codec.decoders)
+	}
+}
@@ -0,0 +1,91 @@
+package gcache
+
+import (
+	"fmt"
+	"time"
+)
+
+// StaleError is returned by Get along with the value of an expired entry,
+// when the loader failed to reload it, see CacheBuilder.StaleIfError.
+type StaleError struct {
+	// Err is the error returned by the loader.
+	Err error
+	// Expired is when the entry expired.
+	Expired time.Time
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("gcache2: serving a value expired at %v: %v", e.Expired, e.Err)
+}
+
+func (e *StaleError) Unwrap() error {
+	return e.Err
+}
+
+type staleValue struct {
+	value   interface{}
+	expired time.Time
+}
+
+// staleCache holds the expired entries of a cache for maxStale, guarded by
+// its lock.
+type staleCache struct {
+	maxStale time.Duration
+	values   *heldEntries
+}
+
+func newStaleCache(maxStale time.Duration, capacity int) *staleCache {
+	return &staleCache{maxStale: maxStale, values: newHeldEntries(capacity)}
+}
+
+func (s *staleCache) add(key, value interface{}, e *expiry, now time.Time) {
+	expired := now
+	if e != nil && e.expiration != nil {
+		expired = *e.expiration
+	}
+	grace := expired.Add(s.maxStale)
+	if !now.Before(grace) {
+		return
+	}
+	s.values.add(key, staleValue{value: value, expired: expired}, grace, now)
+}
+
+// forgetHeld drops what is held for key once it is set or removed.
+func (c *baseCache) forgetHeld(key interface{}) {
+	if c.negatives != nil {
+		c.negatives.forget(key)
+	}
+	if c.stale != nil {
+		c.stale.values.forget(key)
+	}
+}
+
+func (c *baseCache) purgeHeld() {
+	if c.negatives != nil {
+		c.negatives.purge()
+	}
+	if c.stale != nil {
+		c.stale.values.purge()
+	}
+}
+
+// staleIfError returns the stale value of key along with a *StaleError, or
+// only err if there is none. err must come from the loader.
+func (c *baseCache) staleIfError(key interface{}, err error, called bool) (interface{}, bool, error) {
+	if c.stale == nil {
+		return nil, called, err
+	}
+	c.mu.Lock()
+	held, ok := c.stale.values.get(key, c.clock.Now())
+	c.mu.Unlock()
+	if !ok {
+		return nil, called, err
+	}
+
+	stale := held.(staleValue)
+	v, derr := c.deserialize(key, stale.value)
+	if derr != nil {
+		return nil, called, err
+	}
+	return v, called, &StaleError{Err: err, Expired: stale.expired}
+}
@@ -0,0 +1,137 @@
+package gcache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// flakyLoader returns key as the value until down is set.
+func flakyLoader(down *bool) LoaderFunc {
+	return func(key interface{}) (interface{}, error) {
+		if *down {
+			return nil, errors.New("backend down")
+		}
+		return key, nil
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		down := false
+		clock := NewFakeClock()
+		gc := mustBuild(t, New(8).EvictType(tp).Clock(clock).
+			Expiration(time.Second).
+			LoaderFunc(flakyLoader(&down)).
+			StaleIfError(time.Minute))
+
+		gc.Get("a")
+		clock.Advance(2 * time.Second)
+		down = true
+
+		v, err := gc.Get("a")
+		var stale *StaleError
+		if !errors.As(err, &stale) || v != "a" {
+			t.Fatalf("%v: the stale value should be returned with a StaleError, got %v, %v", tp, v, err)
+		}
+		if !stale.Expired.Equal(clock.Now().Add(-time.Second)) {
+			t.Errorf("%v: StaleError should tell when the entry expired, got %v", tp, stale.Expired)
+		}
+
+		down = false
+		if v, err := gc.Get("a"); err != nil || v != "a" {
+			t.Errorf("%v: a should be reloaded, got %v, %v", tp, v, err)
+		}
+	}
+}
+
+func TestStaleIfErrorGracePeriod(t *testing.T) {
+	down := false
+	clock := NewFakeClock()
+	gc := mustBuild(t, New(8).LRU().Clock(clock).
+		Expiration(time.Second).
+		LoaderFunc(flakyLoader(&down)).
+		StaleIfError(time.Minute))
+
+	gc.Get("a")
+	clock.Advance(2 * time.Second)
+	down = true
+	if _, err := gc.Get("a"); err == nil {
+		t.Fatal("the loader error should be returned")
+	}
+
+	clock.Advance(time.Minute)
+	v, err := gc.Get("a")
+	var stale *StaleError
+	if errors.As(err, &stale) || v != nil {
+		t.Errorf("the value should not be served past the grace period, got %v, %v", v, err)
+	}
+}
+
+func TestStaleIfErrorRemoved(t *testing.T) {
+	down := false
+	clock := NewFakeClock()
+	gc := mustBuild(t, New(8).LRU().Clock(clock).
+		Expiration(time.Second).
+		LoaderFunc(flakyLoader(&down)).
+		StaleIfError(time.Minute))
+
+	gc.Get("a")
+	gc.Get("b")
+	clock.Advance(2 * time.Second)
+	down = true
+	gc.Get("a")
+	gc.Get("b")
+
+	gc.Remove("a")
+	if v, err := gc.Get("a"); v != nil || err == nil {
+		t.Errorf("a should not be served stale once removed, got %v, %v", v, err)
+	}
+	gc.Purge()
+	if v, err := gc.Get("b"); v != nil || err == nil {
+		t.Errorf("b should not be served stale once purged, got %v, %v", v, err)
+	}
+}
+
+func TestStaleIfErrorNegativeCache(t *testing.T) {
+	down := false
+	calls := 0
+	clock := NewFakeClock()
+	gc := mustBuild(t, New(8).LRU().Clock(clock).
+		Expiration(time.Second).
+		LoaderFunc(func(key interface{}) (interface{}, error) {
+			calls++
+			return flakyLoader(&down)(key)
+		}).
+		StaleIfError(time.Minute).
+		NegativeCache(NegativeCache{TTL: 10 * time.Second}))
+
+	gc.Get("a")
+	clock.Advance(2 * time.Second)
+	down = true
+	for i := 0; i < 3; i++ {
+		if v, err := gc.Get("a"); v != "a" || err == nil {
+			t.Errorf("the stale value should be returned, got %v, %v", v, err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("the failed reload should be cached, the loader ran %v times", calls)
+	}
+}
+
+func TestStaleIfErrorGetIFPresent(t *testing.T) {
+	down := false
+	clock := NewFakeClock()
+	gc := mustBuild(t, New(8).LRU().Clock(clock).
+		Expiration(time.Second).
+		LoaderFunc(flakyLoader(&down)).
+		StaleIfError(time.Minute))
+
+	gc.Set("a", "old")
+	clock.Advance(2 * time.Second)
+	v, err := gc.GetIFPresent("a")
+	var stale *StaleError
+	if errors.As(err, &stale) {
+		t.Errorf("the loader didn't fail, a should not be served stale, got %v, %v", v, err)
+	}
+}
@@ -7,14 +7,28 @@ import (
 type statsAccessor interface {
 	HitCount() uint64
 	MissCount() uint64
+	NegativeHitCount() uint64
+	LoadRetryCount() uint64
+	CircuitRejectCount() uint64
+	CircuitState() CircuitState
+	EarlyRefreshCount() uint64
 	LookupCount() uint64
 	HitRate() float64
+	Weight() int64
 }
 
 // statistics
 type stats struct {
 	hitCount  uint64
 	missCount uint64
+	// negativeHitCount counts the loader errors returned by a NegativeCache.
+	negativeHitCount   uint64
+	loadRetryCount     uint64
+	circuitRejectCount uint64
+	earlyRefreshCount  uint64
+	weight             int64
+	// breaker is only set with a CircuitBreaker.
+	breaker *circuitBreaker
 }
 
 // increment hit count
@@ -27,6 +41,35 @@ func (st *stats) IncrMissCount() uint64 {
 	return atomic.AddUint64(&st.missCount, 1)
 }
 
+// increment negative hit count
+func (st *stats) IncrNegativeHitCount() uint64 {
+	return atomic.AddUint64(&st.negativeHitCount, 1)
+}
+
+// increment load retry count
+func (st *stats) IncrLoadRetryCount() uint64 {
+	return atomic.AddUint64(&st.loadRetryCount, 1)
+}
+
+// increment circuit reject count
+func (st *stats) IncrCircuitRejectCount() uint64 {
+	return atomic.AddUint64(&st.circuitRejectCount, 1)
+}
+
+// increment early refresh count
+func (st *stats) IncrEarlyRefreshCount() uint64 {
+	return atomic.AddUint64(&st.earlyRefreshCount, 1)
+}
+
+// add delta to the weight of the cached entries
+func (st *stats) addWeight(delta int64) int64 {
+	return atomic.AddInt64(&st.weight, delta)
+}
+
+func (st *stats) resetWeight() {
+	atomic.StoreInt64(&st.weight, 0)
+}
+
 // HitCount returns hit count
 func (st *stats) HitCount() uint64 {
 	return atomic.LoadUint64(&st.hitCount)
@@ -37,6 +80,36 @@ func (st *stats) MissCount() uint64 {
 	return atomic.LoadUint64(&st.missCount)
 }
 
+// NegativeHitCount returns the number of cached loader errors returned
+func (st *stats) NegativeHitCount() uint64 {
+	return atomic.LoadUint64(&st.negativeHitCount)
+}
+
+// LoadRetryCount returns the number of times the loader was retried
+func (st *stats) LoadRetryCount() uint64 {
+	return atomic.LoadUint64(&st.loadRetryCount)
+}
+
+// CircuitRejectCount returns the number of loads failed by an open circuit
+// breaker
+func (st *stats) CircuitRejectCount() uint64 {
+	return atomic.LoadUint64(&st.circuitRejectCount)
+}
+
+// CircuitState returns the state of the circuit breaker, always closed
+// without one
+func (st *stats) CircuitState() CircuitState {
+	if st.breaker == nil {
+		return CircuitClosed
+	}
+	return st.breaker.current()
+}
+
+// EarlyRefreshCount returns the number of reloads started by EarlyRefresh
+func (st *stats) EarlyRefreshCount() uint64 {
+	return atomic.LoadUint64(&st.earlyRefreshCount)
+}
+
 // LookupCount returns lookup count
 func (st *stats) LookupCount() uint64 {
 	return st.HitCount() + st.MissCount()
@@ -51,3 +124,9 @@ func (st *stats) HitRate() float64 {
 	}
 	return float64(hc) / float64(total)
 }
+
+// Weight returns the total weight of the cached entries, as computed by the
+// Weigher. Always zero without one.
+func (st *stats) Weight() int64 {
+	return atomic.LoadInt64(&st.weight)
+}
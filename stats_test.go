@@ -156,6 +156,35 @@ func TestCacheStats(t *testing.T) {
 			},
 			rate: 0.5,
 		},
+		{
+			builder: func() Cache {
+				cache, err := New(32).RR().Build()
+				if err != nil {
+					t.Error(err)
+				}
+				cache.Set(0, 0)
+				cache.Get(0)
+				cache.Get(1)
+				return cache
+			},
+			rate: 0.5,
+		},
+		{
+			builder: func() Cache {
+				cache, err := New(32).
+					RR().
+					LoaderFunc(getter).
+					Build()
+				if err != nil {
+					t.Error(err)
+				}
+				cache.Set(0, 0)
+				cache.Get(0)
+				cache.Get(1)
+				return cache
+			},
+			rate: 0.5,
+		},
 	}
 
 	for i, cs := range cases {
@@ -0,0 +1,407 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// TierWritePolicy tells which tiers of a Tiered cache writes go to.
+type TierWritePolicy int
+
+const (
+	// WriteBothTiers writes to L1 and L2.
+	WriteBothTiers TierWritePolicy = iota
+	// WriteL2Only writes to L2 and drops the key from L1, which only holds
+	// the entries promoted by reads.
+	WriteL2Only
+)
+
+// Tiered composes two caches, a small and fast L1 in front of a bigger L2.
+// Reads check L1 then L2, promoting L2 hits to L1, and entries evicted from
+// L1 are demoted to L2. Entries move between the tiers with the time they
+// have left, or follow the expiration policy of L1 when they don't expire.
+// The tiers keep their own stats, and should be built without a loader: the
+// Tiered loader only runs when both tiers miss.
+type Tiered struct {
+	l1, l2           Cache
+	policy           TierWritePolicy
+	loaderExpireFunc loaderExpireContextFunc
+
+	*stats
+	loadGroup Group
+}
+
+type TieredBuilder struct {
+	l1, l2           Cache
+	policy           TierWritePolicy
+	loaderExpireFunc loaderExpireContextFunc
+}
+
+// tier is implemented by the caches that can be the L1 of a Tiered cache.
+type tier interface {
+	setDemote(func(key, value interface{}, ttl *time.Duration))
+}
+
+// lowerTier is implemented by the caches that can be the L2 of a Tiered
+// cache without going through their public methods: promotions keep the
+// time entries have left, and demotions don't write entries through or log
+// them again.
+type lowerTier interface {
+	ttl(key interface{}) (*time.Duration, bool)
+	replaySet(key, value interface{}, expiration, accessTTL *time.Duration)
+}
+
+func NewTiered(l1, l2 Cache) *TieredBuilder {
+	return &TieredBuilder{l1: l1, l2: l2}
+}
+
+func (tb *TieredBuilder) WritePolicy(policy TierWritePolicy) *TieredBuilder {
+	tb.policy = policy
+	return tb
+}
+
+// Set a loader function, called when both tiers miss.
+func (tb *TieredBuilder) LoaderFunc(loaderFunc LoaderFunc) *TieredBuilder {
+	tb.loaderExpireFunc = func(_ context.Context, k interface{}) (interface{}, *time.Duration, error) {
+		v, err := loaderFunc(k)
+		return v, nil, err
+	}
+	return tb
+}
+
+// Set a context-aware loader function, see CacheBuilder.LoaderContextFunc.
+func (tb *TieredBuilder) LoaderContextFunc(loaderFunc LoaderContextFunc) *TieredBuilder {
+	tb.loaderExpireFunc = func(ctx context.Context, k interface{}) (interface{}, *time.Duration, error) {
+		v, err := loaderFunc(ctx, k)
+		return v, nil, err
+	}
+	return tb
+}
+
+// Set a loader function with expiration, the value is written to the tiers
+// with SetWithExpire.
+func (tb *TieredBuilder) LoaderExpireFunc(loaderExpireFunc LoaderExpireFunc) *TieredBuilder {
+	tb.loaderExpireFunc = func(_ context.Context, k interface{}) (interface{}, *time.Duration, error) {
+		return loaderExpireFunc(k)
+	}
+	return tb
+}
+
+func (tb *TieredBuilder) Build() (*Tiered, error) {
+	if tb.l1 == nil || tb.l2 == nil {
+		return nil, errors.New("gcache2: can't Build Tiered, both tiers are required")
+	}
+	if tb.l1 == tb.l2 {
+		return nil, errors.New("gcache2: can't Build Tiered, L1 and L2 are the same cache")
+	}
+	l1, ok := tb.l1.(tier)
+	if !ok {
+		return nil, fmt.Errorf("gcache2: can't Build Tiered, %T can't be L1", tb.l1)
+	}
+
+	t := &Tiered{
+		l1:               tb.l1,
+		l2:               tb.l2,
+		policy:           tb.policy,
+		loaderExpireFunc: tb.loaderExpireFunc,
+		stats:            &stats{},
+	}
+	t.loadGroup.cache = t
+	l1.setDemote(t.demote)
+	return t, nil
+}
+
+func (c *baseCache) setDemote(demote func(key, value interface{}, ttl *time.Duration)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onDemote = demote
+}
+
+func (c *ShardedCache) setDemote(demote func(key, value interface{}, ttl *time.Duration)) {
+	for _, shard := range c.shards {
+		shard.(tier).setDemote(demote)
+	}
+}
+
+// demoted hands an evicted entry to demote, with the time it had left.
+// Called with the cache lock held.
+func (c *baseCache) demoted(key, value interface{}, e *expiry) {
+	var ttl *time.Duration
+	if e != nil && e.expiration != nil {
+		left := e.expiration.Sub(c.clock.Now())
+		if left <= 0 {
+			return
+		}
+		ttl = &left
+	}
+	v, err := c.deserialize(key, value)
+	if err != nil {
+		return
+	}
+	c.onDemote(key, v, ttl)
+}
+
+// ttl returns the time the entry of key has left, nil if it doesn't expire,
+// and false if there is none.
+func (c *baseCache) ttl(key interface{}) (*time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.loadGroup.cache.(entryStore).expiryOf(key)
+	if !ok || e.expiration == nil {
+		return nil, ok
+	}
+	left := e.expiration.Sub(c.clock.Now())
+	if left <= 0 {
+		return nil, false
+	}
+	return &left, true
+}
+
+func (c *ShardedCache) ttl(key interface{}) (*time.Duration, bool) {
+	return c.shard(key).(lowerTier).ttl(key)
+}
+
+func (c *ShardedCache) replaySet(key, value interface{}, expiration, accessTTL *time.Duration) {
+	c.shard(key).(lowerTier).replaySet(key, value, expiration, accessTTL)
+}
+
+// demote is called with the lock of L1 held, L2 never calls back into L1.
+// Entries still in L2 are left as they are there, the others are stored
+// back without being written through again.
+func (t *Tiered) demote(key, value interface{}, ttl *time.Duration) {
+	if l2, ok := t.l2.(lowerTier); ok {
+		if _, ok := l2.ttl(key); !ok {
+			l2.replaySet(key, value, ttl, nil)
+		}
+		return
+	}
+	if ttl != nil {
+		t.l2.SetWithExpire(key, value, *ttl)
+		return
+	}
+	t.l2.Set(key, value)
+}
+
+// L1 returns the first tier, for its stats.
+func (t *Tiered) L1() Cache {
+	return t.l1
+}
+
+// L2 returns the second tier, for its stats.
+func (t *Tiered) L2() Cache {
+	return t.l2
+}
+
+func (t *Tiered) Set(key, value interface{}) error {
+	return t.write(key, func(c Cache) error {
+		return c.Set(key, value)
+	})
+}
+
+func (t *Tiered) SetWithExpire(key, value interface{}, expiration time.Duration) error {
+	return t.write(key, func(c Cache) error {
+		return c.SetWithExpire(key, value, expiration)
+	})
+}
+
+func (t *Tiered) SetWithExpireAfterAccess(key, value interface{}, expiration time.Duration) error {
+	return t.write(key, func(c Cache) error {
+		return c.SetWithExpireAfterAccess(key, value, expiration)
+	})
+}
+
+// write applies set to the tiers of the write policy.
+func (t *Tiered) write(key interface{}, set func(Cache) error) error {
+	if err := set(t.l2); err != nil {
+		return err
+	}
+	if t.policy == WriteL2Only {
+		if err := t.l1.Remove(key); err != KeyNotFoundError {
+			return err
+		}
+		return nil
+	}
+	return set(t.l1)
+}
+
+func (t *Tiered) Get(key interface{}) (interface{}, error) {
+	return t.GetContext(context.Background(), key)
+}
+
+func (t *Tiered) GetContext(ctx context.Context, key interface{}) (interface{}, error) {
+	v, err := t.GetIFPresent(key)
+	if err != KeyNotFoundError || t.loaderExpireFunc == nil {
+		return v, err
+	}
+
+	v, _, err = t.loadGroup.DoContext(ctx, key, func(ctx context.Context) (interface{}, error) {
+		v, expiration, err := t.loaderExpireFunc(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if expiration != nil {
+			err = t.SetWithExpire(key, v, *expiration)
+		} else {
+			err = t.Set(key, v)
+		}
+		return v, err
+	}, true)
+	return v, err
+}
+
+// GetIFPresent promotes L2 hits to L1. It never calls the loader.
+func (t *Tiered) GetIFPresent(key interface{}) (interface{}, error) {
+	v, err := t.l1.GetIFPresent(key)
+	if err == nil {
+		t.IncrHitCount()
+		return v, nil
+	}
+	if err != KeyNotFoundError {
+		return nil, err
+	}
+
+	v, err = t.l2.GetIFPresent(key)
+	if err == KeyNotFoundError {
+		t.IncrMissCount()
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.IncrHitCount()
+	t.promote(key, v)
+	return v, nil
+}
+
+// promote copies an L2 hit to L1 with the time it has left in L2.
+func (t *Tiered) promote(key, value interface{}) {
+	l2, ok := t.l2.(lowerTier)
+	if !ok {
+		t.l1.Set(key, value)
+		return
+	}
+	ttl, ok := l2.ttl(key)
+	if !ok {
+		// Expired since the hit.
+		return
+	}
+	if ttl != nil {
+		t.l1.SetWithExpire(key, value, *ttl)
+		return
+	}
+	t.l1.Set(key, value)
+}
+
+func (t *Tiered) GetMany(keys []interface{}) (map[interface{}]interface{}, map[interface{}]error) {
+	values := make(map[interface{}]interface{}, len(keys))
+	errs := make(map[interface{}]error)
+	for _, key := range keys {
+		v, err := t.Get(key)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		values[key] = v
+	}
+	return values, errs
+}
+
+func (t *Tiered) GetALL() map[interface{}]interface{} {
+	m := t.l2.GetALL()
+	for k, v := range t.l1.GetALL() {
+		m[k] = v
+	}
+	return m
+}
+
+func (t *Tiered) Remove(key interface{}) error {
+	err1 := t.l1.Remove(key)
+	err2 := t.l2.Remove(key)
+	if err1 == KeyNotFoundError && err2 == KeyNotFoundError {
+		return KeyNotFoundError
+	}
+	if err1 == KeyNotFoundError {
+		err1 = nil
+	}
+	if err2 == KeyNotFoundError {
+		err2 = nil
+	}
+	return errors.Join(err1, err2)
+}
+
+func (t *Tiered) Purge() {
+	t.l1.Purge()
+	t.l2.Purge()
+}
+
+func (t *Tiered) Keys() []interface{} {
+	seen := make(map[interface{}]struct{})
+	keys := []interface{}{}
+	for _, c := range []Cache{t.l1, t.l2} {
+		for _, k := range c.Keys() {
+			if _, ok := seen[k]; !ok {
+				seen[k] = struct{}{}
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys
+}
+
+// Len counts the keys present in both tiers once.
+func (t *Tiered) Len() int {
+	return len(t.Keys())
+}
+
+// Resize is not supported, resize the tiers instead.
+func (t *Tiered) Resize(int) error {
+	return errors.New("gcache2: can't Resize Tiered, resize its tiers instead")
+}
+
+// Snapshot only covers L2, the tier expected to hold every entry.
+func (t *Tiered) Snapshot(w io.Writer) error {
+	return t.l2.Snapshot(w)
+}
+
+// Restore restores L2 and empties L1.
+func (t *Tiered) Restore(r io.Reader) error {
+	if err := t.l2.Restore(r); err != nil {
+		return err
+	}
+	t.l1.Purge()
+	return nil
+}
+
+// Close closes both tiers.
+func (t *Tiered) Close() error {
+	err := t.l1.Close()
+	if e := t.l2.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func (t *Tiered) Debug() map[string][]int {
+	d := make(map[string][]int)
+	for name, v := range t.l1.Debug() {
+		d["l1/"+name] = v
+	}
+	for name, v := range t.l2.Debug() {
+		d["l2/"+name] = v
+	}
+	return d
+}
+
+func (t *Tiered) unsafeGet(key interface{}, onLoad bool) (interface{}, error) {
+	if v, err := t.l1.unsafeGet(key, onLoad); err == nil {
+		return v, nil
+	}
+	return t.l2.unsafeGet(key, onLoad)
+}
+
+// Weight sums the weight of the tiers.
+func (t *Tiered) Weight() int64 {
+	return t.l1.Weight() + t.l2.Weight()
+}
@@ -0,0 +1,236 @@
+package gcache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func buildTiered(t *testing.T, l1, l2 *CacheBuilder, policy TierWritePolicy) *Tiered {
+	tc, err := NewTiered(mustBuild(t, l1), mustBuild(t, l2)).WritePolicy(policy).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tc
+}
+
+func TestTieredReadThrough(t *testing.T) {
+	tc := buildTiered(t, New(2).LRU(), New(16).LFU(), WriteBothTiers)
+	for i := 0; i < 5; i++ {
+		tc.Set(i, i)
+	}
+	if _, err := tc.L1().GetIFPresent(0); err != KeyNotFoundError {
+		t.Fatal("0 should have been evicted from L1")
+	}
+
+	if v, err := tc.Get(0); err != nil || v != 0 {
+		t.Fatalf("0 should be read from L2, got %v, %v", v, err)
+	}
+	if v, err := tc.L1().GetIFPresent(0); err != nil || v != 0 {
+		t.Errorf("0 should be promoted to L1, got %v, %v", v, err)
+	}
+	if _, err := tc.Get(42); err != KeyNotFoundError {
+		t.Errorf("err should be KeyNotFoundError, not %v", err)
+	}
+	if h, m := tc.HitCount(), tc.MissCount(); h != 1 || m != 1 {
+		t.Errorf("Tiered should count 1 hit and 1 miss, got %v and %v", h, m)
+	}
+	if l := tc.Len(); l != 5 {
+		t.Errorf("Len should count each key once, got %v", l)
+	}
+}
+
+func TestTieredDemotion(t *testing.T) {
+	clock := NewFakeClock()
+	tc := buildTiered(t, New(2).LRU().Clock(clock), New(16).LRU().Clock(clock), WriteBothTiers)
+	tc.Set("a", 1)
+	tc.SetWithExpire("b", 2, time.Minute)
+	tc.L2().Remove("a")
+	tc.L2().Remove("b")
+
+	tc.Set("c", 3)
+	tc.Set("d", 4)
+	for key, expected := range map[string]interface{}{"a": 1, "b": 2} {
+		if v, err := tc.L2().GetIFPresent(key); err != nil || v != expected {
+			t.Errorf("%v should be demoted to L2, got %v, %v", key, v, err)
+		}
+	}
+
+	clock.Advance(2 * time.Minute)
+	if _, err := tc.L2().GetIFPresent("b"); err != KeyNotFoundError {
+		t.Error("b should keep its expiration once demoted")
+	}
+}
+
+func TestTieredPromotionKeepsTTL(t *testing.T) {
+	for _, l2 := range []*CacheBuilder{New(16).LRU(), New(16).ARC().Shards(2)} {
+		clock := NewFakeClock()
+		tc := buildTiered(t, New(2).LRU().Clock(clock), l2.Clock(clock), WriteL2Only)
+		tc.SetWithExpire("a", 1, time.Minute)
+		tc.Set("b", 2)
+
+		clock.Advance(30 * time.Second)
+		tc.Get("a")
+		tc.Get("b")
+		if _, err := tc.L1().GetIFPresent("a"); err != nil {
+			t.Fatalf("a should be promoted to L1, got %v", err)
+		}
+
+		clock.Advance(31 * time.Second)
+		if _, err := tc.L1().GetIFPresent("a"); err != KeyNotFoundError {
+			t.Error("a should expire from L1 with the time it had left in L2")
+		}
+		if v, err := tc.L1().GetIFPresent("b"); err != nil || v != 2 {
+			t.Errorf("b should not expire, got %v, %v", v, err)
+		}
+	}
+}
+
+func TestTieredDemotionSharded(t *testing.T) {
+	tc := buildTiered(t, New(4).LRU().Shards(2), New(64).LRU(), WriteL2Only)
+	for i := 0; i < 16; i++ {
+		tc.L1().Set(i, i)
+	}
+	if l := tc.L2().Len(); l == 0 {
+		t.Error("the shards of L1 should demote their evictions")
+	}
+}
+
+func TestTieredWriteL2Only(t *testing.T) {
+	tc := buildTiered(t, New(4).LRU(), New(16).LRU(), WriteL2Only)
+	tc.Set("a", 1)
+	if tc.L1().Len() != 0 {
+		t.Error("writes should only go to L2")
+	}
+
+	tc.Get("a")
+	if v, err := tc.L1().GetIFPresent("a"); err != nil || v != 1 {
+		t.Errorf("a should be promoted to L1, got %v, %v", v, err)
+	}
+
+	tc.Set("a", 2)
+	if v, err := tc.Get("a"); err != nil || v != 2 {
+		t.Errorf("a write should not leave a stale value in L1, got %v, %v", v, err)
+	}
+}
+
+func TestTieredLoader(t *testing.T) {
+	var calls int32
+	l1 := mustBuild(t, New(4).LRU())
+	l2 := mustBuild(t, New(16).LRU())
+	tc, err := NewTiered(l1, l2).
+		LoaderFunc(func(key interface{}) (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			time.Sleep(10 * time.Millisecond)
+			return key, nil
+		}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l2.Set("cached", "cached")
+	if v, err := tc.Get("cached"); err != nil || v != "cached" {
+		t.Errorf("cached should be read from L2, got %v, %v", v, err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Errorf("the loader should not run on an L2 hit, ran %v times", n)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := tc.Get("missing"); err != nil || v != "missing" {
+				t.Errorf("missing should be loaded, got %v, %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("the loader should run once, ran %v times", n)
+	}
+	for _, c := range []Cache{l1, l2} {
+		if _, err := c.GetIFPresent("missing"); err != nil {
+			t.Errorf("loaded values should be written to both tiers, got %v", err)
+		}
+	}
+}
+
+func TestTieredRemoveAndPurge(t *testing.T) {
+	tc := buildTiered(t, New(4).LRU(), New(16).LRU(), WriteBothTiers)
+	tc.Set("a", 1)
+	tc.Set("b", 2)
+	if err := tc.Remove("a"); err != nil {
+		t.Error(err)
+	}
+	if has(tc.L1(), "a") || has(tc.L2(), "a") {
+		t.Error("a should be removed from both tiers")
+	}
+	if err := tc.Remove("a"); err != KeyNotFoundError {
+		t.Errorf("err should be KeyNotFoundError, not %v", err)
+	}
+
+	tc.Purge()
+	if l := tc.Len(); l != 0 {
+		t.Errorf("Purge should empty both tiers, got %v entries", l)
+	}
+}
+
+func TestTieredRemoveError(t *testing.T) {
+	w := newStoreWriter()
+	tc := buildTiered(t, New(4).LRU().WriteThrough(w), New(16).LRU(), WriteBothTiers)
+	tc.Set("a", 1)
+
+	w.setFail(1)
+	if err := tc.Remove("a"); !errors.Is(err, errStore) {
+		t.Errorf("the error of L1 should be returned, got %v", err)
+	}
+}
+
+func TestTieredDemotionAlreadyInL2(t *testing.T) {
+	w := newStoreWriter()
+	clock := NewFakeClock()
+	tc := buildTiered(t, New(1).LRU().Clock(clock), New(16).LRU().Clock(clock).WriteThrough(w), WriteBothTiers)
+	tc.SetWithExpire("a", 1, time.Minute)
+	delete(w.store, "a")
+
+	clock.Advance(30 * time.Second)
+	tc.Set("b", 2)
+	if _, ok := w.store["a"]; ok {
+		t.Error("a demoted entry already in L2 should not be written through again")
+	}
+	clock.Advance(31 * time.Second)
+	if has(tc.L2(), "a") {
+		t.Error("a should keep its expiration in L2")
+	}
+
+	tc.L2().Remove("b")
+	tc.Set("c", 3)
+	if v, err := tc.L2().GetIFPresent("b"); err != nil || v != 2 {
+		t.Errorf("b should be demoted to L2, got %v, %v", v, err)
+	}
+	if _, ok := w.store["b"]; ok {
+		t.Error("demotions should not be written through")
+	}
+}
+
+func TestTieredBuildErrors(t *testing.T) {
+	c := mustBuild(t, New(4).LRU())
+	if _, err := NewTiered(c, c).Build(); err == nil {
+		t.Error("Build should fail with the same cache as both tiers")
+	}
+	if _, err := NewTiered(c, nil).Build(); err == nil {
+		t.Error("Build should fail without L2")
+	}
+	tc := buildTiered(t, New(4).LRU(), New(16).LRU(), WriteBothTiers)
+	if _, err := NewTiered(tc, c).Build(); err == nil {
+		t.Error("Build should fail with a Tiered cache as L1")
+	}
+	if _, err := NewTiered(c, tc).Build(); err != nil {
+		t.Errorf("a Tiered cache can be L2, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package gcache
+
+import (
+	"container/list"
+	"time"
+)
+
+const (
+	wheelLevels = 4
+	wheelBits   = 6
+	wheelSlots  = 1 << wheelBits
+	wheelMask   = wheelSlots - 1
+)
+
+// timerWheel is a hierarchical timer wheel tracking when keys expire.
+//
+// Level 0 has one slot per tick, and every slot of level i spans 64 slots of
+// level i-1. A timer is placed on the lowest level able to hold it and moves
+// down a level each time its slot comes up, until it eventually fires from
+// level 0. Scheduling, rescheduling and cancelling are all O(1).
+//
+// timerWheel is not safe for concurrent use.
+type timerWheel struct {
+	tick    int64
+	current int64
+	buckets [wheelLevels][wheelSlots]*list.List
+	timers  map[interface{}]*timerNode
+}
+
+type timerNode struct {
+	key interface{}
+	// target is the first tick at which the deadline has passed.
+	target  int64
+	bucket  *list.List
+	element *list.Element
+}
+
+func newTimerWheel(tick time.Duration, now time.Time) *timerWheel {
+	if tick <= 0 {
+		tick = time.Second
+	}
+	w := &timerWheel{
+		tick:   int64(tick),
+		timers: make(map[interface{}]*timerNode),
+	}
+	for i := range w.buckets {
+		for j := range w.buckets[i] {
+			w.buckets[i][j] = list.New()
+		}
+	}
+	w.current = now.UnixNano() / w.tick
+	return w
+}
+
+// schedule (re)arms the timer of key to fire once deadline has passed.
+func (w *timerWheel) schedule(key interface{}, deadline time.Time) {
+	node, ok := w.timers[key]
+	if ok {
+		node.bucket.Remove(node.element)
+	} else {
+		node = &timerNode{key: key}
+		w.timers[key] = node
+	}
+	node.target = deadline.UnixNano()/w.tick + 1
+	w.place(node)
+}
+
+// cancel disarms the timer of key, if any.
+func (w *timerWheel) cancel(key interface{}) {
+	if node, ok := w.timers[key]; ok {
+		node.bucket.Remove(node.element)
+		delete(w.timers, key)
+	}
+}
+
+func (w *timerWheel) place(node *timerNode) {
+	level := 0
+	diff := node.target - w.current
+	for level < wheelLevels-1 && diff >= int64(1)<<(wheelBits*uint(level+1)) {
+		level++
+	}
+	node.bucket = w.buckets[level][(node.target>>(wheelBits*uint(level)))&wheelMask]
+	node.element = node.bucket.PushBack(node)
+}
+
+// advance moves the wheel to now and returns the keys whose timers fired.
+func (w *timerWheel) advance(now time.Time) []interface{} {
+	previous, current := w.current, now.UnixNano()/w.tick
+	if current <= previous {
+		return nil
+	}
+	w.current = current
+
+	var expired []interface{}
+	for level := 0; level < wheelLevels; level++ {
+		shift := wheelBits * uint(level)
+		from, to := previous>>shift, current>>shift
+		if from == to {
+			break
+		}
+		if to-from > wheelSlots {
+			from = to - wheelSlots
+		}
+		for t := from + 1; t <= to; t++ {
+			slot := t & wheelMask
+			bucket := w.buckets[level][slot]
+			w.buckets[level][slot] = list.New()
+			for e := bucket.Front(); e != nil; e = e.Next() {
+				node := e.Value.(*timerNode)
+				if node.target <= current {
+					delete(w.timers, node.key)
+					expired = append(expired, node.key)
+				} else {
+					w.place(node)
+				}
+			}
+		}
+	}
+	return expired
+}
+
+// reset cancels every timer.
+func (w *timerWheel) reset() {
+	for key, node := range w.timers {
+		node.bucket.Remove(node.element)
+		delete(w.timers, key)
+	}
+}
+
+func (w *timerWheel) len() int {
+	return len(w.timers)
+}
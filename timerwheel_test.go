@@ -0,0 +1,68 @@
+package gcache
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestTimerWheelFiresAfterDeadline(t *testing.T) {
+	start := time.Date(1984, time.April, 4, 0, 0, 0, 0, time.UTC)
+	w := newTimerWheel(time.Second, start)
+
+	rnd := rand.New(rand.NewSource(1))
+	deadlines := make(map[interface{}]time.Time)
+	for i := 0; i < 2000; i++ {
+		// Spread deadlines over every level of the wheel.
+		d := time.Duration(rnd.Int63n(int64(400 * time.Hour)))
+		deadlines[i] = start.Add(d)
+		w.schedule(i, start.Add(d))
+	}
+
+	now := start
+	fired := make(map[interface{}]bool)
+	for len(fired) < len(deadlines) {
+		now = now.Add(time.Duration(rnd.Int63n(int64(2 * time.Hour))))
+		for _, key := range w.advance(now) {
+			if fired[key] {
+				t.Fatalf("key %v fired twice", key)
+			}
+			if !deadlines[key].Before(now) {
+				t.Fatalf("key %v fired at %v, before its deadline %v", key, now, deadlines[key])
+			}
+			fired[key] = true
+		}
+		for key, deadline := range deadlines {
+			if !fired[key] && deadline.Add(time.Second).Before(now) {
+				t.Fatalf("key %v did not fire, deadline %v, now %v", key, deadline, now)
+			}
+		}
+	}
+	if w.len() != 0 {
+		t.Errorf("%v != %v", w.len(), 0)
+	}
+}
+
+func TestTimerWheelRescheduleAndCancel(t *testing.T) {
+	start := time.Date(1984, time.April, 4, 0, 0, 0, 0, time.UTC)
+	w := newTimerWheel(time.Second, start)
+
+	w.schedule("a", start.Add(time.Second))
+	w.schedule("b", start.Add(time.Second))
+	w.schedule("a", start.Add(time.Hour))
+	w.cancel("b")
+
+	if expired := w.advance(start.Add(time.Minute)); len(expired) != 0 {
+		t.Errorf("unexpected expired keys %v", expired)
+	}
+	expired := w.advance(start.Add(2 * time.Hour))
+	if len(expired) != 1 || expired[0] != "a" {
+		t.Errorf("unexpected expired keys %v", expired)
+	}
+
+	w.schedule("c", start.Add(3*time.Hour))
+	w.reset()
+	if expired := w.advance(start.Add(24 * time.Hour)); len(expired) != 0 {
+		t.Errorf("unexpected expired keys %v", expired)
+	}
+}
@@ -0,0 +1,589 @@
+package gcache
+
+import (
+	"container/list"
+	"context"
+	"io"
+	"time"
+)
+
+const (
+	tinyLFUWindow    = 0
+	tinyLFUProbation = 1
+	tinyLFUProtected = 2
+)
+
+// TinyLFU implements W-TinyLFU: new entries land in a small LRU admission
+// window; entries leaving the window compete with the victim of a segmented
+// LRU main space, and are admitted only if the frequency sketch says they are
+// more popular. This keeps scans from flushing out the frequently used set.
+type TinyLFU struct {
+	baseCache
+	store map[interface{}]*list.Element
+
+	window    *list.List
+	probation *list.List
+	protected *list.List
+
+	windowCap    int
+	protectedCap int
+
+	sketch *cmSketch
+}
+
+type tinyLFUItem struct {
+	expiry
+	key     interface{}
+	value   interface{}
+	weight  int64
+	hash    uint64
+	segment int
+}
+
+func newTinyLFU(cb *CacheBuilder) *TinyLFU {
+	c := &TinyLFU{}
+	buildCache(&c.baseCache, cb)
+	if cb.bufferedReads {
+		c.reads = newReadBuffer(c.applyRead)
+	}
+
+	c.init()
+	c.loadGroup.cache = c
+	c.startJanitor(c, cb.cleanupInterval)
+	c.startMemoryController(c, cb.memoryPressure)
+	c.startCheckpointer(c, cb.persistence)
+	return c
+}
+
+func (c *TinyLFU) init() {
+	c.store = make(map[interface{}]*list.Element, c.capacity+1)
+	c.window = list.New()
+	c.probation = list.New()
+	c.protected = list.New()
+
+	c.setSegments()
+	c.sketch = newCMSketch(c.capacity)
+}
+
+func (c *TinyLFU) setSegments() {
+	// 1% admission window, the main space is split 20/80 between its
+	// probation and protected segments.
+	c.windowCap = maxInt(c.capacity/100, 1)
+	c.protectedCap = (c.capacity - c.windowCap) * 8 / 10
+}
+
+// resize keeps the sketch when shrinking. It is replaced, losing the
+// frequencies it recorded, when the capacity outgrows it.
+func (c *TinyLFU) resize(capacity int) {
+	c.capacity = capacity
+	c.setSegments()
+	if capacity > int(c.sketch.mask)+1 {
+		c.sketch = newCMSketch(capacity)
+	}
+	for len(c.store) > capacity && c.evictOne(RemovalResized) {
+	}
+	c.demoteProtected()
+	c.admit(RemovalResized)
+}
+
+func (c *TinyLFU) set(key, value interface{}) (interface{}, error) {
+	value, w, err := c.prepare(key, value)
+	if err != nil {
+		return nil, err
+	}
+	return c.insert(key, value, w), nil
+}
+
+// insert stores value, prepared with its weight w.
+func (c *TinyLFU) insert(key, value interface{}, w int64) interface{} {
+	c.drainReads()
+	c.forgetHeld(key)
+	c.makeRoom(c, key, w)
+
+	var item *tinyLFUItem
+	if e, ok := c.store[key]; ok {
+		item = e.Value.(*tinyLFUItem)
+		c.removed(key, item.value, nil, RemovalReplaced)
+		item.value = value
+		c.access(e)
+	} else {
+		item = &tinyLFUItem{
+			expiry:  expiry{clock: c.clock},
+			key:     key,
+			value:   value,
+			hash:    hashKey(key),
+			segment: tinyLFUWindow,
+		}
+		c.sketch.increment(item.hash)
+		c.store[key] = c.window.PushFront(item)
+		c.admit(RemovalSize)
+	}
+
+	c.addWeight(w - item.weight)
+	item.weight = w
+	c.expireAfterWrite(key, &item.expiry)
+
+	if c.addedFunc != nil {
+		c.addedFunc(key, value)
+	}
+
+	return item
+}
+
+// admit moves entries overflowing the admission window into the main space,
+// evicting whichever of the candidate and the main victim is less popular.
+func (c *TinyLFU) admit(cause RemovalCause) {
+	for c.window.Len() > c.windowCap {
+		candidate := c.window.Back()
+		c.window.Remove(candidate)
+		item := candidate.Value.(*tinyLFUItem)
+
+		if c.probation.Len()+c.protected.Len() < c.capacity-c.windowCap {
+			item.segment = tinyLFUProbation
+			c.store[item.key] = c.probation.PushFront(item)
+			continue
+		}
+
+		victim := c.probation.Back()
+		if victim == nil {
+			victim = c.protected.Back()
+		}
+		if victim == nil {
+			c.evictItem(item, cause)
+			continue
+		}
+
+		if c.sketch.estimate(item.hash) > c.sketch.estimate(victim.Value.(*tinyLFUItem).hash) {
+			c.removeElement(victim, cause)
+			item.segment = tinyLFUProbation
+			c.store[item.key] = c.probation.PushFront(item)
+		} else {
+			c.evictItem(item, cause)
+		}
+	}
+}
+
+// access records a hit on e and updates its position in the segments.
+func (c *TinyLFU) access(e *list.Element) {
+	item := e.Value.(*tinyLFUItem)
+	c.sketch.increment(item.hash)
+
+	switch item.segment {
+	case tinyLFUWindow:
+		c.window.MoveToFront(e)
+	case tinyLFUProtected:
+		c.protected.MoveToFront(e)
+	case tinyLFUProbation:
+		c.probation.Remove(e)
+		item.segment = tinyLFUProtected
+		c.store[item.key] = c.protected.PushFront(item)
+		c.demoteProtected()
+	}
+}
+
+// demoteProtected moves the entries overflowing the protected segment back
+// to probation.
+func (c *TinyLFU) demoteProtected() {
+	for c.protected.Len() > c.protectedCap {
+		demoted := c.protected.Back()
+		c.protected.Remove(demoted)
+		it := demoted.Value.(*tinyLFUItem)
+		it.segment = tinyLFUProbation
+		c.store[it.key] = c.probation.PushFront(it)
+	}
+}
+
+func (c *TinyLFU) Set(key, value interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, w, err := c.prepare(key, value)
+	if err != nil {
+		return err
+	}
+	if err := c.beforeSet(key, value); err != nil {
+		return err
+	}
+	c.insert(key, stored, w)
+	return nil
+}
+
+func (c *TinyLFU) SetWithExpire(key, value interface{}, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, w, err := c.prepare(key, value)
+	if err != nil {
+		return err
+	}
+	if err := c.beforeSetWithExpire(key, value, expiration); err != nil {
+		return err
+	}
+	item := c.insert(key, stored, w)
+
+	c.expireAt(key, &item.(*tinyLFUItem).expiry, expiration)
+	return nil
+}
+
+func (c *TinyLFU) SetWithExpireAfterAccess(key, value interface{}, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, w, err := c.prepare(key, value)
+	if err != nil {
+		return err
+	}
+	if err := c.beforeSetWithExpireAfterAccess(key, value, expiration); err != nil {
+		return err
+	}
+	item := c.insert(key, stored, w)
+
+	c.expireAfterAccess(key, &item.(*tinyLFUItem).expiry, expiration)
+	return nil
+}
+
+func (c *TinyLFU) get(key interface{}, onLoad bool) (interface{}, error) {
+	e, exists := c.store[key]
+	if !exists {
+		if !onLoad {
+			c.sketch.increment(hashKey(key))
+			c.stats.IncrMissCount()
+		}
+		return nil, KeyNotFoundError
+	}
+
+	item := e.Value.(*tinyLFUItem)
+	if item.isExpired(nil) {
+		c.removeElement(e, RemovalExpired)
+		if !onLoad {
+			c.stats.IncrMissCount()
+		}
+		return nil, KeyNotFoundError
+	}
+
+	if !onLoad {
+		c.access(e)
+		c.stats.IncrHitCount()
+		c.accessed(key, &item.expiry)
+		c.refreshIfStale(key, &item.expiry)
+	}
+
+	if c.deserializeFunc != nil {
+		return c.deserializeFunc(key, item.value)
+	}
+
+	return item.value, nil
+}
+
+func (c *TinyLFU) storeEntry(key, value interface{}, expiration, accessTTL *time.Duration) error {
+	item, err := c.set(key, value)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case expiration != nil:
+		c.expireAt(key, &item.(*tinyLFUItem).expiry, *expiration)
+	case accessTTL != nil:
+		c.expireAfterAccess(key, &item.(*tinyLFUItem).expiry, *accessTTL)
+	}
+	return nil
+}
+
+func (c *TinyLFU) removeEntry(key interface{}) {
+	c.remove(key)
+}
+
+func (c *TinyLFU) expiryOf(key interface{}) (*expiry, bool) {
+	if e, ok := c.store[key]; ok {
+		return &e.Value.(*tinyLFUItem).expiry, true
+	}
+	return nil, false
+}
+
+func (c *TinyLFU) getWithLoader(ctx context.Context, key interface{}, isWait bool) (interface{}, error) {
+	if c.loaderExpireFunc == nil {
+		return nil, KeyNotFoundError
+	}
+
+	value, _, err := c.load(ctx, key, func(v interface{}, expiration *time.Duration, e error) (interface{}, error) {
+		if e != nil {
+			return nil, e
+		}
+
+		if err := c.setLoaded(key, v, expiration); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}, isWait)
+	return value, err
+}
+
+func (c *TinyLFU) Get(key interface{}) (interface{}, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+func (c *TinyLFU) GetContext(ctx context.Context, key interface{}) (interface{}, error) {
+	if v, ok := c.getShared(key); ok {
+		return c.deserialize(key, v)
+	}
+	c.mu.Lock()
+	v, err := c.get(key, false)
+	c.mu.Unlock()
+
+	if err == KeyNotFoundError {
+		return c.getWithLoader(ctx, key, true)
+	}
+	return v, err
+}
+
+func (c *TinyLFU) GetIFPresent(key interface{}) (interface{}, error) {
+	if v, ok := c.getShared(key); ok {
+		return c.deserialize(key, v)
+	}
+	c.mu.Lock()
+	v, err := c.get(key, false)
+	c.mu.Unlock()
+
+	if err == KeyNotFoundError {
+		return c.getWithLoader(context.Background(), key, false)
+	}
+	return v, err
+}
+
+func (c *TinyLFU) GetALL() map[interface{}]interface{} {
+	c.mu.Lock()
+	allKeys := c.keys()
+	c.mu.Unlock()
+
+	m := make(map[interface{}]interface{})
+	for _, k := range allKeys {
+		v, err := c.GetIFPresent(k)
+		if err == nil {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+func (c *TinyLFU) remove(key interface{}) error {
+	if e, ok := c.store[key]; ok {
+		c.removeElement(e, RemovalExplicit)
+		return nil
+	}
+	return KeyNotFoundError
+}
+
+func (c *TinyLFU) expire(key interface{}, now time.Time) {
+	if e, ok := c.store[key]; ok && e.Value.(*tinyLFUItem).isExpired(&now) {
+		c.removeElement(e, RemovalExpired)
+	}
+}
+
+func (c *TinyLFU) Remove(key interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.beforeRemove(key); err != nil {
+		return err
+	}
+	c.forgetHeld(key)
+	return c.remove(key)
+}
+
+func (c *TinyLFU) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logPurge()
+	c.purgeHeld()
+	c.purge()
+}
+
+func (c *TinyLFU) purge() {
+	if c.purgeVisitorFunc != nil || c.listensRemovals() {
+		for key, e := range c.store {
+			c.purged(key, e.Value.(*tinyLFUItem).value)
+		}
+	}
+
+	c.resetExpirations()
+	c.resetWeight()
+	c.init()
+}
+
+func (c *TinyLFU) keys() []interface{} {
+	keys := make([]interface{}, len(c.store))
+	var i = 0
+	for k := range c.store {
+		keys[i] = k
+		i++
+	}
+	return keys
+}
+
+func (c *TinyLFU) Keys() []interface{} {
+	c.mu.Lock()
+	allKeys := c.keys()
+	c.mu.Unlock()
+
+	keys := []interface{}{}
+	for _, k := range allKeys {
+		_, err := c.GetIFPresent(k)
+		if err == nil {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func (c *TinyLFU) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.store)
+}
+
+func (c *TinyLFU) Resize(capacity int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.setCapacity(c, capacity)
+}
+
+func (c *TinyLFU) segment(item *tinyLFUItem) *list.List {
+	switch item.segment {
+	case tinyLFUProbation:
+		return c.probation
+	case tinyLFUProtected:
+		return c.protected
+	default:
+		return c.window
+	}
+}
+
+func (c *TinyLFU) weightOf(key interface{}) int64 {
+	if e, ok := c.store[key]; ok {
+		return e.Value.(*tinyLFUItem).weight
+	}
+	return 0
+}
+
+// evictOne makes room for heavy entries without going through admission,
+// taking the main space victim first.
+func (c *TinyLFU) evictOne(cause RemovalCause) bool {
+	for _, l := range []*list.List{c.probation, c.protected, c.window} {
+		if e := l.Back(); e != nil {
+			c.removeElement(e, cause)
+			return true
+		}
+	}
+	return false
+}
+
+func (c *TinyLFU) removeElement(e *list.Element, cause RemovalCause) {
+	item := e.Value.(*tinyLFUItem)
+	c.segment(item).Remove(e)
+	c.evictItem(item, cause)
+}
+
+// evictItem drops an item that is no longer linked in any segment.
+func (c *TinyLFU) evictItem(item *tinyLFUItem, cause RemovalCause) {
+	delete(c.store, item.key)
+	c.addWeight(-item.weight)
+	c.removed(item.key, item.value, &item.expiry, cause)
+}
+
+func (c *TinyLFU) Debug() map[string][]int {
+	d := make(map[string][]int)
+	d["tinylfu"] = []int{len(c.store), c.window.Len(), c.probation.Len(), c.protected.Len()}
+	return d
+}
+
+// getShared serves a hit under the shared lock when BufferedReads is set.
+func (c *TinyLFU) getShared(key interface{}) (interface{}, bool) {
+	if c.reads == nil {
+		return nil, false
+	}
+	c.mu.RLock()
+	e, ok := c.store[key]
+	if !ok {
+		c.mu.RUnlock()
+		return nil, false
+	}
+	item := e.Value.(*tinyLFUItem)
+	if !c.sharedRead(&item.expiry) {
+		c.mu.RUnlock()
+		return nil, false
+	}
+	v := item.value
+	c.mu.RUnlock()
+
+	c.stats.IncrHitCount()
+	c.recordRead(item)
+	return v, true
+}
+
+// applyRead replays a read buffered by getShared.
+func (c *TinyLFU) applyRead(v interface{}) {
+	item := v.(*tinyLFUItem)
+	if e, ok := c.store[item.key]; ok && e.Value == item {
+		c.access(e)
+	}
+}
+
+// Snapshot records the sketch estimate of the entries, not the whole sketch.
+func (c *TinyLFU) Snapshot(w io.Writer) error {
+	return c.snapshot(c, TYPE_TINYLFU, w)
+}
+
+func (c *TinyLFU) Restore(r io.Reader) error {
+	return c.restore(c, TYPE_TINYLFU, r)
+}
+
+func (c *TinyLFU) exportEntries(at time.Time) ([]SnapshotEntry, int) {
+	entries := make([]SnapshotEntry, 0, len(c.store))
+	for _, l := range []*list.List{c.window, c.probation, c.protected} {
+		for e := l.Back(); e != nil; e = e.Prev() {
+			item := e.Value.(*tinyLFUItem)
+			entry := SnapshotEntry{
+				Key:     item.key,
+				Value:   item.value,
+				Freq:    uint(c.sketch.estimate(item.hash)),
+				Segment: item.segment,
+			}
+			item.export(at, &entry)
+			entries = append(entries, entry)
+		}
+	}
+	return entries, 0
+}
+
+func (c *TinyLFU) importEntries(at time.Time, entries []SnapshotEntry, _ int) {
+	for i := range entries {
+		e := &entries[i]
+		w, ok := c.importWeight(e)
+		if !ok {
+			continue
+		}
+		item := &tinyLFUItem{
+			expiry:  expiry{clock: c.clock},
+			key:     e.Key,
+			value:   e.Value,
+			weight:  w,
+			hash:    hashKey(e.Key),
+			segment: e.Segment,
+		}
+		item.restore(at, e)
+		for j := uint(0); j < e.Freq; j++ {
+			c.sketch.increment(item.hash)
+		}
+		c.store[e.Key] = c.segment(item).PushFront(item)
+	}
+}
+
+func (c *TinyLFU) unsafeGet(key interface{}, onLoad bool) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.get(key, onLoad)
+}
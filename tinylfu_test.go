@@ -0,0 +1,143 @@
+package gcache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func evictedFuncForTinyLFU(key, value interface{}) {
+	fmt.Printf("[TinyLFU] Key:%v Value:%v will be evicted.\n", key, value)
+}
+
+func buildTinyLFUCache(size int) (Cache, error) {
+	return New(size).
+		TinyLFU().
+		EvictedFunc(evictedFuncForTinyLFU).
+		Expiration(time.Second).
+		Build()
+}
+
+func buildLoadingTinyLFUCache(size int, loader LoaderFunc) (Cache, error) {
+	return New(size).
+		TinyLFU().
+		LoaderFunc(loader).
+		EvictedFunc(evictedFuncForTinyLFU).
+		Expiration(time.Second).
+		Build()
+}
+
+func TestTinyLFUGet(t *testing.T) {
+	size := 1000
+	gc, err := buildTinyLFUCache(size)
+	if err != nil {
+		t.Error(err)
+	}
+
+	testSetCache(t, gc, size)
+	testGetCache(t, gc, size)
+}
+
+func TestLoadingTinyLFUGet(t *testing.T) {
+	size := 1000
+	gc, err := buildLoadingTinyLFUCache(size, loader)
+	if err != nil {
+		t.Error(err)
+	}
+
+	testGetCache(t, gc, size)
+}
+
+func TestTinyLFULength(t *testing.T) {
+	gc, err := buildLoadingTinyLFUCache(1000, loader)
+	if err != nil {
+		t.Error(err)
+	}
+
+	gc.Get("test1")
+	gc.Get("test2")
+	length := gc.Len()
+	expectedLength := 2
+	if length != expectedLength {
+		t.Errorf("Expected length is %v, not %v", length, expectedLength)
+	}
+}
+
+func TestTinyLFUEvictItem(t *testing.T) {
+	cacheSize := 10
+	numbers := 100
+	gc, err := buildLoadingTinyLFUCache(cacheSize, loader)
+	if err != nil {
+		t.Error(err)
+	}
+
+	for i := 0; i < numbers; i++ {
+		_, err := gc.Get(fmt.Sprintf("Key-%d", i))
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if gc.Len() > cacheSize {
+			t.Fatalf("cache holds %v entries, capacity is %v", gc.Len(), cacheSize)
+		}
+	}
+}
+
+func TestTinyLFUScanResistance(t *testing.T) {
+	size := 100
+	gc, err := New(size).TinyLFU().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hot := 50
+	for round := 0; round < 5; round++ {
+		for i := 0; i < hot; i++ {
+			key := fmt.Sprintf("hot-%d", i)
+			if _, err := gc.Get(key); err != nil {
+				gc.Set(key, i)
+			}
+		}
+	}
+
+	// A long scan of one-hit keys must not flush the hot set.
+	for i := 0; i < 10*size; i++ {
+		gc.Set(fmt.Sprintf("scan-%d", i), i)
+	}
+
+	kept := 0
+	for i := 0; i < hot; i++ {
+		if _, err := gc.GetIFPresent(fmt.Sprintf("hot-%d", i)); err == nil {
+			kept++
+		}
+	}
+	if kept < hot*9/10 {
+		t.Errorf("only %v/%v hot keys survived the scan", kept, hot)
+	}
+}
+
+func TestTinyLFUExpiration(t *testing.T) {
+	clock := NewFakeClock()
+	gc, err := New(8).TinyLFU().Clock(clock).Expiration(time.Second).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gc.Set("a", 1)
+	gc.SetWithExpire("b", 2, time.Minute)
+	clock.Advance(2 * time.Second)
+
+	if _, err := gc.Get("a"); err != KeyNotFoundError {
+		t.Errorf("err should be KeyNotFoundError, not %v", err)
+	}
+	if v, err := gc.Get("b"); err != nil || v != 2 {
+		t.Errorf("Get(b) = %v, %v", v, err)
+	}
+}
+
+func TestTinyLFUGetIFPresent(t *testing.T) {
+	testGetIFPresent(t, TYPE_TINYLFU)
+}
+
+func TestTinyLFUGetALL(t *testing.T) {
+	testGetALL(t, TYPE_TINYLFU)
+}
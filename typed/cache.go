@@ -0,0 +1,365 @@
+// Package typed provides a type-safe, generic front-end to gcache2.
+//
+// Caches built from this package are backed by the regular gcache2 eviction
+// policies; keys and values are checked at compile time instead of being
+// asserted out of interface{} at every call site. They are still stored as
+// interface{} underneath, so values that don't fit in a pointer are boxed on
+// Set and every call pays for a conversion, on top of the regular cache.
+package typed
+
+import (
+	"context"
+	"io"
+	"time"
+
+	gcache "github.com/aaronwinter/gcache2"
+)
+
+type Cache[K comparable, V any] interface {
+	Set(K, V) error
+	SetWithExpire(K, V, time.Duration) error
+	SetWithExpireAfterAccess(K, V, time.Duration) error
+	Get(K) (V, error)
+	GetContext(context.Context, K) (V, error)
+	GetIFPresent(K) (V, error)
+	GetMany([]K) (map[K]V, map[K]error)
+	GetALL() map[K]V
+	Remove(K) error
+	Purge()
+	Keys() []K
+	Len() int
+	Resize(int) error
+	Snapshot(io.Writer) error
+	Restore(io.Reader) error
+	Close() error
+
+	HitCount() uint64
+	MissCount() uint64
+	NegativeHitCount() uint64
+	LoadRetryCount() uint64
+	CircuitRejectCount() uint64
+	CircuitState() gcache.CircuitState
+	EarlyRefreshCount() uint64
+	LookupCount() uint64
+	HitRate() float64
+	Weight() int64
+}
+
+type (
+	LoaderFunc[K comparable, V any]        func(K) (V, error)
+	LoaderContextFunc[K comparable, V any] func(context.Context, K) (V, error)
+	LoaderExpireFunc[K comparable, V any]  func(K) (V, *time.Duration, error)
+	BatchLoaderFunc[K comparable, V any]   func(context.Context, []K) (map[K]V, error)
+	EvictedFunc[K comparable, V any]       func(K, V)
+	PurgeVisitorFunc[K comparable, V any]  func(K, V)
+	AddedFunc[K comparable, V any]         func(K, V)
+	RemovalListener[K comparable, V any]   func(K, V, gcache.RemovalCause)
+	Weigher[K comparable, V any]           func(K, V) int64
+)
+
+type CacheBuilder[K comparable, V any] struct {
+	cb *gcache.CacheBuilder
+}
+
+func New[K comparable, V any](capacity int) *CacheBuilder[K, V] {
+	return &CacheBuilder[K, V]{cb: gcache.New(capacity)}
+}
+
+func (b *CacheBuilder[K, V]) EvictType(tp string) *CacheBuilder[K, V] {
+	b.cb.EvictType(tp)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) Simple() *CacheBuilder[K, V] {
+	return b.EvictType(gcache.TYPE_SIMPLE)
+}
+
+func (b *CacheBuilder[K, V]) LRU() *CacheBuilder[K, V] {
+	return b.EvictType(gcache.TYPE_LRU)
+}
+
+func (b *CacheBuilder[K, V]) LFU() *CacheBuilder[K, V] {
+	return b.EvictType(gcache.TYPE_LFU)
+}
+
+func (b *CacheBuilder[K, V]) ARC() *CacheBuilder[K, V] {
+	return b.EvictType(gcache.TYPE_ARC)
+}
+
+func (b *CacheBuilder[K, V]) TinyLFU() *CacheBuilder[K, V] {
+	return b.EvictType(gcache.TYPE_TINYLFU)
+}
+
+func (b *CacheBuilder[K, V]) RR() *CacheBuilder[K, V] {
+	return b.EvictType(gcache.TYPE_RR)
+}
+
+// Set a loader function.
+// loaderFunc: create a new value with this function if cached value is expired.
+func (b *CacheBuilder[K, V]) LoaderFunc(loaderFunc LoaderFunc[K, V]) *CacheBuilder[K, V] {
+	b.cb.LoaderFunc(func(k interface{}) (interface{}, error) {
+		return loaderFunc(k.(K))
+	})
+	return b
+}
+
+// Set a context-aware loader function, see gcache.CacheBuilder.LoaderContextFunc.
+func (b *CacheBuilder[K, V]) LoaderContextFunc(loaderFunc LoaderContextFunc[K, V]) *CacheBuilder[K, V] {
+	b.cb.LoaderContextFunc(func(ctx context.Context, k interface{}) (interface{}, error) {
+		return loaderFunc(ctx, k.(K))
+	})
+	return b
+}
+
+// Set a loader function with expiration.
+// The returned time.Duration is used as the entry's expiration. If nil is returned instead,
+// the builder's Expiration applies, and the value never expires if none was set.
+func (b *CacheBuilder[K, V]) LoaderExpireFunc(loaderExpireFunc LoaderExpireFunc[K, V]) *CacheBuilder[K, V] {
+	b.cb.LoaderExpireFunc(func(k interface{}) (interface{}, *time.Duration, error) {
+		return loaderExpireFunc(k.(K))
+	})
+	return b
+}
+
+// Set a batch loader function, see gcache.CacheBuilder.BatchLoaderFunc.
+func (b *CacheBuilder[K, V]) BatchLoaderFunc(batchLoaderFunc BatchLoaderFunc[K, V]) *CacheBuilder[K, V] {
+	b.cb.BatchLoaderFunc(func(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+		typed := make([]K, len(keys))
+		for i, k := range keys {
+			typed[i] = k.(K)
+		}
+		loaded, err := batchLoaderFunc(ctx, typed)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[interface{}]interface{}, len(loaded))
+		for k, v := range loaded {
+			m[k] = v
+		}
+		return m, nil
+	})
+	return b
+}
+
+func (b *CacheBuilder[K, V]) EvictedFunc(evictedFunc EvictedFunc[K, V]) *CacheBuilder[K, V] {
+	b.cb.EvictedFunc(func(k, v interface{}) {
+		evictedFunc(k.(K), valueOf[V](v))
+	})
+	return b
+}
+
+func (b *CacheBuilder[K, V]) RemovalListener(listener RemovalListener[K, V]) *CacheBuilder[K, V] {
+	b.cb.RemovalListener(func(k, v interface{}, cause gcache.RemovalCause) {
+		listener(k.(K), valueOf[V](v), cause)
+	})
+	return b
+}
+
+func (b *CacheBuilder[K, V]) AsyncRemovalListener(listener RemovalListener[K, V], queueSize int) *CacheBuilder[K, V] {
+	b.cb.AsyncRemovalListener(func(k, v interface{}, cause gcache.RemovalCause) {
+		listener(k.(K), valueOf[V](v), cause)
+	}, queueSize)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) PurgeVisitorFunc(purgeVisitorFunc PurgeVisitorFunc[K, V]) *CacheBuilder[K, V] {
+	b.cb.PurgeVisitorFunc(func(k, v interface{}) {
+		purgeVisitorFunc(k.(K), valueOf[V](v))
+	})
+	return b
+}
+
+func (b *CacheBuilder[K, V]) AddedFunc(addedFunc AddedFunc[K, V]) *CacheBuilder[K, V] {
+	b.cb.AddedFunc(func(k, v interface{}) {
+		addedFunc(k.(K), valueOf[V](v))
+	})
+	return b
+}
+
+func (b *CacheBuilder[K, V]) Weigher(weigher Weigher[K, V]) *CacheBuilder[K, V] {
+	b.cb.Weigher(func(k, v interface{}) int64 {
+		return weigher(k.(K), valueOf[V](v))
+	})
+	return b
+}
+
+func (b *CacheBuilder[K, V]) MaxWeight(maxWeight int64) *CacheBuilder[K, V] {
+	b.cb.MaxWeight(maxWeight)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) Clock(clock gcache.Clock) *CacheBuilder[K, V] {
+	b.cb.Clock(clock)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) Expiration(expiration time.Duration) *CacheBuilder[K, V] {
+	b.cb.Expiration(expiration)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) ExpireAfterAccess(expiration time.Duration) *CacheBuilder[K, V] {
+	b.cb.ExpireAfterAccess(expiration)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) RefreshAfterWrite(refresh time.Duration) *CacheBuilder[K, V] {
+	b.cb.RefreshAfterWrite(refresh)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) CleanupInterval(interval time.Duration) *CacheBuilder[K, V] {
+	b.cb.CleanupInterval(interval)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) Shards(n int) *CacheBuilder[K, V] {
+	b.cb.Shards(n)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) BufferedReads() *CacheBuilder[K, V] {
+	b.cb.BufferedReads()
+	return b
+}
+
+func (b *CacheBuilder[K, V]) MemoryPressure(mp gcache.MemoryPressure) *CacheBuilder[K, V] {
+	b.cb.MemoryPressure(mp)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) Persistence(p gcache.Persistence) *CacheBuilder[K, V] {
+	b.cb.Persistence(p)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) WriteThrough(w gcache.CacheWriter) *CacheBuilder[K, V] {
+	b.cb.WriteThrough(w)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) WriteBehind(w gcache.CacheWriter, wb gcache.WriteBehind) *CacheBuilder[K, V] {
+	b.cb.WriteBehind(w, wb)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) NegativeCache(nc gcache.NegativeCache) *CacheBuilder[K, V] {
+	b.cb.NegativeCache(nc)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) StaleIfError(maxStale time.Duration) *CacheBuilder[K, V] {
+	b.cb.StaleIfError(maxStale)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) LoaderRetry(rp gcache.RetryPolicy) *CacheBuilder[K, V] {
+	b.cb.LoaderRetry(rp)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) CircuitBreaker(cbr gcache.CircuitBreaker) *CacheBuilder[K, V] {
+	b.cb.CircuitBreaker(cbr)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) EarlyRefresh(beta float64) *CacheBuilder[K, V] {
+	b.cb.EarlyRefresh(beta)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) SnapshotCodec(codec gcache.Codec) *CacheBuilder[K, V] {
+	b.cb.SnapshotCodec(codec)
+	return b
+}
+
+func (b *CacheBuilder[K, V]) Build() (Cache[K, V], error) {
+	c, err := b.cb.Build()
+	if err != nil {
+		return nil, err
+	}
+	return &cache[K, V]{Cache: c}, nil
+}
+
+// cache adapts an untyped gcache2 Cache to Cache[K, V].
+type cache[K comparable, V any] struct {
+	gcache.Cache
+}
+
+func (c *cache[K, V]) Set(key K, value V) error {
+	return c.Cache.Set(key, value)
+}
+
+func (c *cache[K, V]) SetWithExpire(key K, value V, expiration time.Duration) error {
+	return c.Cache.SetWithExpire(key, value, expiration)
+}
+
+func (c *cache[K, V]) SetWithExpireAfterAccess(key K, value V, expiration time.Duration) error {
+	return c.Cache.SetWithExpireAfterAccess(key, value, expiration)
+}
+
+func (c *cache[K, V]) Get(key K) (V, error) {
+	v, err := c.Cache.Get(key)
+	// v is only set with an error by a *gcache.StaleError.
+	return valueOf[V](v), err
+}
+
+func (c *cache[K, V]) GetContext(ctx context.Context, key K) (V, error) {
+	v, err := c.Cache.GetContext(ctx, key)
+	return valueOf[V](v), err
+}
+
+func (c *cache[K, V]) GetIFPresent(key K) (V, error) {
+	v, err := c.Cache.GetIFPresent(key)
+	return valueOf[V](v), err
+}
+
+func (c *cache[K, V]) GetMany(keys []K) (map[K]V, map[K]error) {
+	untyped := make([]interface{}, len(keys))
+	for i, k := range keys {
+		untyped[i] = k
+	}
+	values, errs := c.Cache.GetMany(untyped)
+
+	m := make(map[K]V, len(values))
+	for k, v := range values {
+		m[k.(K)] = valueOf[V](v)
+	}
+	e := make(map[K]error, len(errs))
+	for k, err := range errs {
+		e[k.(K)] = err
+	}
+	return m, e
+}
+
+func (c *cache[K, V]) GetALL() map[K]V {
+	all := c.Cache.GetALL()
+	m := make(map[K]V, len(all))
+	for k, v := range all {
+		m[k.(K)] = valueOf[V](v)
+	}
+	return m
+}
+
+func (c *cache[K, V]) Remove(key K) error {
+	return c.Cache.Remove(key)
+}
+
+func (c *cache[K, V]) Keys() []K {
+	all := c.Cache.Keys()
+	keys := make([]K, len(all))
+	for i, k := range all {
+		keys[i] = k.(K)
+	}
+	return keys
+}
+
+// valueOf converts a stored value back to V, mapping a nil interface to the
+// zero value so that interface-typed V never panics on assertion.
+func valueOf[V any](v interface{}) V {
+	if v == nil {
+		var zero V
+		return zero
+	}
+	return v.(V)
+}
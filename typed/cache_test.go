@@ -0,0 +1,219 @@
+package typed
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	gcache "github.com/aaronwinter/gcache2"
+)
+
+var evictTypes = []string{
+	gcache.TYPE_SIMPLE,
+	gcache.TYPE_LRU,
+	gcache.TYPE_LFU,
+	gcache.TYPE_ARC,
+	gcache.TYPE_TINYLFU,
+	gcache.TYPE_RR,
+}
+
+func TestTypedGetSet(t *testing.T) {
+	for _, tp := range evictTypes {
+		c, err := New[string, int](32).EvictType(tp).Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 10; i++ {
+			if err := c.Set(fmt.Sprintf("key-%d", i), i); err != nil {
+				t.Error(err)
+			}
+		}
+		for i := 0; i < 10; i++ {
+			v, err := c.Get(fmt.Sprintf("key-%d", i))
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tp, err)
+			}
+			if v != i {
+				t.Errorf("%s: %v != %v", tp, v, i)
+			}
+		}
+
+		if _, err := c.Get("missing"); err != gcache.KeyNotFoundError {
+			t.Errorf("%s: err should be KeyNotFoundError, not %v", tp, err)
+		}
+
+		all := c.GetALL()
+		if len(all) != 10 {
+			t.Errorf("%s: %v != %v", tp, len(all), 10)
+		}
+		if all["key-3"] != 3 {
+			t.Errorf("%s: %v != %v", tp, all["key-3"], 3)
+		}
+		if keys := c.Keys(); len(keys) != 10 {
+			t.Errorf("%s: %v != %v", tp, len(keys), 10)
+		}
+	}
+}
+
+func TestTypedLoaderFunc(t *testing.T) {
+	for _, tp := range evictTypes {
+		c, err := New[int, string](8).
+			EvictType(tp).
+			LoaderFunc(func(k int) (string, error) {
+				return fmt.Sprintf("value-%d", k), nil
+			}).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		v, err := c.Get(42)
+		if err != nil {
+			t.Error(err)
+		}
+		if v != "value-42" {
+			t.Errorf("%s: %v != %v", tp, v, "value-42")
+		}
+	}
+}
+
+func TestTypedLoaderExpireFunc(t *testing.T) {
+	for _, tp := range evictTypes {
+		c, err := New[int, int](8).
+			EvictType(tp).
+			LoaderExpireFunc(func(k int) (int, *time.Duration, error) {
+				return k * k, nil, nil
+			}).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		v, err := c.Get(3)
+		if err != nil {
+			t.Error(err)
+		}
+		if v != 9 {
+			t.Errorf("%s: %v != %v", tp, v, 9)
+		}
+	}
+}
+
+func TestTypedCallbacks(t *testing.T) {
+	for _, tp := range []string{gcache.TYPE_SIMPLE, gcache.TYPE_LRU, gcache.TYPE_LFU, gcache.TYPE_TINYLFU} {
+		evicted := map[string]int{}
+		added := map[string]int{}
+		purged := map[string]int{}
+		c, err := New[string, int](2).
+			EvictType(tp).
+			EvictedFunc(func(k string, v int) { evicted[k] = v }).
+			AddedFunc(func(k string, v int) { added[k] = v }).
+			PurgeVisitorFunc(func(k string, v int) { purged[k] = v }).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Set("a", 1)
+		c.Set("b", 2)
+		c.Set("c", 3)
+		if len(added) != 3 {
+			t.Errorf("%s: %v != %v", tp, len(added), 3)
+		}
+		if len(evicted) != 1 {
+			t.Errorf("%s: %v != %v", tp, len(evicted), 1)
+		}
+
+		c.Purge()
+		if len(purged) != 2 {
+			t.Errorf("%s: %v != %v", tp, len(purged), 2)
+		}
+	}
+}
+
+func TestTypedInterfaceValue(t *testing.T) {
+	c, err := New[string, error](8).LRU().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Set("nil", nil)
+	v, err := c.Get("nil")
+	if err != nil {
+		t.Error(err)
+	}
+	if v != nil {
+		t.Errorf("%v should be nil", v)
+	}
+}
+
+func TestTypedGetContext(t *testing.T) {
+	c, err := New[string, int](8).
+		LRU().
+		LoaderContextFunc(func(ctx context.Context, k string) (int, error) {
+			return len(k), ctx.Err()
+		}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := c.GetContext(context.Background(), "four")
+	if err != nil {
+		t.Error(err)
+	}
+	if v != 4 {
+		t.Errorf("%v != %v", v, 4)
+	}
+}
+
+func TestTypedGetMany(t *testing.T) {
+	c, err := New[int, string](8).
+		LRU().
+		BatchLoaderFunc(func(ctx context.Context, keys []int) (map[int]string, error) {
+			m := make(map[int]string)
+			for _, k := range keys {
+				if k > 0 {
+					m[k] = fmt.Sprint(k)
+				}
+			}
+			return m, nil
+		}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, errs := c.GetMany([]int{-1, 1, 2})
+	if len(values) != 2 || values[1] != "1" || values[2] != "2" {
+		t.Errorf("unexpected values %v", values)
+	}
+	if len(errs) != 1 || errs[-1] != gcache.KeyNotFoundError {
+		t.Errorf("unexpected errors %v", errs)
+	}
+}
+
+func TestTypedWeigher(t *testing.T) {
+	c, err := New[string, []byte](100).
+		LRU().
+		Weigher(func(k string, v []byte) int64 {
+			return int64(len(v))
+		}).
+		MaxWeight(64).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		c.Set(fmt.Sprint(i), make([]byte, 16))
+	}
+	if w := c.Weight(); w != 64 {
+		t.Errorf("%v != %v", w, 64)
+	}
+	if err := c.Set("big", make([]byte, 65)); err != gcache.EntryTooHeavyError {
+		t.Errorf("expected EntryTooHeavyError, got %v", err)
+	}
+}
@@ -0,0 +1,540 @@
+package gcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncPolicy tells when the write-ahead log is flushed to disk.
+type SyncPolicy int
+
+const (
+	// SyncAlways syncs the log after every mutation.
+	SyncAlways SyncPolicy = iota
+	// SyncPeriodic syncs the log every SyncInterval. A crash of the machine
+	// loses the mutations since the last sync.
+	SyncPeriodic
+	// SyncNever leaves it to the OS. Mutations survive a crash of the
+	// process, not of the machine.
+	SyncNever
+)
+
+// WAL configures the write-ahead log of Persistence. Zero fields take their
+// default value.
+type WAL struct {
+	// SegmentSize is the size above which the log moves to a new segment.
+	// Defaults to 16MB.
+	SegmentSize int64
+	// Sync defaults to SyncAlways.
+	Sync SyncPolicy
+	// SyncInterval is the time between two syncs with SyncPeriodic.
+	// Defaults to one second.
+	SyncInterval time.Duration
+	// CompactSegments is the number of segments above which a checkpoint is
+	// taken in the background, dropping the segments it covers. Defaults
+	// to 4.
+	CompactSegments int
+}
+
+func (w WAL) withDefaults() WAL {
+	if w.SegmentSize <= 0 {
+		w.SegmentSize = 16 << 20
+	}
+	if w.SyncInterval <= 0 {
+		w.SyncInterval = time.Second
+	}
+	if w.CompactSegments <= 0 {
+		w.CompactSegments = 4
+	}
+	return w
+}
+
+type walOp uint8
+
+const (
+	walSet walOp = iota
+	walRemove
+	walPurge
+)
+
+type walRecord struct {
+	Op    walOp
+	Key   interface{}
+	Value interface{}
+	// Expiration is the deadline of SetWithExpire.
+	Expiration *time.Time
+	AccessTTL  *time.Duration
+	// Shard is the shard out of Shards a Purge applied to.
+	Shard  int
+	Shards int
+}
+
+// apply replays the record on the shards of a cache, now being the time of
+// the replay.
+func (r *walRecord) apply(shards []Cache, now time.Time) {
+	if r.Op == walPurge {
+		if r.Shards == len(shards) {
+			shards[r.Shard].Purge()
+			return
+		}
+		for _, shard := range shards {
+			shard.Purge()
+		}
+		return
+	}
+
+	target := shards[hashKey(r.Key)%uint64(len(shards))].(replayTarget)
+	switch r.Op {
+	case walSet:
+		switch {
+		case r.Expiration != nil:
+			if ttl := r.Expiration.Sub(now); ttl > 0 {
+				target.replaySet(r.Key, r.Value, &ttl, nil)
+			} else {
+				target.replayRemove(r.Key)
+			}
+		default:
+			target.replaySet(r.Key, r.Value, nil, r.AccessTTL)
+		}
+	case walRemove:
+		target.replayRemove(r.Key)
+	}
+}
+
+// replayTarget applies the records to a cache without writing them through
+// the CacheWriter.
+type replayTarget interface {
+	replaySet(key, value interface{}, expiration, accessTTL *time.Duration)
+	replayRemove(key interface{})
+}
+
+func (c *baseCache) replaySet(key, value interface{}, expiration, accessTTL *time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loadGroup.cache.(entryStore).storeEntry(key, value, expiration, accessTTL)
+}
+
+func (c *baseCache) replayRemove(key interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loadGroup.cache.(entryStore).removeEntry(key)
+}
+
+// A segment is a sequence of frames, the length and CRC-32 of a record
+// encoded with the cache's codec, followed by the record. Records of a
+// segment share the same encoder.
+const walFrameHeader = 8
+
+// writeAheadLog appends the mutations of a cache to segments named after the
+// checkpoint file. Every checkpoint starts a new segment and drops the ones
+// it covers. Replaying a segment twice is harmless, so mutations racing
+// with a checkpoint may end up on both sides.
+type writeAheadLog struct {
+	path   string
+	config WAL
+	codec  Codec
+	clock  Clock
+	report func(error)
+	// compact is signalled when there are too many segments.
+	compact chan struct{}
+
+	mu       sync.Mutex
+	seq      uint64
+	f        *os.File
+	size     int64
+	segments int
+	buf      bytes.Buffer
+	enc      Encoder
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// walWriter is what a cache, or a shard, logs its mutations with.
+type walWriter struct {
+	log    *writeAheadLog
+	shard  int
+	shards int
+}
+
+func segmentPath(path string, seq uint64) string {
+	return fmt.Sprintf("%s.wal.%020d", path, seq)
+}
+
+// listSegments returns the sequence numbers of the segments of path, in
+// order.
+func listSegments(path string) ([]uint64, error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	prefix := base + ".wal."
+	var seqs []uint64
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimPrefix(e.Name(), prefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+// replayLog applies the segments of path to targets, the shards of the
+// cache, and returns the sequence number of the last one. report is called
+// with the segments that are corrupt, a torn record at the end of the last
+// segment is expected after a crash.
+func replayLog(path string, codec Codec, clock Clock, targets []Cache, report func(error)) (uint64, error) {
+	seqs, err := listSegments(path)
+	if err != nil {
+		return 0, err
+	}
+	for i, seq := range seqs {
+		err := replaySegment(segmentPath(path, seq), codec, clock, targets)
+		if err != nil && !(err == errTornFrame && i == len(seqs)-1) {
+			report(fmt.Errorf("gcache2: can't replay %s, %v: %w", segmentPath(path, seq), err, CorruptCheckpointError))
+		}
+	}
+	if len(seqs) == 0 {
+		return 0, nil
+	}
+	return seqs[len(seqs)-1], nil
+}
+
+func replaySegment(name string, codec Codec, clock Clock, targets []Cache) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fr := &frameReader{r: bufio.NewReader(f), remaining: info.Size()}
+	dec := codec.NewDecoder(fr)
+	for {
+		var r walRecord
+		if err := dec.Decode(&r); err != nil {
+			if fr.err != nil {
+				return fr.err
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		r.apply(targets, clock.Now())
+	}
+}
+
+var errTornFrame = errors.New("torn record")
+
+// frameReader reads the records of a segment as a stream, ending it at the
+// first frame that is torn or fails its checksum.
+type frameReader struct {
+	r         *bufio.Reader
+	remaining int64
+	buf       []byte
+	err       error
+}
+
+func (fr *frameReader) Read(p []byte) (int, error) {
+	for len(fr.buf) == 0 {
+		if fr.err != nil {
+			return 0, io.EOF
+		}
+		var h [walFrameHeader]byte
+		if _, err := io.ReadFull(fr.r, h[:]); err != nil {
+			if err != io.EOF {
+				fr.err = errTornFrame
+			}
+			return 0, io.EOF
+		}
+		fr.remaining -= walFrameHeader
+		n := int64(binary.BigEndian.Uint32(h[:4]))
+		if n > fr.remaining {
+			fr.err = errTornFrame
+			return 0, io.EOF
+		}
+		fr.remaining -= n
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(fr.r, payload); err != nil {
+			fr.err = errTornFrame
+			return 0, io.EOF
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(h[4:]) {
+			fr.err = fmt.Errorf("checksum mismatch")
+			return 0, io.EOF
+		}
+		fr.buf = payload
+	}
+	n := copy(p, fr.buf)
+	fr.buf = fr.buf[n:]
+	return n, nil
+}
+
+// openLog starts a new segment after seq.
+func openLog(path string, config WAL, codec Codec, clock Clock, seq uint64, report func(error)) (*writeAheadLog, error) {
+	segments, err := listSegments(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &writeAheadLog{
+		path:     path,
+		config:   config.withDefaults(),
+		codec:    codec,
+		clock:    clock,
+		report:   report,
+		compact:  make(chan struct{}, 1),
+		seq:      seq,
+		segments: len(segments),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := w.next(); err != nil {
+		return nil, err
+	}
+	if w.config.Sync == SyncPeriodic {
+		go w.run()
+	} else {
+		close(w.done)
+	}
+	return w, nil
+}
+
+func (w *writeAheadLog) run() {
+	defer close(w.done)
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-after(w.clock, w.config.SyncInterval):
+			w.mu.Lock()
+			if w.f != nil {
+				w.f.Sync()
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// next moves to a new segment. Called with the lock held. If the segment
+// can't be created, the log keeps appending to the current one, unless its
+// encoder failed.
+func (w *writeAheadLog) next() error {
+	f, err := os.OpenFile(segmentPath(w.path, w.seq+1), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	old := w.f
+	w.f = f
+	w.seq++
+	w.size = 0
+	w.segments++
+	w.buf.Reset()
+	w.enc = w.codec.NewEncoder(&w.buf)
+	if w.segments > w.config.CompactSegments {
+		select {
+		case w.compact <- struct{}{}:
+		default:
+		}
+	}
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// append logs r. It is a no-op once the log is closed.
+func (w *writeAheadLog) append(r *walRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return nil
+	}
+	if w.enc == nil {
+		if err := w.next(); err != nil {
+			return err
+		}
+	}
+	w.buf.Reset()
+	if err := w.enc.Encode(r); err != nil {
+		// The encoder may have sent type information along with r, which
+		// is lost with it: the records that follow need a new segment.
+		w.enc = nil
+		if err := w.next(); err != nil {
+			w.report(fmt.Errorf("gcache2: can't rotate the WAL: %w", err))
+		}
+		return err
+	}
+	payload := w.buf.Bytes()
+	frame := make([]byte, walFrameHeader+len(payload))
+	binary.BigEndian.PutUint32(frame[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:], crc32.ChecksumIEEE(payload))
+	copy(frame[walFrameHeader:], payload)
+	if _, err := w.f.Write(frame); err != nil {
+		return err
+	}
+	if w.config.Sync == SyncAlways {
+		if err := w.f.Sync(); err != nil {
+			return err
+		}
+	}
+
+	w.size += int64(len(frame))
+	if w.size >= w.config.SegmentSize {
+		// r is logged, the rotation is retried by the next append.
+		if err := w.next(); err != nil {
+			w.report(fmt.Errorf("gcache2: can't rotate the WAL: %w", err))
+		}
+	}
+	return nil
+}
+
+// rotate starts a new segment before a checkpoint, and returns the last
+// segment the checkpoint covers.
+func (w *writeAheadLog) rotate() (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return w.seq, nil
+	}
+	if err := w.f.Sync(); err != nil {
+		return 0, err
+	}
+	seq := w.seq
+	return seq, w.next()
+}
+
+// truncate drops the segments up to seq.
+func (w *writeAheadLog) truncate(seq uint64) error {
+	seqs, err := listSegments(w.path)
+	if err != nil {
+		return err
+	}
+	removed := 0
+	for _, s := range seqs {
+		if s > seq {
+			break
+		}
+		if err := os.Remove(segmentPath(w.path, s)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removed++
+	}
+
+	w.mu.Lock()
+	w.segments -= removed
+	w.mu.Unlock()
+	return nil
+}
+
+// compactions is nil-safe, for the checkpointer of a cache without a log.
+func (w *writeAheadLog) compactions() <-chan struct{} {
+	if w == nil {
+		return nil
+	}
+	return w.compact
+}
+
+func (w *writeAheadLog) close() error {
+	if w == nil {
+		return nil
+	}
+	select {
+	case <-w.stop:
+	default:
+		close(w.stop)
+	}
+	<-w.done
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Sync()
+	if e := w.f.Close(); err == nil {
+		err = e
+	}
+	w.f = nil
+	return err
+}
+
+// attachLog makes the cache log its mutations from now on.
+func (c *baseCache) attachLog(w *walWriter) {
+	c.wal = w
+}
+
+func (w *walWriter) append(r *walRecord) error {
+	if w == nil {
+		return nil
+	}
+	if err := w.log.append(r); err != nil {
+		return fmt.Errorf("gcache2: can't append to the WAL: %w", err)
+	}
+	return nil
+}
+
+// logSet logs a Set before it is applied, with the cache lock held.
+func (c *baseCache) logSet(key, value interface{}) error {
+	return c.wal.append(&walRecord{Op: walSet, Key: key, Value: value})
+}
+
+func (c *baseCache) logSetWithExpire(key, value interface{}, expiration time.Duration) error {
+	if c.wal == nil {
+		return nil
+	}
+	t := c.clock.Now().Add(expiration)
+	return c.wal.append(&walRecord{Op: walSet, Key: key, Value: value, Expiration: &t})
+}
+
+func (c *baseCache) logSetWithExpireAfterAccess(key, value interface{}, expiration time.Duration) error {
+	return c.wal.append(&walRecord{Op: walSet, Key: key, Value: value, AccessTTL: &expiration})
+}
+
+func (c *baseCache) logLoaded(key, value interface{}, expiration *time.Duration) error {
+	if expiration != nil {
+		return c.logSetWithExpire(key, value, *expiration)
+	}
+	return c.logSet(key, value)
+}
+
+func (c *baseCache) logRemove(key interface{}) error {
+	return c.wal.append(&walRecord{Op: walRemove, Key: key})
+}
+
+// logPurge reports its errors, as Purge can't return them.
+func (c *baseCache) logPurge() {
+	if c.wal == nil {
+		return
+	}
+	err := c.wal.append(&walRecord{Op: walPurge, Shard: c.wal.shard, Shards: c.wal.shards})
+	if err != nil {
+		c.wal.log.report(err)
+	}
+}
@@ -0,0 +1,280 @@
+package gcache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// buildLogged builds a cache logging to path. It is closed at the end of the
+// test, leaving it open in between is how a crash is simulated.
+func buildLogged(t *testing.T, cb *CacheBuilder, path string, wal WAL, onError func(error)) Cache {
+	gc := mustBuild(t, cb.Persistence(Persistence{Path: path, WAL: &wal, OnError: onError}))
+	t.Cleanup(func() { gc.Close() })
+	return gc
+}
+
+func segmentsOf(t *testing.T, path string) []uint64 {
+	seqs, err := listSegments(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seqs
+}
+
+func TestWALReplay(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		path := filepath.Join(t.TempDir(), "cache")
+		gc := buildLogged(t, New(16).EvictType(tp), path, WAL{}, nil)
+		gc.Set("a", 1)
+		gc.SetWithExpire("b", 2, time.Hour)
+		gc.SetWithExpireAfterAccess("c", 3, time.Hour)
+		gc.Set("d", 4)
+		gc.Remove("d")
+		gc.Set("a", 5)
+
+		rec := &errorRecorder{}
+		restored := buildLogged(t, New(16).EvictType(tp), path, WAL{}, rec.report)
+		if err := rec.last(); err != nil {
+			t.Errorf("%v: %v", tp, err)
+		}
+		for key, expected := range map[string]interface{}{"a": 5, "b": 2, "c": 3} {
+			if v, err := restored.Get(key); err != nil || v != expected {
+				t.Errorf("%v: %v should be %v, got %v, %v", tp, key, expected, v, err)
+			}
+		}
+		if has(restored, "d") {
+			t.Errorf("%v: d was removed", tp)
+		}
+	}
+}
+
+func TestWALOnTopOfCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	gc := mustBuild(t, New(16).LRU().Persistence(Persistence{Path: path, WAL: &WAL{}}))
+	gc.Set("a", 1)
+	gc.Set("b", 2)
+	if err := gc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if seqs := segmentsOf(t, path); len(seqs) != 1 {
+		t.Errorf("the checkpoint should drop the segments it covers, got %v", seqs)
+	}
+
+	gc = buildLogged(t, New(16).LRU(), path, WAL{}, nil)
+	gc.Remove("a")
+	gc.Set("c", 3)
+
+	restored := buildLogged(t, New(16).LRU(), path, WAL{}, nil)
+	if keys := restored.Len(); keys != 2 || !has(restored, "b") || !has(restored, "c") {
+		t.Errorf("b and c should be restored, got %v", restored.Keys())
+	}
+}
+
+func TestWALPurge(t *testing.T) {
+	for _, shards := range []int{0, 4} {
+		path := filepath.Join(t.TempDir(), "cache")
+		gc := buildLogged(t, New(64).LRU().Shards(shards), path, WAL{}, nil)
+		testSetCache(t, gc, 16)
+		gc.Purge()
+		gc.Set("a", 1)
+
+		for _, restoredShards := range []int{0, 4} {
+			restored := buildLogged(t, New(64).LRU().Shards(restoredShards), path, WAL{}, nil)
+			if keys := restored.Keys(); len(keys) != 1 || keys[0] != "a" {
+				t.Errorf("%v shards to %v shards: only a should be restored, got %v", shards, restoredShards, keys)
+			}
+		}
+	}
+}
+
+func TestWALExpiredWhileDown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	clock := NewFakeClock()
+	gc := mustBuild(t, New(16).LRU().Clock(clock).Persistence(Persistence{Path: path, WAL: &WAL{}}))
+	gc.Set("a", 1)
+	gc.Close()
+
+	gc = buildLogged(t, New(16).LRU().Clock(clock), path, WAL{}, nil)
+	gc.SetWithExpire("a", 2, time.Second)
+	gc.SetWithExpire("b", 2, time.Hour)
+
+	clock.Advance(time.Minute)
+	restored := buildLogged(t, New(16).LRU().Clock(clock), path, WAL{}, nil)
+	if has(restored, "a") {
+		t.Error("a expired while the cache was down, it should be removed")
+	}
+	if !has(restored, "b") {
+		t.Error("b should be restored")
+	}
+}
+
+func TestWALTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	gc := buildLogged(t, New(16).LRU(), path, WAL{}, nil)
+	gc.Set("a", 1)
+	gc.Set("b", 2)
+
+	seqs := segmentsOf(t, path)
+	name := segmentPath(path, seqs[len(seqs)-1])
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(name, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &errorRecorder{}
+	restored := buildLogged(t, New(16).LRU(), path, WAL{}, rec.report)
+	if err := rec.last(); err != nil {
+		t.Errorf("a torn tail should not be reported, got %v", err)
+	}
+	if !has(restored, "a") || has(restored, "b") {
+		t.Errorf("only a should be restored, got %v", restored.Keys())
+	}
+}
+
+func TestWALCorruptSegment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	gc := buildLogged(t, New(16).LRU(), path, WAL{}, nil)
+	gc.Set("a", 1)
+	gc.Set("b", 2)
+	// Make the corrupt segment not the last one.
+	buildLogged(t, New(16).LRU(), path, WAL{}, nil).Set("c", 3)
+
+	name := segmentPath(path, segmentsOf(t, path)[0])
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &errorRecorder{}
+	restored := buildLogged(t, New(16).LRU(), path, WAL{}, rec.report)
+	if !errors.Is(rec.last(), CorruptCheckpointError) {
+		t.Errorf("CorruptCheckpointError should be reported, got %v", rec.last())
+	}
+	if !has(restored, "a") || has(restored, "b") || !has(restored, "c") {
+		t.Errorf("the log should be replayed up to the corrupt record, got %v", restored.Keys())
+	}
+}
+
+func TestWALCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	wal := WAL{SegmentSize: 1, CompactSegments: 3}
+	gc := buildLogged(t, New(64).LRU(), path, wal, nil)
+	for i := 0; i < 20; i++ {
+		gc.Set(i, i)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(segmentsOf(t, path)) > 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the log should be compacted, got %v segments", len(segmentsOf(t, path)))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	restored := buildLogged(t, New(64).LRU(), path, wal, nil)
+	for i := 0; i < 20; i++ {
+		if v, err := restored.Get(i); err != nil || v != i {
+			t.Errorf("%v should be restored, got %v, %v", i, v, err)
+		}
+	}
+}
+
+func TestWALSyncPolicies(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncPeriodic, SyncNever} {
+		path := filepath.Join(t.TempDir(), "cache")
+		wal := WAL{Sync: policy, SyncInterval: time.Millisecond}
+		gc := buildLogged(t, New(16).LRU(), path, wal, nil)
+		for i := 0; i < 8; i++ {
+			gc.Set(fmt.Sprint(i), i)
+		}
+
+		restored := buildLogged(t, New(16).LRU(), path, wal, nil)
+		if l := restored.Len(); l != 8 {
+			t.Errorf("policy %v: Len should be 8, not %v", policy, l)
+		}
+	}
+}
+
+func TestWALRotationFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	rec := &errorRecorder{}
+	gc := buildLogged(t, New(16).LRU(), path, WAL{SegmentSize: 1, CompactSegments: 1 << 20}, rec.report)
+
+	seqs := segmentsOf(t, path)
+	blocker := segmentPath(path, seqs[len(seqs)-1]+1)
+	if err := os.Mkdir(blocker, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if err := gc.Set(key, 1); err != nil {
+			t.Errorf("a logged write should not fail with its rotation, got %v", err)
+		}
+	}
+	if rec.last() == nil {
+		t.Error("the failed rotation should be reported")
+	}
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	gc.Set("c", 1)
+
+	restored := buildLogged(t, New(16).LRU(), path, WAL{}, nil)
+	if l := restored.Len(); l != 3 {
+		t.Errorf("every write should be logged, got %v", restored.Keys())
+	}
+}
+
+type unregisteredValue struct{ N int }
+
+func TestWALEncodeFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	gc := buildLogged(t, New(16).LRU(), path, WAL{}, nil)
+	if err := gc.Set("bad", unregisteredValue{1}); err == nil {
+		t.Fatal("a value the codec can't encode should be rejected")
+	}
+	gc.Set("a", 1)
+	gc.Set("b", "x")
+
+	rec := &errorRecorder{}
+	restored := buildLogged(t, New(16).LRU(), path, WAL{}, rec.report)
+	if err := rec.last(); err != nil {
+		t.Error(err)
+	}
+	for key, expected := range map[string]interface{}{"a": 1, "b": "x"} {
+		if v, err := restored.Get(key); err != nil || v != expected {
+			t.Errorf("%v should be %v, got %v, %v", key, expected, v, err)
+		}
+	}
+}
+
+func TestWALRestore(t *testing.T) {
+	for _, shards := range []int{0, 4} {
+		src := mustBuild(t, New(16).LRU())
+		src.Set("b", 2)
+		snapshot := snapshotOf(t, src)
+
+		path := filepath.Join(t.TempDir(), "cache")
+		gc := buildLogged(t, New(16).LRU().Shards(shards), path, WAL{}, nil)
+		gc.Set("a", 1)
+		if err := gc.Restore(snapshot); err != nil {
+			t.Fatal(err)
+		}
+
+		restored := buildLogged(t, New(16).LRU().Shards(shards), path, WAL{}, nil)
+		if restored.Len() != 1 || !has(restored, "b") {
+			t.Errorf("shards %v: the restored entries should survive a crash, got %v", shards, restored.Keys())
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package gcache
+
+// weighted is implemented by the caches to make room for heavy entries.
+type weighted interface {
+	// weightOf returns the weight of the entry stored under key, if any.
+	weightOf(key interface{}) int64
+	// evictOne evicts an entry according to the cache's policy and reports
+	// false when there was nothing left to evict.
+	evictOne(cause RemovalCause) bool
+}
+
+// weigh returns the weight of a value about to be stored, rejecting values
+// that could never fit in MaxWeight.
+func (c *baseCache) weigh(key, value interface{}) (int64, error) {
+	if c.weigher == nil {
+		return 0, nil
+	}
+	w := c.weigher(key, value)
+	if c.maxWeight > 0 && w > c.maxWeight {
+		return 0, EntryTooHeavyError
+	}
+	return w, nil
+}
+
+// prepare serializes value and weighs it, so that an entry the cache can't
+// take is rejected before it is written through or logged.
+func (c *baseCache) prepare(key, value interface{}) (interface{}, int64, error) {
+	if c.serializeFunc != nil {
+		var err error
+		value, err = c.serializeFunc(key, value)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	w, err := c.weigh(key, value)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, w, nil
+}
+
+// makeRoom evicts entries until key can be stored with weight w without
+// exceeding MaxWeight. The entry currently stored under key may be evicted
+// as well, if the policy picks it.
+func (c *baseCache) makeRoom(cache weighted, key interface{}, w int64) {
+	if c.maxWeight <= 0 {
+		return
+	}
+	for c.Weight()+w-cache.weightOf(key) > c.maxWeight {
+		if !cache.evictOne(RemovalSize) {
+			return
+		}
+	}
+}
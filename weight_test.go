@@ -0,0 +1,118 @@
+package gcache
+
+import (
+	"testing"
+)
+
+func intWeigher(key, value interface{}) int64 {
+	return int64(value.(int))
+}
+
+func TestWeightedEviction(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		gc, err := New(1000).
+			EvictType(tp).
+			Weigher(intWeigher).
+			MaxWeight(100).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 20; i++ {
+			if err := gc.Set(i, 20); err != nil {
+				t.Fatalf("%s: %v", tp, err)
+			}
+			if w := gc.Weight(); w > 100 {
+				t.Fatalf("%s: weight %v exceeds the maximum", tp, w)
+			}
+		}
+		if w, l := gc.Weight(), gc.Len(); w != 100 || l != 5 {
+			t.Errorf("%s: expected 5 entries weighing 100, got %v weighing %v", tp, l, w)
+		}
+		if _, err := gc.GetIFPresent(19); err != nil {
+			t.Errorf("%s: the last entry should be cached: %v", tp, err)
+		}
+	}
+}
+
+func TestWeightedLRU(t *testing.T) {
+	gc, err := New(10).LRU().Weigher(intWeigher).MaxWeight(100).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gc.Set("a", 40)
+	gc.Set("b", 40)
+	gc.Get("a")
+	gc.Set("c", 50)
+
+	if _, err := gc.GetIFPresent("b"); err != KeyNotFoundError {
+		t.Error("b should have been evicted")
+	}
+	if w := gc.Weight(); w != 90 {
+		t.Errorf("%v != %v", w, 90)
+	}
+}
+
+func TestWeightAccounting(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		gc, err := New(10).EvictType(tp).Weigher(intWeigher).MaxWeight(100).Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := gc.Set("heavy", 101); err != EntryTooHeavyError {
+			t.Errorf("%s: expected EntryTooHeavyError, got %v", tp, err)
+		}
+		if _, err := gc.GetIFPresent("heavy"); err != KeyNotFoundError {
+			t.Errorf("%s: a rejected entry should not be cached", tp)
+		}
+
+		gc.Set("a", 10)
+		gc.Set("a", 30)
+		gc.Set("b", 5)
+		if w := gc.Weight(); w != 35 {
+			t.Errorf("%s: %v != %v", tp, w, 35)
+		}
+
+		// Growing an entry evicts others to make room.
+		gc.Set("b", 90)
+		if w := gc.Weight(); w > 100 {
+			t.Errorf("%s: weight %v exceeds the maximum", tp, w)
+		}
+
+		gc.Remove("b")
+		gc.Remove("a")
+		if w := gc.Weight(); w != 0 {
+			t.Errorf("%s: %v != %v", tp, w, 0)
+		}
+
+		gc.Set("c", 50)
+		gc.Purge()
+		if w := gc.Weight(); w != 0 {
+			t.Errorf("%s: %v != %v after Purge", tp, w, 0)
+		}
+	}
+}
+
+func TestWeightBuildErrors(t *testing.T) {
+	if _, err := New(10).MaxWeight(100).Build(); err == nil {
+		t.Error("MaxWeight without a Weigher should fail")
+	}
+	if _, err := New(10).Weigher(intWeigher).MaxWeight(-1).Build(); err == nil {
+		t.Error("a negative MaxWeight should fail")
+	}
+}
+
+func TestShardedWeight(t *testing.T) {
+	gc, err := New(100).LRU().Shards(4).Weigher(intWeigher).MaxWeight(400).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		gc.Set(i, 10)
+	}
+	if w := gc.Weight(); w > 400 || w < 300 {
+		t.Errorf("unexpected weight %v", w)
+	}
+}
@@ -0,0 +1,235 @@
+package gcache
+
+import (
+	"sync"
+	"time"
+)
+
+// CacheWriter writes the entries set and removed through a cache to its
+// backing store, see CacheBuilder.WriteThrough and CacheBuilder.WriteBehind.
+// Values loaded by a loader are never written.
+type CacheWriter interface {
+	Write(key, value interface{}) error
+	Delete(key interface{}) error
+	WriteAll(entries map[interface{}]interface{}) error
+	DeleteAll(keys []interface{}) error
+}
+
+// WriteBehind configures the asynchronous flushes of a CacheWriter. Zero
+// fields take their default value.
+type WriteBehind struct {
+	// Window is how long writes are coalesced before being flushed.
+	// Defaults to one second.
+	Window time.Duration
+	// MaxBatch flushes before the end of the window once that many keys
+	// are pending. Defaults to 1000.
+	MaxBatch int
+	// Retries is the number of times a failed flush is retried. Defaults
+	// to 3.
+	Retries int
+	// Backoff is the time before the first retry, doubled for every other
+	// one. Defaults to 100ms.
+	Backoff time.Duration
+	// OnError is called with the flushes given up on.
+	OnError func(error)
+}
+
+func (wb WriteBehind) withDefaults() WriteBehind {
+	if wb.Window <= 0 {
+		wb.Window = time.Second
+	}
+	if wb.MaxBatch <= 0 {
+		wb.MaxBatch = 1000
+	}
+	if wb.Retries <= 0 {
+		wb.Retries = 3
+	}
+	if wb.Backoff <= 0 {
+		wb.Backoff = 100 * time.Millisecond
+	}
+	return wb
+}
+
+// pendingWrite is the last mutation of a key waiting to be flushed.
+type pendingWrite struct {
+	value   interface{}
+	deleted bool
+}
+
+// writeBehind flushes the mutations of a cache to a CacheWriter in the
+// background, only keeping the last one of every key.
+type writeBehind struct {
+	writer CacheWriter
+	config WriteBehind
+	clock  Clock
+
+	mu      sync.Mutex
+	pending map[interface{}]pendingWrite
+	// full is signalled when MaxBatch keys are pending.
+	full chan struct{}
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+func newWriteBehind(writer CacheWriter, config WriteBehind, clock Clock) *writeBehind {
+	wb := &writeBehind{
+		writer:  writer,
+		config:  config.withDefaults(),
+		clock:   clock,
+		pending: make(map[interface{}]pendingWrite),
+		full:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go wb.run()
+	return wb
+}
+
+func (wb *writeBehind) run() {
+	defer close(wb.done)
+	var window <-chan time.Time
+	for {
+		if window == nil {
+			window = after(wb.clock, wb.config.Window)
+		}
+		select {
+		case <-wb.stop:
+			wb.err = wb.flush()
+			return
+		case <-window:
+			window = nil
+		case <-wb.full:
+		}
+		if err := wb.flush(); err != nil && wb.config.OnError != nil {
+			wb.config.OnError(err)
+		}
+	}
+}
+
+// add queues a mutation, it is a no-op on a nil writeBehind.
+func (wb *writeBehind) add(key interface{}, w pendingWrite) {
+	if wb == nil {
+		return
+	}
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	wb.pending[key] = w
+	if len(wb.pending) >= wb.config.MaxBatch {
+		select {
+		case wb.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// flush writes the pending mutations, retrying the batches that fail.
+func (wb *writeBehind) flush() error {
+	wb.mu.Lock()
+	pending := wb.pending
+	wb.pending = make(map[interface{}]pendingWrite)
+	wb.mu.Unlock()
+
+	writes := make(map[interface{}]interface{})
+	var deletes []interface{}
+	for key, w := range pending {
+		if w.deleted {
+			deletes = append(deletes, key)
+		} else {
+			writes[key] = w.value
+		}
+	}
+
+	var err error
+	if len(writes) > 0 {
+		err = wb.retry(func() error { return wb.writer.WriteAll(writes) })
+	}
+	if len(deletes) > 0 {
+		if e := wb.retry(func() error { return wb.writer.DeleteAll(deletes) }); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (wb *writeBehind) retry(fn func() error) error {
+	backoff := wb.config.Backoff
+	err := fn()
+	for i := 0; err != nil && i < wb.config.Retries; i++ {
+		<-after(wb.clock, backoff)
+		backoff *= 2
+		err = fn()
+	}
+	return err
+}
+
+// close flushes the pending mutations and stops the background flushes.
+func (wb *writeBehind) close() error {
+	if wb == nil {
+		return nil
+	}
+	wb.once.Do(func() {
+		close(wb.stop)
+		<-wb.done
+	})
+	return wb.err
+}
+
+// beforeSet must be called by Set with the cache lock held, once prepare
+// accepted the entry and before the mutation. A write-through error rejects
+// it.
+func (c *baseCache) beforeSet(key, value interface{}) error {
+	if err := c.writeThrough(key, value); err != nil {
+		return err
+	}
+	if err := c.logSet(key, value); err != nil {
+		return err
+	}
+	c.behind.add(key, pendingWrite{value: value})
+	return nil
+}
+
+func (c *baseCache) beforeSetWithExpire(key, value interface{}, expiration time.Duration) error {
+	if err := c.writeThrough(key, value); err != nil {
+		return err
+	}
+	if err := c.logSetWithExpire(key, value, expiration); err != nil {
+		return err
+	}
+	c.behind.add(key, pendingWrite{value: value})
+	return nil
+}
+
+func (c *baseCache) beforeSetWithExpireAfterAccess(key, value interface{}, expiration time.Duration) error {
+	if err := c.writeThrough(key, value); err != nil {
+		return err
+	}
+	if err := c.logSetWithExpireAfterAccess(key, value, expiration); err != nil {
+		return err
+	}
+	c.behind.add(key, pendingWrite{value: value})
+	return nil
+}
+
+func (c *baseCache) beforeRemove(key interface{}) error {
+	if c.writer != nil {
+		if err := c.writer.Delete(key); err != nil {
+			return err
+		}
+	}
+	if err := c.logRemove(key); err != nil {
+		return err
+	}
+	c.behind.add(key, pendingWrite{deleted: true})
+	return nil
+}
+
+func (c *baseCache) writeThrough(key, value interface{}) error {
+	if c.writer == nil {
+		return nil
+	}
+	return c.writer.Write(key, value)
+}
@@ -0,0 +1,289 @@
+package gcache
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// storeWriter is a CacheWriter backed by a map, failing the next fail calls.
+type storeWriter struct {
+	mu      sync.Mutex
+	store   map[interface{}]interface{}
+	batches int
+	fail    int
+}
+
+func newStoreWriter() *storeWriter {
+	return &storeWriter{store: make(map[interface{}]interface{})}
+}
+
+var errStore = errors.New("store unavailable")
+
+func (w *storeWriter) failing() error {
+	if w.fail > 0 {
+		w.fail--
+		return errStore
+	}
+	return nil
+}
+
+func (w *storeWriter) Write(key, value interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.failing(); err != nil {
+		return err
+	}
+	w.store[key] = value
+	return nil
+}
+
+func (w *storeWriter) Delete(key interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.failing(); err != nil {
+		return err
+	}
+	delete(w.store, key)
+	return nil
+}
+
+func (w *storeWriter) WriteAll(entries map[interface{}]interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.failing(); err != nil {
+		return err
+	}
+	w.batches++
+	for k, v := range entries {
+		w.store[k] = v
+	}
+	return nil
+}
+
+func (w *storeWriter) DeleteAll(keys []interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.failing(); err != nil {
+		return err
+	}
+	w.batches++
+	for _, k := range keys {
+		delete(w.store, k)
+	}
+	return nil
+}
+
+func (w *storeWriter) get(key interface{}) (interface{}, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	v, ok := w.store[key]
+	return v, ok
+}
+
+func (w *storeWriter) setFail(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.fail = n
+}
+
+func (w *storeWriter) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.store)
+}
+
+func TestWriteThrough(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		w := newStoreWriter()
+		gc := mustBuild(t, New(8).EvictType(tp).
+			LoaderFunc(func(key interface{}) (interface{}, error) {
+				return "loaded", nil
+			}).
+			WriteThrough(w))
+
+		gc.Set("a", 1)
+		gc.SetWithExpire("b", 2, time.Hour)
+		gc.SetWithExpireAfterAccess("c", 3, time.Hour)
+		for key, expected := range map[string]interface{}{"a": 1, "b": 2, "c": 3} {
+			if v, ok := w.get(key); !ok || v != expected {
+				t.Errorf("%v: %v should be written as %v, got %v", tp, key, expected, v)
+			}
+		}
+
+		gc.Remove("a")
+		if _, ok := w.get("a"); ok {
+			t.Errorf("%v: a should be deleted", tp)
+		}
+
+		gc.Get("d")
+		gc.GetMany([]interface{}{"e"})
+		if _, ok := w.get("d"); ok || w.len() != 2 {
+			t.Errorf("%v: loaded values should not be written", tp)
+		}
+	}
+}
+
+func TestWriteThroughRejects(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		w := newStoreWriter()
+		gc := mustBuild(t, New(8).EvictType(tp).WriteThrough(w))
+		gc.Set("a", 1)
+
+		w.setFail(1)
+		if err := gc.Set("a", 2); err != errStore {
+			t.Errorf("%v: Set should fail with the writer error, got %v", tp, err)
+		}
+		if v, _ := gc.Get("a"); v != 1 {
+			t.Errorf("%v: a failed write should leave the cache untouched, got %v", tp, v)
+		}
+
+		w.setFail(1)
+		if err := gc.Remove("a"); err != errStore {
+			t.Errorf("%v: Remove should fail with the writer error, got %v", tp, err)
+		}
+		if !has(gc, "a") {
+			t.Errorf("%v: a failed delete should leave the cache untouched", tp)
+		}
+	}
+}
+
+func TestWriteThroughRejectedEntry(t *testing.T) {
+	for _, tp := range allEvictTypes {
+		w := newStoreWriter()
+		gc := mustBuild(t, New(8).EvictType(tp).
+			Weigher(intWeigher).
+			MaxWeight(10).
+			WriteThrough(w))
+
+		if err := gc.Set("a", 11); err != EntryTooHeavyError {
+			t.Errorf("%v: err should be EntryTooHeavyError, not %v", tp, err)
+		}
+		if err := gc.SetWithExpire("b", 11, time.Minute); err != EntryTooHeavyError {
+			t.Errorf("%v: err should be EntryTooHeavyError, not %v", tp, err)
+		}
+		if len(w.store) != 0 {
+			t.Errorf("%v: entries the cache rejects should not be written through, got %v", tp, w.store)
+		}
+	}
+}
+
+func TestWriteBehindCoalesces(t *testing.T) {
+	clock := NewFakeClock()
+	w := newStoreWriter()
+	w.store["b"] = "stale"
+	gc := mustBuild(t, New(8).LRU().Clock(clock).WriteBehind(w, WriteBehind{Window: time.Second}))
+	defer gc.Close()
+
+	gc.Set("a", 1)
+	gc.Set("a", 2)
+	gc.Remove("b")
+	gc.Set("c", 3)
+	if v, _ := w.get("a"); v != nil {
+		t.Errorf("writes should wait for the end of the window, a is %v", v)
+	}
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+	if v, _ := w.get("a"); v != 2 {
+		t.Errorf("a should be flushed with its last value, got %v", v)
+	}
+	if _, ok := w.get("b"); ok {
+		t.Error("b should be deleted")
+	}
+	if w.batches != 2 {
+		t.Errorf("the window should be flushed in one WriteAll and one DeleteAll, got %v batches", w.batches)
+	}
+}
+
+func TestWriteBehindMaxBatch(t *testing.T) {
+	w := newStoreWriter()
+	gc := mustBuild(t, New(8).LRU().WriteBehind(w, WriteBehind{Window: time.Hour, MaxBatch: 3}))
+	defer gc.Close()
+	for i := 0; i < 3; i++ {
+		gc.Set(i, i)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for w.len() != 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("a full batch should be flushed before the end of the window")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWriteBehindRetry(t *testing.T) {
+	w := newStoreWriter()
+	gc := mustBuild(t, New(8).LRU().WriteBehind(w, WriteBehind{Window: time.Hour, Retries: 2, Backoff: time.Millisecond}))
+
+	w.setFail(2)
+	gc.Set("a", 1)
+	if err := gc.Close(); err != nil {
+		t.Fatalf("the flush should succeed on its last retry, got %v", err)
+	}
+	if v, _ := w.get("a"); v != 1 {
+		t.Errorf("a should be flushed on Close, got %v", v)
+	}
+
+	w.setFail(3)
+	gc = mustBuild(t, New(8).LRU().WriteBehind(w, WriteBehind{Window: time.Hour, Retries: 2, Backoff: time.Millisecond}))
+	gc.Set("b", 1)
+	if err := gc.Close(); err != errStore {
+		t.Errorf("Close should report the flush given up on, got %v", err)
+	}
+}
+
+func TestWriteBehindOnError(t *testing.T) {
+	rec := &errorRecorder{}
+	w := newStoreWriter()
+	w.setFail(1 << 30)
+	gc := mustBuild(t, New(8).LRU().WriteBehind(w, WriteBehind{
+		Window:  time.Millisecond,
+		Retries: 1,
+		Backoff: time.Millisecond,
+		OnError: rec.report,
+	}))
+	defer gc.Close()
+	gc.Set("a", 1)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for rec.last() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("OnError should be called once the retries are exhausted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := rec.last(); err != errStore {
+		t.Errorf("OnError should get the writer error, got %v", err)
+	}
+}
+
+func TestWriterNotCalledOnReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	gc := buildLogged(t, New(8).LRU().WriteThrough(newStoreWriter()), path, WAL{}, nil)
+	gc.Set("a", 1)
+	gc.SetWithExpire("b", 2, time.Hour)
+	gc.Remove("a")
+
+	w := newStoreWriter()
+	restored := buildLogged(t, New(8).LRU().WriteThrough(w), path, WAL{}, nil)
+	if !has(restored, "b") {
+		t.Fatal("b should be replayed")
+	}
+	if w.len() != 0 {
+		t.Errorf("the replayed records should not be written through, got %v", w.store)
+	}
+
+	w = newStoreWriter()
+	restored = buildLogged(t, New(8).LRU().WriteBehind(w, WriteBehind{}), path, WAL{}, nil)
+	if err := restored.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.len() != 0 || w.batches != 0 {
+		t.Errorf("the replayed records should not be queued, got %v in %v batches", w.store, w.batches)
+	}
+}